@@ -17,7 +17,7 @@ const (
 3. 如果页面未加载，最多连续调用wait三次
 4. 如果找不到目标内容，可以调用swipe滑动查找
 5. 遇到价格区间、时间区间等筛选条件，如果没有完全符合的，可以放宽要求
-6. 在执行下一步操作前请一定要检查上一步的操作是否生效
+6. 在执行下一步操作前请一定要检查上一步的操作是否生效，工具结果中的 screen_changed 和 app_changed 表示操作后屏幕和当前app是否发生了变化
 7. 如果滑动不生效，请调整起始点位置，增大滑动距离重试
 8. 完成任务后，必须调用finish_task结束
 9. 在结束任务前请一定要仔细检查任务是否完整准确的完成
@@ -44,7 +44,7 @@ Important rules:
 3. If page is not loaded, call wait up to 3 times
 4. If target content not found, call swipe to search
 5. For price ranges or time ranges, relax requirements if exact match not found
-6. Before next action, verify previous action took effect
+6. Before next action, verify previous action took effect; screen_changed and app_changed in the tool result tell whether the screen and current app changed after it
 7. If swipe doesn't work, adjust start position and increase swipe distance
 8. After completing task, must call finish_task to end
 9. Before finishing, carefully verify task is completed accurately
@@ -54,4 +54,24 @@ For each response:
 - Then call ONE tool function to execute the action
 - Only call one tool function per response
 `
+
+	SummaryPrompt_ZH = `请将以下手机操作助手的历史记录压缩成一段简洁的"目前进展"，供助手继续完成任务时参考。
+要求：
+- 说明已经完成了哪些操作、到达了哪些页面
+- 保留对后续步骤有用的关键信息（搜索词、已选条件、已记录的内容、失败过的尝试等）
+- 不要编造历史记录中没有的内容
+- 只输出总结本身，不要调用任何工具`
+
+	SummaryPrompt_EN = `Compress the following history of a phone operation agent into a concise "progress so far" summary that the agent will use to continue the task.
+Requirements:
+- State which actions have been completed and which screens were reached
+- Keep key facts useful for the next steps (search terms, selected filters, recorded content, failed attempts, etc.)
+- Do not invent anything that is not in the history
+- Output only the summary itself, do not call any tool`
+
+	CallAPIPrompt_ZH = `你是手机操作助手的内容分析模块。请根据用户的指令，结合当前屏幕截图和已记录的笔记，给出准确、简洁的总结或评论。
+只输出结果本身，不要编造截图和笔记中没有的信息。`
+
+	CallAPIPrompt_EN = `You are the content analysis module of a phone operation agent. Following the instruction, use the current screenshot and the recorded notes to produce an accurate, concise summary or comment.
+Output only the result itself and do not invent information that is not in the screenshot or the notes.`
 )
@@ -0,0 +1,226 @@
+// Package adb is a client for the adb server's smart-socket protocol, used by
+// ADBDevice instead of spawning the adb binary for every command.
+//
+// Every request is a 4-digit hex length followed by the service name; the server
+// answers OKAY or FAIL followed by a length-prefixed message. Device services
+// (shell:, exec:, ...) are reached by first switching the connection to a device
+// with host:transport:<serial>.
+package adb
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// DefaultServerAddr is the address of a local adb server.
+const DefaultServerAddr = "127.0.0.1:5037"
+
+// Client talks to one adb server. The zero value uses DefaultServerAddr.
+type Client struct {
+	// Addr is the adb server address (host:port).
+	Addr string
+}
+
+// NewClient creates a client for the adb server at addr, DefaultServerAddr when empty.
+func NewClient(addr string) *Client {
+	return &Client{Addr: addr}
+}
+
+// Error is a FAIL answer from the adb server.
+type Error struct {
+	Service string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("adb %s: %s", e.Service, e.Message)
+}
+
+// conn is one connection to the adb server. The server closes it after a host
+// service; after host:transport it is bound to a device.
+type conn struct {
+	net.Conn
+	r    *bufio.Reader
+	stop func() bool
+}
+
+func (c *Client) dial(ctx context.Context) (*conn, error) {
+	addr := c.Addr
+	if addr == "" {
+		addr = DefaultServerAddr
+	}
+	var d net.Dialer
+	nc, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("connect to adb server %s: %w", addr, err)
+	}
+	// Unblock pending reads and writes when ctx is done
+	stop := context.AfterFunc(ctx, func() { _ = nc.Close() })
+	return &conn{Conn: nc, r: bufio.NewReader(nc), stop: stop}, nil
+}
+
+func (c *conn) Close() error {
+	c.stop()
+	return c.Conn.Close()
+}
+
+// request sends a service request and waits for its OKAY.
+func (c *conn) request(service string) error {
+	if _, err := fmt.Fprintf(c.Conn, "%04x%s", len(service), service); err != nil {
+		return err
+	}
+	return c.readStatus(service)
+}
+
+func (c *conn) readStatus(service string) error {
+	status := make([]byte, 4)
+	if _, err := io.ReadFull(c.r, status); err != nil {
+		return fmt.Errorf("adb %s: read status: %w", service, err)
+	}
+	switch string(status) {
+	case "OKAY":
+		return nil
+	case "FAIL":
+		message, err := c.readString()
+		if err != nil {
+			return fmt.Errorf("adb %s: read failure: %w", service, err)
+		}
+		return &Error{Service: service, Message: message}
+	default:
+		return fmt.Errorf("adb %s: unexpected status %q", service, status)
+	}
+}
+
+// readString reads a 4-digit hex length prefixed string.
+func (c *conn) readString() (string, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(c.r, header); err != nil {
+		return "", err
+	}
+	n, err := strconv.ParseUint(string(header), 16, 16)
+	if err != nil {
+		return "", fmt.Errorf("invalid length %q", header)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// readAll reads the rest of the stream, ignoring the close caused by ctx afterwards.
+func (c *conn) readAll(ctx context.Context) ([]byte, error) {
+	data, err := io.ReadAll(c.r)
+	if ctx.Err() != nil {
+		return data, ctx.Err()
+	}
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		return data, err
+	}
+	return data, nil
+}
+
+// HostCommand runs a host service (host:version, host:connect:<addr>, ...) and returns its answer.
+func (c *Client) HostCommand(ctx context.Context, service string) (string, error) {
+	cn, err := c.dial(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer cn.Close()
+
+	if err := cn.request(service); err != nil {
+		return "", err
+	}
+	return cn.readString()
+}
+
+// DeviceEntry is one line of host:devices-l.
+type DeviceEntry struct {
+	Serial string
+	State  string
+	// Attrs holds the key:value attributes (product, model, device, transport_id).
+	Attrs map[string]string
+}
+
+// Devices lists the devices known to the server (host:devices-l).
+func (c *Client) Devices(ctx context.Context) ([]DeviceEntry, error) {
+	output, err := c.HostCommand(ctx, "host:devices-l")
+	if err != nil {
+		return nil, err
+	}
+	return ParseDevices(output), nil
+}
+
+// ParseDevices parses the output of host:devices-l or `adb devices -l`.
+func ParseDevices(output string) []DeviceEntry {
+	var devices []DeviceEntry
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "List of devices") || strings.HasPrefix(line, "*") {
+			continue
+		}
+		parts := strings.Fields(line)
+		if len(parts) < 2 {
+			continue
+		}
+		entry := DeviceEntry{Serial: parts[0], State: parts[1], Attrs: map[string]string{}}
+		for _, part := range parts[2:] {
+			if key, value, ok := strings.Cut(part, ":"); ok {
+				entry.Attrs[key] = value
+			}
+		}
+		devices = append(devices, entry)
+	}
+	return devices
+}
+
+// transport opens a connection bound to the device with the given serial,
+// or to the only connected device when serial is empty.
+func (c *Client) transport(ctx context.Context, serial string) (*conn, error) {
+	cn, err := c.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	service := "host:transport-any"
+	if serial != "" {
+		service = "host:transport:" + serial
+	}
+	if err := cn.request(service); err != nil {
+		cn.Close()
+		return nil, err
+	}
+	return cn, nil
+}
+
+// Service runs a device service (shell:, tcpip:, ...) and returns everything it writes.
+func (c *Client) Service(ctx context.Context, serial, service string) ([]byte, error) {
+	cn, err := c.transport(ctx, serial)
+	if err != nil {
+		return nil, err
+	}
+	defer cn.Close()
+
+	if err := cn.request(service); err != nil {
+		return nil, err
+	}
+	return cn.readAll(ctx)
+}
+
+// Shell runs command with the device shell and returns its combined output.
+// The legacy shell service does not report the exit status.
+func (c *Client) Shell(ctx context.Context, serial, command string) ([]byte, error) {
+	return c.Service(ctx, serial, "shell:"+command)
+}
+
+// Exec runs command with the exec: service, which returns the raw standard output
+// without the newline translation of shell:, so binary output such as
+// `screencap -p` arrives intact.
+func (c *Client) Exec(ctx context.Context, serial, command string) ([]byte, error) {
+	return c.Service(ctx, serial, "exec:"+command)
+}
@@ -0,0 +1,242 @@
+package adb
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeServer is a local adb server with one device, speaking enough of the
+// smart-socket protocol for the client.
+type fakeServer struct {
+	listener net.Listener
+	serial   string
+	shell    map[string]string // command -> output
+	exec     map[string][]byte // command -> raw output
+
+	mu       sync.Mutex
+	services []string
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	f := &fakeServer{
+		listener: listener,
+		serial:   "emulator-5554",
+		shell:    map[string]string{},
+		exec:     map[string][]byte{},
+	}
+	go f.serve()
+	t.Cleanup(func() { listener.Close() })
+	return f
+}
+
+func (f *fakeServer) Addr() string { return f.listener.Addr().String() }
+
+func (f *fakeServer) Services() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.services...)
+}
+
+func (f *fakeServer) serve() {
+	for {
+		c, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		go f.handle(c)
+	}
+}
+
+func readRequest(r *bufio.Reader) (string, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return "", err
+	}
+	n, err := strconv.ParseUint(string(header), 16, 16)
+	if err != nil {
+		return "", err
+	}
+	data := make([]byte, n)
+	_, err = io.ReadFull(r, data)
+	return string(data), err
+}
+
+func okay(w io.Writer, message string) {
+	fmt.Fprintf(w, "OKAY%04x%s", len(message), message)
+}
+
+func fail(w io.Writer, message string) {
+	fmt.Fprintf(w, "FAIL%04x%s", len(message), message)
+}
+
+func (f *fakeServer) handle(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	transport := false
+	for {
+		service, err := readRequest(r)
+		if err != nil {
+			return
+		}
+		f.mu.Lock()
+		f.services = append(f.services, service)
+		f.mu.Unlock()
+
+		switch {
+		case service == "host:devices-l":
+			okay(c, f.serial+"          device product:sdk_gphone64 model:sdk_gphone64_x86_64 device:emu64x transport_id:1\n")
+			return
+		case strings.HasPrefix(service, "host:connect:"):
+			okay(c, "connected to "+strings.TrimPrefix(service, "host:connect:"))
+			return
+		case service == "host:transport-any" || service == "host:transport:"+f.serial:
+			io.WriteString(c, "OKAY")
+			transport = true
+		case strings.HasPrefix(service, "host:transport:"):
+			fail(c, "device '"+strings.TrimPrefix(service, "host:transport:")+"' not found")
+			return
+		case transport && strings.HasPrefix(service, "shell:"):
+			io.WriteString(c, "OKAY")
+			io.WriteString(c, f.shell[strings.TrimPrefix(service, "shell:")])
+			return
+		case transport && strings.HasPrefix(service, "exec:"):
+			io.WriteString(c, "OKAY")
+			c.Write(f.exec[strings.TrimPrefix(service, "exec:")])
+			return
+		default:
+			fail(c, "unknown service "+service)
+			return
+		}
+	}
+}
+
+func TestDevices(t *testing.T) {
+	server := newFakeServer(t)
+	client := NewClient(server.Addr())
+
+	devices, err := client.Devices(context.Background())
+	if err != nil {
+		t.Fatalf("Devices failed: %v", err)
+	}
+	if len(devices) != 1 || devices[0].Serial != "emulator-5554" || devices[0].State != "device" {
+		t.Fatalf("unexpected devices: %+v", devices)
+	}
+	if devices[0].Attrs["model"] != "sdk_gphone64_x86_64" {
+		t.Errorf("unexpected attributes: %v", devices[0].Attrs)
+	}
+}
+
+func TestShell(t *testing.T) {
+	server := newFakeServer(t)
+	server.shell["settings get secure default_input_method"] = "com.android.adbkeyboard/.AdbIME\n"
+	client := NewClient(server.Addr())
+
+	output, err := client.Shell(context.Background(), "emulator-5554", "settings get secure default_input_method")
+	if err != nil {
+		t.Fatalf("Shell failed: %v", err)
+	}
+	if string(output) != "com.android.adbkeyboard/.AdbIME\n" {
+		t.Errorf("unexpected output %q", output)
+	}
+
+	services := server.Services()
+	if len(services) != 2 || services[0] != "host:transport:emulator-5554" {
+		t.Errorf("unexpected services: %v", services)
+	}
+}
+
+func TestExecBinaryOutput(t *testing.T) {
+	server := newFakeServer(t)
+	png := append([]byte("\x89PNG\r\n\x1a\n"), 0, '\n', '\r', 0xff)
+	server.exec["screencap -p"] = png
+	client := NewClient(server.Addr())
+
+	data, err := client.Exec(context.Background(), "", "screencap -p")
+	if err != nil {
+		t.Fatalf("Exec failed: %v", err)
+	}
+	if !bytes.Equal(data, png) {
+		t.Errorf("binary output altered: %q", data)
+	}
+	if services := server.Services(); services[len(services)-1] != "exec:screencap -p" {
+		t.Errorf("unexpected services: %v", services)
+	}
+}
+
+func TestShellUnknownDevice(t *testing.T) {
+	server := newFakeServer(t)
+	client := NewClient(server.Addr())
+
+	_, err := client.Shell(context.Background(), "missing", "echo hi")
+	var adbErr *Error
+	if !errors.As(err, &adbErr) || !strings.Contains(adbErr.Message, "not found") {
+		t.Fatalf("expected a FAIL answer, got %v", err)
+	}
+}
+
+func TestHostCommand(t *testing.T) {
+	server := newFakeServer(t)
+	client := NewClient(server.Addr())
+
+	output, err := client.HostCommand(context.Background(), "host:connect:192.168.1.100:5555")
+	if err != nil || output != "connected to 192.168.1.100:5555" {
+		t.Fatalf("unexpected connect answer %q, %v", output, err)
+	}
+}
+
+func TestShellCanceled(t *testing.T) {
+	// A server that accepts the shell service but never finishes it
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		c, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		r := bufio.NewReader(c)
+		for i := 0; i < 2; i++ {
+			if _, err := readRequest(r); err != nil {
+				return
+			}
+			io.WriteString(c, "OKAY")
+		}
+		time.Sleep(5 * time.Second)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = NewClient(listener.Addr().String()).Shell(ctx, "", "sleep 100")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Error("shell did not stop when the context was canceled")
+	}
+}
+
+func TestParseDevicesSkipsHeader(t *testing.T) {
+	output := "* daemon started successfully\nList of devices attached\n192.168.1.100:5555 device model:Pixel_7\nabc unauthorized\n\n"
+	devices := ParseDevices(output)
+	if len(devices) != 2 || devices[0].Attrs["model"] != "Pixel_7" || devices[1].State != "unauthorized" {
+		t.Errorf("unexpected devices: %+v", devices)
+	}
+}
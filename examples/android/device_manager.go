@@ -1,7 +1,6 @@
 package android
 
 import (
-	"bufio"
 	"context"
 	"fmt"
 	"os/exec"
@@ -9,7 +8,9 @@ import (
 	"strings"
 	"time"
 
+	"github.com/spance/autoglm-go/examples/android/adb"
 	"github.com/spance/autoglm-go/phoneagent/definitions"
+	"github.com/spance/autoglm-go/utils"
 
 	"github.com/rs/zerolog/log"
 )
@@ -22,13 +23,7 @@ func (r *ADBDevice) Connect(ctx context.Context, address string) (string, error)
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	// Prepare the command
-	cmdArgs := []string{"connect", address}
-
-	log.Debug().Str("cmd", fmt.Sprintf("[Connect] run cmd: %s %s", adbPath, strings.Join(cmdArgs, " "))).Msg("")
-
-	cmd := exec.CommandContext(ctx, adbPath, cmdArgs...)
-	rawOutput, err := cmd.CombinedOutput()
+	rawOutput, err := r.hostCommand(ctx, "Connect", "host:connect:"+address, "connect", address)
 	if err != nil {
 		log.Error().Err(err).Msg("[Connect] run cmd failed")
 		return fmt.Sprintf("Connect error: %v", err), err
@@ -59,10 +54,8 @@ func (r *ADBDevice) Disconnect(ctx context.Context, address string) (string, err
 	if len(address) > 0 {
 		cmdArgs = append(cmdArgs, address)
 	}
-	log.Debug().Str("cmd", fmt.Sprintf("[Disconnect] run cmd: %s %s", adbPath, strings.Join(cmdArgs, " "))).Msg("")
 
-	cmd := exec.CommandContext(ctx, adbPath, cmdArgs...)
-	rawOutput, err := cmd.CombinedOutput()
+	rawOutput, err := r.hostCommand(ctx, "Disconnect", "host:disconnect:"+address, cmdArgs...)
 	if err != nil {
 		log.Error().Err(err).Msg("[Disconnect] run cmd failed")
 		return fmt.Sprintf("Disconnect error: %v", err), err
@@ -70,68 +63,36 @@ func (r *ADBDevice) Disconnect(ctx context.Context, address string) (string, err
 
 	log.Debug().Str("output", fmt.Sprintf("%s", rawOutput)).Msg("[Disconnect] raw output")
 
-	return string(rawOutput), nil
+	return rawOutput, nil
 }
 
 func (r *ADBDevice) ListDevices(ctx context.Context) ([]definitions.DeviceInfo, error) {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
-	cmdArgs := []string{"devices", "-l"}
-
-	log.Debug().Str("cmd", fmt.Sprintf("[ListDevices] run cmd: %s %s", adbPath, strings.Join(cmdArgs, " "))).Msg("")
-	cmd := exec.CommandContext(ctx, adbPath, cmdArgs...)
 
-	rawOutput, err := cmd.CombinedOutput()
+	output, err := r.hostCommand(ctx, "ListDevices", "host:devices-l", "devices", "-l")
 	if err != nil {
 		log.Error().Err(err).Msg("[ListDevices] run cmd failed")
 		return nil, err
 	}
-	output := string(rawOutput)
 
 	var devices []definitions.DeviceInfo
-	scanner := bufio.NewScanner(strings.NewReader(output))
-
-	// Skip the first line (header)
-	scanner.Scan()
-
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" {
-			continue
-		}
-
-		parts := strings.Fields(line)
-		if len(parts) < 2 {
-			continue
-		}
-
-		deviceID := parts[0]
-		status := parts[1]
-
+	for _, entry := range adb.ParseDevices(output) {
 		// Determine connection type
 		var connType definitions.ConnectionType
-		if strings.Contains(deviceID, ":") {
+		if strings.Contains(entry.Serial, ":") {
 			connType = definitions.Remote
-		} else if strings.Contains(deviceID, "emulator") {
+		} else if strings.Contains(entry.Serial, "emulator") {
 			connType = definitions.USB // Emulator via USB
 		} else {
 			connType = definitions.USB
 		}
 
-		// Parse additional info
-		var model string
-		for _, part := range parts[2:] {
-			if strings.HasPrefix(part, "model:") {
-				model = strings.SplitN(part, ":", 2)[1]
-				break
-			}
-		}
-
 		devices = append(devices, definitions.DeviceInfo{
-			DeviceID:       deviceID,
-			Status:         status,
+			DeviceID:       entry.Serial,
+			Status:         entry.State,
 			ConnectionType: connType,
-			Model:          model,
+			Model:          entry.Attrs["model"],
 		})
 	}
 
@@ -152,17 +113,22 @@ func (r *ADBDevice) EnableTCPIP(ctx context.Context, port int, deviceID string)
 	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
-	// Prepare the command
-	var cmdArgs []string
-	if len(deviceID) > 0 {
-		cmdArgs = append(cmdArgs, "-s", deviceID)
-	}
-	cmdArgs = append(cmdArgs, "tcpip", strconv.Itoa(port))
-
-	log.Debug().Str("cmd", fmt.Sprintf("[EnableTCPIP] run cmd: %s %s", adbPath, strings.Join(cmdArgs, " "))).Msg("")
+	var output []byte
+	var err error
+	if r.Client != nil {
+		log.Debug().Str("cmd", fmt.Sprintf("[EnableTCPIP] adb protocol: tcpip:%d", port)).Msg("")
+		output, err = r.Client.Service(ctx, deviceID, "tcpip:"+strconv.Itoa(port))
+	} else {
+		// Prepare the command
+		var cmdArgs []string
+		if len(deviceID) > 0 {
+			cmdArgs = append(cmdArgs, "-s", deviceID)
+		}
+		cmdArgs = append(cmdArgs, "tcpip", strconv.Itoa(port))
 
-	cmd := exec.CommandContext(ctx, adbPath, cmdArgs...)
-	output, err := cmd.CombinedOutput()
+		log.Debug().Str("cmd", fmt.Sprintf("[EnableTCPIP] run cmd: %s %s", adbPath, strings.Join(cmdArgs, " "))).Msg("")
+		output, err = exec.CommandContext(ctx, adbPath, cmdArgs...).CombinedOutput()
+	}
 	if err != nil {
 		log.Error().Err(err).Msg("[EnableTCPIP] run cmd failed")
 		return err
@@ -170,8 +136,7 @@ func (r *ADBDevice) EnableTCPIP(ctx context.Context, port int, deviceID string)
 
 	// Process results
 	if strings.Contains(strings.ToLower(string(output)), "restarting") {
-		time.Sleep(time.Second * 5)
-		return nil
+		return utils.Sleep(ctx, 5*time.Second)
 	}
 	return fmt.Errorf("error enabling TCP/IP: %v", err)
 }
@@ -181,16 +146,7 @@ func (r *ADBDevice) GetDeviceIP(ctx context.Context, deviceID string) (string, e
 	defer cancel()
 
 	// ---------- 1. adb shell ip route ----------
-	var cmdArgs []string
-	if len(deviceID) > 0 {
-		cmdArgs = append(cmdArgs, "-s", deviceID)
-	}
-	cmdArgs = append(cmdArgs, "shell", "ip", "route")
-
-	cmd := exec.CommandContext(ctx, adbPath, cmdArgs...)
-	log.Debug().Str("cmd", fmt.Sprintf("[GetDeviceIP] run cmd1: %s %s", adbPath, strings.Join(cmdArgs, " "))).Msg("")
-
-	output, err := cmd.CombinedOutput()
+	output, err := r.shell(ctx, "GetDeviceIP", deviceID, "ip", "route")
 	if err != nil {
 		log.Error().Err(err).Msg("[GetDeviceIP] run cmd1 failed")
 		return "", err
@@ -212,15 +168,7 @@ func (r *ADBDevice) GetDeviceIP(ctx context.Context, deviceID string) (string, e
 	}
 
 	// ---------- 2. adb shell ip addr show wlan0 ----------
-	cmdArgs = []string{}
-	if len(deviceID) > 0 {
-		cmdArgs = append(cmdArgs, "-s", deviceID)
-	}
-	cmdArgs = append(cmdArgs, "shell", "ip", "addr", "show", "wlan0")
-	log.Debug().Str("cmd", fmt.Sprintf("[GetDeviceIP] run cmd2: %s %s", adbPath, strings.Join(cmdArgs, " "))).Msg("")
-
-	cmd = exec.CommandContext(ctx, adbPath, cmdArgs...)
-	output, err = cmd.CombinedOutput()
+	output, err = r.shell(ctx, "GetDeviceIP", deviceID, "ip", "addr", "show", "wlan0")
 	if err != nil {
 		log.Error().Err(err).Msg("[GetDeviceIP] run cmd2 failed")
 		return "", err
@@ -7,24 +7,38 @@ import (
 	"fmt"
 	"image"
 	"image/png"
-	"os"
-	"os/exec"
-	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
 	"github.com/spance/autoglm-go/constants"
+	"github.com/spance/autoglm-go/examples/android/adb"
 	"github.com/spance/autoglm-go/phoneagent/definitions"
+	"github.com/spance/autoglm-go/utils"
 )
 
 type ADBDevice struct {
+	// Client 通过 adb server 协议直接通信；为空时调用 adb 可执行文件
+	Client *adb.Client
+	// ActionDelay 每个操作成功后的固定等待时间；为 0 时不等待，由 PhoneAgent 的 SettlePolicy 等待界面稳定
+	ActionDelay time.Duration
 }
 
+// settle waits ActionDelay after a successful action; it returns early when ctx is done.
+func (r *ADBDevice) settle(ctx context.Context, err error) error {
+	if err != nil {
+		return err
+	}
+	return utils.Sleep(ctx, r.ActionDelay)
+}
+
+// pngSignature starts every PNG file.
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
 // createFallbackScreenshot creates a black fallback image when screenshot fails.
-func createFallbackScreenshot(isSensitive bool) *definitions.Screenshot {
+// The result is flagged as Fallback so the agent does not act on a blank screen.
+func createFallbackScreenshot(isSensitive bool, reason string) *definitions.Screenshot {
 	const (
 		defaultWidth  = 1080
 		defaultHeight = 2400
@@ -33,103 +47,58 @@ func createFallbackScreenshot(isSensitive bool) *definitions.Screenshot {
 	img := image.NewRGBA(image.Rect(0, 0, defaultWidth, defaultHeight))
 
 	var buf bytes.Buffer
-	encoder := base64.NewEncoder(base64.StdEncoding, &buf)
-	if err := png.Encode(encoder, img); err != nil {
+	if err := png.Encode(&buf, img); err != nil {
 		log.Error().Err(err).Msg("Error encoding fallback image")
-		return &definitions.Screenshot{
-			Base64Data:  "",
-			Width:       defaultWidth,
-			Height:      defaultHeight,
-			IsSensitive: isSensitive,
-		}
 	}
-	encoder.Close()
 
 	return &definitions.Screenshot{
-		Base64Data:  buf.String(),
-		Width:       defaultWidth,
-		Height:      defaultHeight,
-		IsSensitive: isSensitive,
+		BinaryData:     buf.Bytes(),
+		Width:          defaultWidth,
+		Height:         defaultHeight,
+		IsSensitive:    isSensitive,
+		Fallback:       true,
+		FallbackReason: reason,
 	}
 }
 
+// GetScreenshot streams `screencap -p` straight into memory in a single round trip,
+// without touching the device or host file system.
 func (r *ADBDevice) GetScreenshot(ctx context.Context, deviceID string) (*definitions.Screenshot, error) {
-	tempPath := filepath.Join(os.TempDir(), fmt.Sprintf("screenshot_%s.png", uuid.New().String()))
-	defer func() {
-		_ = os.Remove(tempPath) // 确保临时文件被清理
-	}()
-
-	var cmdArgs []string
-	if len(deviceID) > 0 {
-		cmdArgs = append(cmdArgs, "-s", deviceID)
-	}
-
-	// 截屏
-	screenshotArgs := append(cmdArgs, "shell", "screencap", "-p", "/sdcard/tmp.png")
-	log.Debug().Str("cmd", fmt.Sprintf("[GetScreenshot] run cmd1: %s %s", adbPath, strings.Join(screenshotArgs, " "))).Msg("")
-
-	output, err := exec.CommandContext(ctx, adbPath, screenshotArgs...).CombinedOutput()
+	data, err := r.execOut(ctx, "GetScreenshot", deviceID, "screencap", "-p")
 	if err != nil {
-		log.Error().Err(err).Str("output", string(output)).Msg("Screenshot command error")
-		return createFallbackScreenshot(false), nil
-	}
-
-	log.Debug().Str("output", fmt.Sprintf("%s", output)).Msg("[GetScreenshot] cmd1 output")
-
-	outputStr := string(output)
-	if strings.Contains(outputStr, "Status: -1") || strings.Contains(outputStr, "Failed") {
-		log.Error().Str("output", outputStr).Msg("Screenshot failed with status: -1 or Failed")
-		return createFallbackScreenshot(true), nil
-	}
-
-	// 拉取文件
-	pullArgs := append(cmdArgs, "pull", "/sdcard/tmp.png", tempPath)
-	log.Debug().Str("cmd", fmt.Sprintf("[GetScreenshot] run cmd2: %s %s", adbPath, strings.Join(pullArgs, " "))).Msg("")
-
-	output, err = exec.CommandContext(ctx, adbPath, pullArgs...).CombinedOutput()
-	if err != nil {
-		log.Error().Err(err).Str("output", string(output)).Msg("Pull command error")
-		return createFallbackScreenshot(false), nil
+		log.Error().Err(err).Msg("Screenshot command error")
+		if strings.Contains(err.Error(), "Status: -1") || strings.Contains(err.Error(), "Failed") {
+			return createFallbackScreenshot(true, err.Error()), nil
+		}
+		return createFallbackScreenshot(false, err.Error()), nil
 	}
 
-	log.Debug().Str("output", fmt.Sprintf("%s", output)).Msg("[GetScreenshot] cmd2 output")
-
-	// 读取文件并 Base64 编码
-	data, err := os.ReadFile(tempPath)
-	if err != nil {
-		log.Error().Err(err).Msg("Error reading image file")
-		return createFallbackScreenshot(false), nil
+	// screencap prints its errors instead of an image, e.g. on secure (FLAG_SECURE) screens
+	if !bytes.HasPrefix(data, pngSignature) {
+		output := string(data[:min(len(data), 200)])
+		log.Error().Str("output", output).Msg("Screenshot did not return a PNG image")
+		if strings.Contains(output, "Status: -1") || strings.Contains(output, "Failed") {
+			return createFallbackScreenshot(true, output), nil
+		}
+		return createFallbackScreenshot(false, "screencap returned no image: "+output), nil
 	}
 
-	img, _, err := image.Decode(bytes.NewReader(data))
+	cfg, err := png.DecodeConfig(bytes.NewReader(data))
 	if err != nil {
 		log.Error().Err(err).Msg("Error decoding image")
-		return createFallbackScreenshot(false), nil
+		return createFallbackScreenshot(false, err.Error()), nil
 	}
-	bounds := img.Bounds()
-	width, height := bounds.Dx(), bounds.Dy()
-
-	base64Data := base64.StdEncoding.EncodeToString(data)
 
 	return &definitions.Screenshot{
-		Base64Data:  base64Data,
-		Width:       width,
-		Height:      height,
+		BinaryData:  data,
+		Width:       cfg.Width,
+		Height:      cfg.Height,
 		IsSensitive: false,
 	}, nil
 }
 
 func (r *ADBDevice) GetCurrentApp(ctx context.Context, deviceID string) (string, error) {
-
-	var cmdArgs []string
-	if deviceID != "" {
-		cmdArgs = append(cmdArgs, "-s", deviceID)
-	}
-
-	args := append(cmdArgs, "shell", "dumpsys", "window")
-	log.Debug().Str("cmd", fmt.Sprintf("[GetCurrentApp] run cmd: %s %s", adbPath, strings.Join(args, " "))).Msg("")
-
-	output, err := exec.CommandContext(ctx, adbPath, args...).CombinedOutput()
+	output, err := r.shell(ctx, "GetCurrentApp", deviceID, "dumpsys", "window")
 	if err != nil {
 		log.Error().Err(err).Str("output", string(output)).Msg("Error running dumpsys window")
 		return "", fmt.Errorf("failed to run dumpsys window: %w", err)
@@ -158,149 +127,98 @@ func (r *ADBDevice) GetCurrentApp(ctx context.Context, deviceID string) (string,
 }
 
 func (r *ADBDevice) Tap(ctx context.Context, x, y int, deviceID string) error {
-	adbPrefix := r.GetADBPrefix(deviceID)
-
-	args := append(adbPrefix, "shell", "input", "tap", strconv.Itoa(x), strconv.Itoa(y))
-	log.Debug().Str("cmd", fmt.Sprintf("[Tap] run cmd: %s %s", adbPath, strings.Join(args, " "))).Msg("")
-
-	_, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput()
-	time.Sleep(time.Second * 1)
-	return err
+	_, err := r.shell(ctx, "Tap", deviceID, "input", "tap", strconv.Itoa(x), strconv.Itoa(y))
+	return r.settle(ctx, err)
 }
 
 func (r *ADBDevice) DoubleTap(ctx context.Context, x, y int, deviceID string) error {
 	const doubleTapInterval = 100 * time.Millisecond
 
-	if err := r.Tap(ctx, x, y, deviceID); err != nil {
+	// Tap twice without ActionDelay in between
+	if _, err := r.shell(ctx, "DoubleTap", deviceID, "input", "tap", strconv.Itoa(x), strconv.Itoa(y)); err != nil {
 		return err
 	}
 
-	time.Sleep(doubleTapInterval)
-
-	if err := r.Tap(ctx, x, y, deviceID); err != nil {
+	if err := utils.Sleep(ctx, doubleTapInterval); err != nil {
 		return err
 	}
 
-	time.Sleep(time.Second * 1)
-	return nil
+	_, err := r.shell(ctx, "DoubleTap", deviceID, "input", "tap", strconv.Itoa(x), strconv.Itoa(y))
+	return r.settle(ctx, err)
 }
 
 func (r *ADBDevice) LongPress(ctx context.Context, x, y int, deviceID string) error {
-	adbPrefix := r.GetADBPrefix(deviceID)
-
-	args := append(adbPrefix,
-		"shell", "input", "swipe",
+	_, err := r.shell(ctx, "LongPress", deviceID,
+		"input", "swipe",
 		strconv.Itoa(x), strconv.Itoa(y),
 		strconv.Itoa(x), strconv.Itoa(y),
 		strconv.Itoa(3000),
 	)
-	log.Debug().Str("cmd", fmt.Sprintf("[LongPress] run cmd: %s %s", adbPath, strings.Join(args, " "))).Msg("")
-	_, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput()
-	time.Sleep(time.Second * 1)
-	return err
+	return r.settle(ctx, err)
 }
 
 func (r *ADBDevice) Swipe(ctx context.Context, startX, startY, endX, endY int, deviceID string) error {
 	distSq := (startX-endX)*(startX-endX) + (startY-endY)*(startY-endY)
 	durationMs := int(float64(distSq) / 1000)
 	durationMs = max(1000, min(durationMs, 2000)) // Clamp between 1000-2000ms
-	adbPrefix := r.GetADBPrefix(deviceID)
-
-	args := append(adbPrefix,
-		"shell", "input", "swipe",
+	_, err := r.shell(ctx, "Swipe", deviceID,
+		"input", "swipe",
 		strconv.Itoa(startX), strconv.Itoa(startY),
 		strconv.Itoa(endX), strconv.Itoa(endY),
 		strconv.Itoa(durationMs),
 	)
-
-	log.Debug().Str("cmd", fmt.Sprintf("[Swipe] run cmd: %s %s", adbPath, strings.Join(args, " "))).Msg("")
-
-	_, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput()
-	time.Sleep(time.Second * 1)
-	return err
+	return r.settle(ctx, err)
 }
 
 func (r *ADBDevice) Back(ctx context.Context, deviceID string) error {
-	adbPrefix := r.GetADBPrefix(deviceID)
-	args := append(adbPrefix, "shell", "input", "keyevent", "4")
-
-	log.Debug().Str("cmd", fmt.Sprintf("[Back] run cmd: %s %s", adbPath, strings.Join(args, " "))).Msg("")
-	_, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput()
-	time.Sleep(time.Second * 1)
-	return err
+	_, err := r.shell(ctx, "Back", deviceID, "input", "keyevent", "4")
+	return r.settle(ctx, err)
 }
 
 func (r *ADBDevice) Home(ctx context.Context, deviceID string) error {
-	adbPrefix := r.GetADBPrefix(deviceID)
-	args := append(adbPrefix, "shell", "input", "keyevent", "KEYCODE_HOME")
-
-	log.Debug().Str("cmd", fmt.Sprintf("[Home] run cmd: %s %s", adbPath, strings.Join(args, " "))).Msg("")
-	_, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput()
-	time.Sleep(time.Second * 1)
-	return err
+	_, err := r.shell(ctx, "Home", deviceID, "input", "keyevent", "KEYCODE_HOME")
+	return r.settle(ctx, err)
 }
 
 func (r *ADBDevice) LaunchApp(ctx context.Context, appName, deviceID string) (bool, error) {
 	if _, ok := constants.APP_PACKAGES_ANDROID[appName]; !ok {
 		return false, fmt.Errorf("app name %s not found in APP_PACKAGES", appName)
 	}
-	adbPrefix := r.GetADBPrefix(deviceID)
 	packageName := constants.APP_PACKAGES_ANDROID[appName]
 
-	args := append(adbPrefix,
-		"shell",
+	_, err := r.shell(ctx, "LaunchApp", deviceID,
 		"monkey",
 		"-p",
 		packageName,
 		"-c", "android.intent.category.LAUNCHER",
 		"1",
 	)
-
-	log.Debug().Str("cmd", fmt.Sprintf("[LaunchApp] run cmd: %s %s", adbPath, strings.Join(args, " "))).Msg("")
-
-	_, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput()
 	if err != nil {
 		log.Error().Err(err).Msg("failed to launch app")
 		return false, err
 	}
-	time.Sleep(time.Second * 1)
-	return true, nil
+	return true, r.settle(ctx, nil)
 }
 
 func (r *ADBDevice) TypeText(ctx context.Context, text, deviceID string) error {
-	adbPrefix := r.GetADBPrefix(deviceID)
-
 	encoded := base64.StdEncoding.EncodeToString([]byte(text))
 
-	args := append(adbPrefix,
-		"shell", "am", "broadcast",
+	_, err := r.shell(ctx, "TypeText", deviceID,
+		"am", "broadcast",
 		"-a", "ADB_INPUT_B64",
 		"--es", "msg", encoded,
 	)
-	log.Debug().Str("cmd", fmt.Sprintf("[TypeText] run cmd: %s %s", adbPath, strings.Join(args, " "))).Msg("")
-
-	_, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput()
 	return err
 }
 
 func (r *ADBDevice) ClearText(ctx context.Context, deviceID string) error {
-	adbPrefix := r.GetADBPrefix(deviceID)
-
-	args := append(adbPrefix, "shell", "am", "broadcast", "-a", "ADB_CLEAR_TEXT")
-	log.Debug().Str("cmd", fmt.Sprintf("[ClearText] run cmd: %s %s", adbPath, strings.Join(args, " "))).Msg("")
-
-	_, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput()
+	_, err := r.shell(ctx, "ClearText", deviceID, "am", "broadcast", "-a", "ADB_CLEAR_TEXT")
 	return err
 }
 
 func (r *ADBDevice) DetectAndSetADBKeyboard(ctx context.Context, deviceID string) (string, error) {
-	adbPrefix := r.GetADBPrefix(deviceID)
-
 	// 获取当前输入法
-	getArgs := append(adbPrefix, "shell", "settings", "get", "secure", "default_input_method")
-	log.Debug().Str("cmd", fmt.Sprintf("[DetectAndSetADBKeyboard] run cmd1: %s %s", adbPath, strings.Join(getArgs, " "))).Msg("")
-
-	out, err := exec.CommandContext(ctx, getArgs[0], getArgs[1:]...).CombinedOutput()
+	out, err := r.shell(ctx, "DetectAndSetADBKeyboard", deviceID, "settings", "get", "secure", "default_input_method")
 	if err != nil {
 		return "", err
 	}
@@ -309,11 +227,7 @@ func (r *ADBDevice) DetectAndSetADBKeyboard(ctx context.Context, deviceID string
 
 	// 如未启用 ADB Keyboard，则切换
 	if !strings.Contains(currentIME, "com.android.adbkeyboard/.AdbIME") {
-
-		setArgs := append(adbPrefix, "shell", "ime", "set", "com.android.adbkeyboard/.AdbIME")
-		log.Debug().Str("cmd", fmt.Sprintf("[DetectAndSetADBKeyboard] run cmd2: %s %s", adbPath, strings.Join(setArgs, " "))).Msg("")
-
-		_, err := exec.CommandContext(ctx, setArgs[0], setArgs[1:]...).CombinedOutput()
+		_, err := r.shell(ctx, "DetectAndSetADBKeyboard", deviceID, "ime", "set", "com.android.adbkeyboard/.AdbIME")
 		if err != nil {
 			return "", err
 		}
@@ -330,12 +244,7 @@ func (r *ADBDevice) RestoreKeyboard(ctx context.Context, ime, deviceID string) e
 		return fmt.Errorf("IME cannot be empty")
 	}
 
-	adbPrefix := r.GetADBPrefix(deviceID)
-	args := append(adbPrefix, "shell", "ime", "set", ime)
-
-	log.Debug().Str("cmd", fmt.Sprintf("[RestoreKeyboard] run cmd: %s %s", adbPath, strings.Join(args, " "))).Msg("")
-
-	_, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput()
+	_, err := r.shell(ctx, "RestoreKeyboard", deviceID, "ime", "set", ime)
 	return err
 }
 
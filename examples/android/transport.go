@@ -0,0 +1,65 @@
+package android
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// shell runs `adb shell args...` on the device, over the adb server protocol when
+// r.Client is set and with the adb binary otherwise. tag prefixes the debug log.
+func (r *ADBDevice) shell(ctx context.Context, tag, deviceID string, args ...string) ([]byte, error) {
+	if r.Client != nil {
+		command := strings.Join(args, " ")
+		log.Debug().Str("cmd", fmt.Sprintf("[%s] adb protocol: shell:%s", tag, command)).Msg("")
+		return r.Client.Shell(ctx, deviceID, command)
+	}
+
+	cmdArgs := append(r.GetADBPrefix(deviceID), "shell")
+	cmdArgs = append(cmdArgs, args...)
+	log.Debug().Str("cmd", fmt.Sprintf("[%s] run cmd: %s", tag, strings.Join(cmdArgs, " "))).Msg("")
+	return exec.CommandContext(ctx, cmdArgs[0], cmdArgs[1:]...).CombinedOutput()
+}
+
+// execOut runs `adb exec-out args...` and returns the raw standard output, which
+// is safe for binary data. Standard error is only logged.
+func (r *ADBDevice) execOut(ctx context.Context, tag, deviceID string, args ...string) ([]byte, error) {
+	if r.Client != nil {
+		command := strings.Join(args, " ")
+		log.Debug().Str("cmd", fmt.Sprintf("[%s] adb protocol: exec:%s", tag, command)).Msg("")
+		return r.Client.Exec(ctx, deviceID, command)
+	}
+
+	cmdArgs := append(r.GetADBPrefix(deviceID), "exec-out")
+	cmdArgs = append(cmdArgs, args...)
+	log.Debug().Str("cmd", fmt.Sprintf("[%s] run cmd: %s", tag, strings.Join(cmdArgs, " "))).Msg("")
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, cmdArgs[0], cmdArgs[1:]...)
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if stderr.Len() > 0 {
+		log.Debug().Str("stderr", stderr.String()).Msgf("[%s] exec-out stderr", tag)
+	}
+	if err != nil && stderr.Len() > 0 {
+		err = fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return output, err
+}
+
+// hostCommand runs an adb server command such as connect or disconnect.
+// args are the adb binary arguments; service is the equivalent host service.
+func (r *ADBDevice) hostCommand(ctx context.Context, tag, service string, args ...string) (string, error) {
+	if r.Client != nil {
+		log.Debug().Str("cmd", fmt.Sprintf("[%s] adb protocol: %s", tag, service)).Msg("")
+		return r.Client.HostCommand(ctx, service)
+	}
+
+	log.Debug().Str("cmd", fmt.Sprintf("[%s] run cmd: %s %s", tag, adbPath, strings.Join(args, " "))).Msg("")
+	output, err := exec.CommandContext(ctx, adbPath, args...).CombinedOutput()
+	return string(output), err
+}
@@ -0,0 +1,102 @@
+package android
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spance/autoglm-go/phoneagent/definitions"
+)
+
+// uiDumpPath is where the view tree is dumped when the device cannot write it to /dev/tty.
+const uiDumpPath = "/sdcard/autoglm_window_dump.xml"
+
+// uiNode mirrors a <node> element of a uiautomator dump.
+type uiNode struct {
+	Class       string   `xml:"class,attr"`
+	Text        string   `xml:"text,attr"`
+	ContentDesc string   `xml:"content-desc,attr"`
+	ResourceID  string   `xml:"resource-id,attr"`
+	Package     string   `xml:"package,attr"`
+	Bounds      string   `xml:"bounds,attr"`
+	Clickable   bool     `xml:"clickable,attr"`
+	Enabled     bool     `xml:"enabled,attr"`
+	Scrollable  bool     `xml:"scrollable,attr"`
+	Password    bool     `xml:"password,attr"`
+	Nodes       []uiNode `xml:"node"`
+}
+
+// GetUIHierarchy dumps the accessibility view tree with `uiautomator dump`.
+func (r *ADBDevice) GetUIHierarchy(ctx context.Context, deviceID string) (*definitions.UINode, error) {
+	output, err := r.shell(ctx, "GetUIHierarchy", deviceID, "uiautomator", "dump", "/dev/tty")
+	if err != nil || !bytes.Contains(output, []byte("</hierarchy>")) {
+		// Some devices refuse /dev/tty, dump to a file and read it back instead
+		log.Debug().Err(err).Msg("uiautomator dump to /dev/tty failed, dumping to a file")
+		output, err = r.shell(ctx, "GetUIHierarchy", deviceID,
+			"uiautomator dump "+uiDumpPath+" >/dev/null && cat "+uiDumpPath+"; rm -f "+uiDumpPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to dump UI hierarchy: %w", err)
+		}
+	}
+	return ParseUIHierarchy(output)
+}
+
+// ParseUIHierarchy parses a uiautomator dump. Text printed around the XML, such
+// as "UI hierchary dumped to: /dev/tty", is ignored.
+func ParseUIHierarchy(data []byte) (*definitions.UINode, error) {
+	start := bytes.Index(data, []byte("<hierarchy"))
+	end := bytes.LastIndex(data, []byte("</hierarchy>"))
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("no UI hierarchy in uiautomator output: %.200s", data)
+	}
+
+	var hierarchy struct {
+		Nodes []uiNode `xml:"node"`
+	}
+	if err := xml.Unmarshal(data[start:end+len("</hierarchy>")], &hierarchy); err != nil {
+		return nil, fmt.Errorf("failed to parse UI hierarchy: %w", err)
+	}
+
+	root := &definitions.UINode{Class: "hierarchy", Enabled: true}
+	for _, node := range hierarchy.Nodes {
+		root.Children = append(root.Children, node.convert())
+		root.Bounds = unionBounds(root.Bounds, root.Children[len(root.Children)-1].Bounds)
+	}
+	return root, nil
+}
+
+func (n uiNode) convert() *definitions.UINode {
+	node := &definitions.UINode{
+		Class:       n.Class,
+		Text:        n.Text,
+		ContentDesc: n.ContentDesc,
+		ResourceID:  n.ResourceID,
+		Package:     n.Package,
+		Clickable:   n.Clickable,
+		Enabled:     n.Enabled,
+		Scrollable:  n.Scrollable,
+		Password:    n.Password,
+	}
+	// bounds="[x1,y1][x2,y2]"
+	b := &node.Bounds
+	if _, err := fmt.Sscanf(n.Bounds, "[%d,%d][%d,%d]", &b.X1, &b.Y1, &b.X2, &b.Y2); err != nil {
+		log.Debug().Str("bounds", n.Bounds).Msg("Invalid node bounds")
+		*b = definitions.Bounds{}
+	}
+	for _, child := range n.Nodes {
+		node.Children = append(node.Children, child.convert())
+	}
+	return node
+}
+
+func unionBounds(a, b definitions.Bounds) definitions.Bounds {
+	if a.Empty() {
+		return b
+	}
+	if b.Empty() {
+		return a
+	}
+	return definitions.Bounds{X1: min(a.X1, b.X1), Y1: min(a.Y1, b.Y1), X2: max(a.X2, b.X2), Y2: max(a.Y2, b.Y2)}
+}
@@ -0,0 +1,38 @@
+package android
+
+import "testing"
+
+const sampleDump = `<?xml version='1.0' encoding='UTF-8' standalone='yes' ?><hierarchy rotation="0"><node index="0" text="" resource-id="" class="android.widget.FrameLayout" package="com.android.settings" content-desc="" clickable="false" enabled="true" scrollable="false" password="false" bounds="[0,0][1080,2400]"><node index="0" text="Wi-Fi" resource-id="android:id/title" class="android.widget.TextView" package="com.android.settings" content-desc="" clickable="false" enabled="true" scrollable="false" password="false" bounds="[48,300][400,360]" /><node index="1" text="" resource-id="com.android.settings:id/switch" class="android.widget.Switch" package="com.android.settings" content-desc="Wi-Fi switch" clickable="true" enabled="true" scrollable="false" password="false" bounds="[900,290][1040,370]" /></node></hierarchy>UI hierchary dumped to: /dev/tty`
+
+func TestParseUIHierarchy(t *testing.T) {
+	root, err := ParseUIHierarchy([]byte(sampleDump))
+	if err != nil {
+		t.Fatalf("ParseUIHierarchy failed: %v", err)
+	}
+	if root.Bounds.X2 != 1080 || root.Bounds.Y2 != 2400 {
+		t.Errorf("unexpected root bounds %v", root.Bounds)
+	}
+
+	elements := root.Elements()
+	if len(elements) != 2 {
+		t.Fatalf("expected 2 elements, got %d", len(elements))
+	}
+	if elements[0].Text != "Wi-Fi" || elements[0].ResourceID != "android:id/title" {
+		t.Errorf("unexpected first element %+v", elements[0])
+	}
+	toggle := elements[1]
+	if !toggle.Clickable || toggle.ContentDesc != "Wi-Fi switch" || toggle.Bounds.X1 != 900 || toggle.Bounds.Y2 != 370 {
+		t.Errorf("unexpected second element %+v", toggle)
+	}
+
+	// A tap just left of the switch lands on it
+	if node := root.ClickableAt(880, 330, 32); node != toggle {
+		t.Errorf("expected the switch to be the tap target, got %+v", node)
+	}
+}
+
+func TestParseUIHierarchyInvalid(t *testing.T) {
+	if _, err := ParseUIHierarchy([]byte("ERROR: could not get idle state.")); err == nil {
+		t.Error("expected an error for output without a hierarchy")
+	}
+}
@@ -5,16 +5,33 @@ import (
 
 	"github.com/spance/autoglm-go/constants"
 	"github.com/spance/autoglm-go/examples/android"
+	"github.com/spance/autoglm-go/examples/android/adb"
+	"github.com/spance/autoglm-go/examples/ios"
 	"github.com/spance/autoglm-go/phoneagent"
 )
 
-func CreateDevice(deviceType string) (phoneagent.Device, error) {
+// ADB transports selectable with Options.ADBTransport.
+const (
+	ADBTransportExec   = "exec"   // run the adb binary for every command
+	ADBTransportNative = "native" // speak the adb server protocol directly
+)
+
+// Options configures the devices created by CreateDevice.
+type Options struct {
+	WdaURL       string // iOS WebDriverAgent 地址
+	ADBTransport string // ADBTransportExec（默认）或 ADBTransportNative
+	ADBServer    string // adb server 地址，仅 native 模式使用
+}
+
+func CreateDevice(deviceType string, opts Options) (phoneagent.Device, error) {
 	switch deviceType {
 	case constants.ADB:
+		if opts.ADBTransport == ADBTransportNative {
+			return &android.ADBDevice{Client: adb.NewClient(opts.ADBServer)}, nil
+		}
 		return &android.ADBDevice{}, nil
 	case constants.IOS:
-		// return &ios.IOSDevice{}, nil
-		return nil, nil // 暂时不实现 iOS 设备
+		return ios.NewIOSDevice(opts.WdaURL), nil
 	default:
 		return nil, fmt.Errorf("unknown device type: %v", deviceType)
 	}
@@ -0,0 +1,105 @@
+package ios
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spance/autoglm-go/phoneagent/definitions"
+)
+
+// Connect points the device at the WebDriverAgent served at address (host:port or URL).
+func (r *IOSDevice) Connect(ctx context.Context, address string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	client := newWdaClient(address)
+	status, err := client.status(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("[Connect] wda status failed")
+		return fmt.Sprintf("Connect error: %v", err), err
+	}
+	if !status.Ready {
+		return fmt.Sprintf("WebDriverAgent at %s is not ready", client.baseURL), nil
+	}
+
+	r.wda() // make sure once has run before replacing the client
+	r.WdaURL = client.baseURL
+	r.client = client
+	return fmt.Sprintf("Connected to %s", client.baseURL), nil
+}
+
+// Disconnect closes the WebDriverAgent session.
+func (r *IOSDevice) Disconnect(ctx context.Context, address string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if err := r.wda().deleteSession(ctx); err != nil {
+		log.Error().Err(err).Msg("[Disconnect] delete session failed")
+		return fmt.Sprintf("Disconnect error: %v", err), err
+	}
+	return fmt.Sprintf("Disconnected from %s", r.wda().baseURL), nil
+}
+
+// ListDevices reports the device behind WdaURL when WebDriverAgent is reachable.
+func (r *IOSDevice) ListDevices(ctx context.Context) ([]definitions.DeviceInfo, error) {
+	info, err := r.GetDeviceInfo(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	return []definitions.DeviceInfo{*info}, nil
+}
+
+func (r *IOSDevice) GetDeviceInfo(ctx context.Context, deviceID string) (*definitions.DeviceInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	status, err := r.wda().status(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	connType := definitions.USB
+	if u, err := url.Parse(r.wda().baseURL); err == nil && u.Hostname() != "localhost" && u.Hostname() != "127.0.0.1" {
+		connType = definitions.WiFi
+	}
+	deviceStatus := "device"
+	if !status.Ready {
+		deviceStatus = "offline"
+	}
+	return &definitions.DeviceInfo{
+		DeviceID:       r.wda().baseURL,
+		Status:         deviceStatus,
+		ConnectionType: connType,
+		Model:          status.Device,
+	}, nil
+}
+
+func (r *IOSDevice) IsConnected(ctx context.Context, deviceID string) bool {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	status, err := r.wda().status(ctx)
+	return err == nil && status.Ready
+}
+
+func (r *IOSDevice) EnableTCPIP(ctx context.Context, port int, deviceID string) error {
+	return fmt.Errorf("tcpip is not supported on iOS, use --wda-url with the device IP")
+}
+
+func (r *IOSDevice) GetDeviceIP(ctx context.Context, deviceID string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	status, err := r.wda().status(ctx)
+	if err != nil {
+		return "", err
+	}
+	return status.IOS.IP, nil
+}
+
+func (r *IOSDevice) RestartServer(ctx context.Context) (string, error) {
+	return "", fmt.Errorf("restarting WebDriverAgent is not supported, relaunch it from Xcode")
+}
@@ -0,0 +1,229 @@
+package ios
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"image"
+	_ "image/png"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spance/autoglm-go/constants"
+	"github.com/spance/autoglm-go/phoneagent/definitions"
+	"github.com/spance/autoglm-go/utils"
+)
+
+// IOSDevice drives an iPhone through the WebDriverAgent REST API.
+// The deviceID arguments are ignored: the device is the one served at WdaURL.
+type IOSDevice struct {
+	// WdaURL is the WebDriverAgent address, DefaultWdaURL when empty.
+	WdaURL string
+	// ActionDelay is waited after each gesture. It is 0 by default: PhoneAgent
+	// waits for the UI to settle according to its SettlePolicy.
+	ActionDelay time.Duration
+
+	once   sync.Once
+	client *wdaClient
+}
+
+// NewIOSDevice creates a device for the WebDriverAgent served at wdaURL.
+func NewIOSDevice(wdaURL string) *IOSDevice {
+	return &IOSDevice{WdaURL: wdaURL}
+}
+
+func (r *IOSDevice) wda() *wdaClient {
+	r.once.Do(func() {
+		r.client = newWdaClient(r.WdaURL)
+	})
+	return r.client
+}
+
+// settle waits ActionDelay after a successful action; it returns early when ctx is done.
+func (r *IOSDevice) settle(ctx context.Context, err error) error {
+	if err != nil {
+		return err
+	}
+	return utils.Sleep(ctx, r.ActionDelay)
+}
+
+// toPoints converts screenshot pixels to WDA points.
+func (r *IOSDevice) toPoints(ctx context.Context, x, y int) (int, int) {
+	scale := r.wda().screenScale(ctx)
+	return int(float64(x) / scale), int(float64(y) / scale)
+}
+
+func (r *IOSDevice) GetScreenshot(ctx context.Context, deviceID string) (*definitions.Screenshot, error) {
+	var encoded string
+	if err := r.wda().do(ctx, http.MethodGet, "/screenshot", nil, &encoded); err != nil {
+		log.Error().Err(err).Msg("[GetScreenshot] wda screenshot failed")
+		return nil, err
+	}
+
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid screenshot data: %w", err)
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode screenshot: %w", err)
+	}
+
+	return &definitions.Screenshot{
+		BinaryData:  data,
+		Base64Data:  encoded,
+		Width:       cfg.Width,
+		Height:      cfg.Height,
+		IsSensitive: false,
+	}, nil
+}
+
+func (r *IOSDevice) GetCurrentApp(ctx context.Context, deviceID string) (string, error) {
+	var info struct {
+		BundleID string `json:"bundleId"`
+	}
+	if err := r.wda().do(ctx, http.MethodGet, "/wda/activeAppInfo", nil, &info); err != nil {
+		return "", fmt.Errorf("failed to get active app: %w", err)
+	}
+
+	for appName, bundleID := range constants.APP_PACKAGES_IOS {
+		if bundleID == info.BundleID {
+			return appName, nil
+		}
+	}
+	return "System Home", nil
+}
+
+func (r *IOSDevice) Tap(ctx context.Context, x, y int, deviceID string) error {
+	px, py := r.toPoints(ctx, x, y)
+	log.Debug().Int("x", px).Int("y", py).Msg("[Tap] wda tap")
+
+	err := r.wda().performTouch(ctx, pointerMove(px, py, 0), pointerDown(), pause(100), pointerUp())
+	return r.settle(ctx, err)
+}
+
+func (r *IOSDevice) DoubleTap(ctx context.Context, x, y int, deviceID string) error {
+	px, py := r.toPoints(ctx, x, y)
+	log.Debug().Int("x", px).Int("y", py).Msg("[DoubleTap] wda double tap")
+
+	err := r.wda().performTouch(ctx,
+		pointerMove(px, py, 0), pointerDown(), pause(100), pointerUp(),
+		pause(100),
+		pointerDown(), pause(100), pointerUp(),
+	)
+	return r.settle(ctx, err)
+}
+
+func (r *IOSDevice) LongPress(ctx context.Context, x, y int, deviceID string) error {
+	px, py := r.toPoints(ctx, x, y)
+	log.Debug().Int("x", px).Int("y", py).Msg("[LongPress] wda long press")
+
+	err := r.wda().performTouch(ctx, pointerMove(px, py, 0), pointerDown(), pause(3000), pointerUp())
+	return r.settle(ctx, err)
+}
+
+func (r *IOSDevice) Swipe(ctx context.Context, startX, startY, endX, endY int, deviceID string) error {
+	distSq := (startX-endX)*(startX-endX) + (startY-endY)*(startY-endY)
+	durationMs := int(float64(distSq) / 1000)
+	durationMs = max(1000, min(durationMs, 2000)) // Clamp between 1000-2000ms
+
+	sx, sy := r.toPoints(ctx, startX, startY)
+	ex, ey := r.toPoints(ctx, endX, endY)
+	log.Debug().Int("start_x", sx).Int("start_y", sy).Int("end_x", ex).Int("end_y", ey).Msg("[Swipe] wda swipe")
+
+	err := r.wda().performTouch(ctx, pointerMove(sx, sy, 0), pointerDown(), pointerMove(ex, ey, durationMs), pointerUp())
+	return r.settle(ctx, err)
+}
+
+// Back performs the iOS back gesture: a swipe from the left edge of the screen.
+func (r *IOSDevice) Back(ctx context.Context, deviceID string) error {
+	var size struct {
+		Width  int `json:"width"`
+		Height int `json:"height"`
+	}
+	if err := r.wda().sessionDo(ctx, http.MethodGet, "/window/size", nil, &size); err != nil {
+		return fmt.Errorf("failed to get window size: %w", err)
+	}
+	log.Debug().Msg("[Back] wda edge swipe")
+
+	y := size.Height / 2
+	err := r.wda().performTouch(ctx, pointerMove(0, y, 0), pointerDown(), pointerMove(size.Width/2, y, 300), pointerUp())
+	return r.settle(ctx, err)
+}
+
+func (r *IOSDevice) Home(ctx context.Context, deviceID string) error {
+	log.Debug().Msg("[Home] wda homescreen")
+	err := r.wda().do(ctx, http.MethodPost, "/wda/homescreen", map[string]any{}, nil)
+	return r.settle(ctx, err)
+}
+
+func (r *IOSDevice) LaunchApp(ctx context.Context, appName, deviceID string) (bool, error) {
+	bundleID, ok := resolveBundleID(appName)
+	if !ok {
+		return false, fmt.Errorf("app name %s not found in APP_PACKAGES_IOS", appName)
+	}
+	log.Debug().Str("bundle_id", bundleID).Msg("[LaunchApp] wda launch")
+
+	if err := r.wda().sessionDo(ctx, http.MethodPost, "/wda/apps/launch", map[string]any{"bundleId": bundleID}, nil); err != nil {
+		log.Error().Err(err).Msg("failed to launch app")
+		return false, err
+	}
+	return true, r.settle(ctx, nil)
+}
+
+// resolveBundleID finds the bundle ID to launch for appName, which is an app name
+// from APP_PACKAGES_IOS, a bundle ID, or the Android package PhoneAgent resolved
+// the app alias to (com.android.settings for "Settings"), mapped back through its
+// aliases.
+func resolveBundleID(appName string) (string, bool) {
+	if bundleID, ok := constants.APP_PACKAGES_IOS[appName]; ok {
+		return bundleID, true
+	}
+	if aliases, ok := constants.GetAliasesByPackage(appName); ok {
+		for _, alias := range aliases {
+			if bundleID, ok := constants.APP_PACKAGES_IOS[alias]; ok {
+				return bundleID, true
+			}
+		}
+		return "", false
+	}
+	if strings.Contains(appName, ".") && !strings.ContainsAny(appName, " \t/") {
+		return appName, true
+	}
+	return "", false
+}
+
+func (r *IOSDevice) TypeText(ctx context.Context, text, deviceID string) error {
+	log.Debug().Int("len", len(text)).Msg("[TypeText] wda keys")
+	return r.wda().sessionDo(ctx, http.MethodPost, "/wda/keys", map[string]any{"value": []string{text}}, nil)
+}
+
+func (r *IOSDevice) ClearText(ctx context.Context, deviceID string) error {
+	var element map[string]string
+	if err := r.wda().sessionDo(ctx, http.MethodGet, "/element/active", nil, &element); err != nil {
+		return fmt.Errorf("no focused element: %w", err)
+	}
+	id := element[elementKey]
+	if id == "" {
+		id = element["ELEMENT"]
+	}
+	if id == "" {
+		return fmt.Errorf("no focused element")
+	}
+	log.Debug().Str("element", id).Msg("[ClearText] wda clear")
+	return r.wda().sessionDo(ctx, http.MethodPost, "/element/"+id+"/clear", map[string]any{}, nil)
+}
+
+// DetectAndSetADBKeyboard is a no-op: WDA types through the system keyboard.
+func (r *IOSDevice) DetectAndSetADBKeyboard(ctx context.Context, deviceID string) (string, error) {
+	return "", nil
+}
+
+// RestoreKeyboard is a no-op, see DetectAndSetADBKeyboard.
+func (r *IOSDevice) RestoreKeyboard(ctx context.Context, ime, deviceID string) error {
+	return nil
+}
@@ -0,0 +1,230 @@
+package ios
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/spance/autoglm-go/phoneagent"
+	"github.com/spance/autoglm-go/phoneagent/definitions"
+	"github.com/spance/autoglm-go/phoneagent/helper"
+)
+
+var _ phoneagent.Device = (*IOSDevice)(nil)
+
+type wdaRequest struct {
+	Method string
+	Path   string
+	Body   map[string]any
+}
+
+// fakeWDA is a minimal WebDriverAgent speaking the endpoints used by IOSDevice.
+type fakeWDA struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	requests  []wdaRequest
+	sessions  int
+	sessionID string
+	bundleID  string
+}
+
+func newFakeWDA(t *testing.T) *fakeWDA {
+	f := &fakeWDA{bundleID: "com.apple.springboard"}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.handle))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeWDA) handle(w http.ResponseWriter, r *http.Request) {
+	var body map[string]any
+	if data, _ := io.ReadAll(r.Body); len(data) > 0 {
+		_ = json.Unmarshal(data, &body)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, wdaRequest{Method: r.Method, Path: r.URL.Path, Body: body})
+
+	reply := func(status int, value any) {
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(map[string]any{"value": value, "sessionId": f.sessionID})
+	}
+
+	path := r.URL.Path
+	switch {
+	case path == "/status":
+		reply(http.StatusOK, map[string]any{"ready": true, "device": "iphone", "ios": map[string]any{"ip": "192.168.1.20"}})
+	case path == "/session" && r.Method == http.MethodPost:
+		f.sessions++
+		f.sessionID = "session-" + string(rune('0'+f.sessions))
+		reply(http.StatusOK, map[string]any{"sessionId": f.sessionID})
+	case path == "/screenshot":
+		img := image.NewRGBA(image.Rect(0, 0, 30, 60))
+		var buf bytes.Buffer
+		_ = png.Encode(&buf, img)
+		reply(http.StatusOK, base64.StdEncoding.EncodeToString(buf.Bytes()))
+	case path == "/wda/activeAppInfo":
+		reply(http.StatusOK, map[string]any{"bundleId": f.bundleID})
+	case path == "/wda/homescreen":
+		f.bundleID = "com.apple.springboard"
+		reply(http.StatusOK, nil)
+	case strings.HasPrefix(path, "/session/"):
+		rest := strings.TrimPrefix(path, "/session/")
+		id, endpoint, _ := strings.Cut(rest, "/")
+		if id != f.sessionID {
+			reply(http.StatusNotFound, map[string]any{"error": "invalid session id", "message": "Session does not exist"})
+			return
+		}
+		switch endpoint {
+		case "wda/screen":
+			reply(http.StatusOK, map[string]any{"scale": 3})
+		case "window/size":
+			reply(http.StatusOK, map[string]any{"width": 390, "height": 844})
+		case "wda/apps/launch":
+			f.bundleID, _ = body["bundleId"].(string)
+			reply(http.StatusOK, nil)
+		case "element/active":
+			reply(http.StatusOK, map[string]any{elementKey: "E1"})
+		case "actions", "wda/keys", "element/E1/clear":
+			reply(http.StatusOK, nil)
+		default:
+			reply(http.StatusNotFound, map[string]any{"error": "unknown command", "message": endpoint})
+		}
+	default:
+		reply(http.StatusNotFound, map[string]any{"error": "unknown command", "message": path})
+	}
+}
+
+func (f *fakeWDA) last(path string) *wdaRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := len(f.requests) - 1; i >= 0; i-- {
+		if strings.HasSuffix(f.requests[i].Path, path) {
+			return &f.requests[i]
+		}
+	}
+	return nil
+}
+
+func newTestDevice(t *testing.T) (*IOSDevice, *fakeWDA) {
+	wda := newFakeWDA(t)
+	device := NewIOSDevice(wda.URL)
+	device.ActionDelay = 0
+	return device, wda
+}
+
+func TestScreenshotAndCurrentApp(t *testing.T) {
+	device, _ := newTestDevice(t)
+	ctx := context.Background()
+
+	shot, err := device.GetScreenshot(ctx, "")
+	if err != nil {
+		t.Fatalf("GetScreenshot failed: %v", err)
+	}
+	if shot.Width != 30 || shot.Height != 60 || len(shot.BinaryData) == 0 {
+		t.Errorf("unexpected screenshot: %dx%d", shot.Width, shot.Height)
+	}
+
+	app, err := device.GetCurrentApp(ctx, "")
+	if err != nil || app != "System Home" {
+		t.Errorf("expected System Home, got %q, %v", app, err)
+	}
+}
+
+func TestLaunchAppAndTap(t *testing.T) {
+	device, wda := newTestDevice(t)
+	ctx := context.Background()
+
+	if ok, err := device.LaunchApp(ctx, "Safari", ""); !ok || err != nil {
+		t.Fatalf("LaunchApp failed: %v", err)
+	}
+	if app, _ := device.GetCurrentApp(ctx, ""); app != "Safari" {
+		t.Errorf("expected Safari to be active, got %q", app)
+	}
+	if _, err := device.LaunchApp(ctx, "No Such App", ""); err == nil {
+		t.Error("expected unknown app to fail")
+	}
+
+	// Pixels are converted to points with the screen scale
+	if err := device.Tap(ctx, 300, 600, ""); err != nil {
+		t.Fatalf("Tap failed: %v", err)
+	}
+	req := wda.last("/actions")
+	actions := req.Body["actions"].([]any)[0].(map[string]any)["actions"].([]any)
+	move := actions[0].(map[string]any)
+	if move["x"] != float64(100) || move["y"] != float64(200) {
+		t.Errorf("expected tap at (100, 200) points, got %v", move)
+	}
+}
+
+func TestAgentLaunchApp(t *testing.T) {
+	device, wda := newTestDevice(t)
+	agent := phoneagent.NewPhoneAgent(device, &definitions.ModelConfig{}, &definitions.AgentConfig{
+		Lang:   "en",
+		Settle: map[string]definitions.SettlePolicy{"": {}},
+	})
+
+	// The agent resolves app names to Android packages, the device maps them back
+	for app, bundleID := range map[string]string{
+		"Settings":               "com.apple.Preferences",
+		"微信":                     "com.tencent.xin",
+		"Safari":                 "com.apple.mobilesafari",
+		"com.example.custom.app": "com.example.custom.app",
+	} {
+		action := helper.Action{"_metadata": "do", "action": "Launch", "app": app}
+		result, err := agent.ExecuteAction(context.Background(), action, 1170, 2532)
+		if err != nil || !result.Success {
+			t.Fatalf("launching %s failed: %+v, %v", app, result, err)
+		}
+		if req := wda.last("/wda/apps/launch"); req == nil || req.Body["bundleId"] != bundleID {
+			t.Errorf("launching %s: expected bundle %s, got %+v", app, bundleID, req)
+		}
+	}
+}
+
+func TestSessionRecreatedWhenExpired(t *testing.T) {
+	device, wda := newTestDevice(t)
+	ctx := context.Background()
+
+	if err := device.TypeText(ctx, "hello", ""); err != nil {
+		t.Fatalf("TypeText failed: %v", err)
+	}
+	wda.mu.Lock()
+	wda.sessionID = "restarted"
+	wda.mu.Unlock()
+
+	if err := device.ClearText(ctx, ""); err != nil {
+		t.Fatalf("ClearText after session loss failed: %v", err)
+	}
+	if wda.sessions != 2 {
+		t.Errorf("expected the session to be recreated, got %d sessions", wda.sessions)
+	}
+	if keys := wda.last("/wda/keys"); keys == nil || keys.Body["value"].([]any)[0] != "hello" {
+		t.Errorf("unexpected keys request: %+v", keys)
+	}
+}
+
+func TestListDevices(t *testing.T) {
+	device, wda := newTestDevice(t)
+
+	devices, err := device.ListDevices(context.Background())
+	if err != nil || len(devices) != 1 {
+		t.Fatalf("unexpected devices %+v, %v", devices, err)
+	}
+	if devices[0].DeviceID != wda.URL || devices[0].Status != "device" || devices[0].Model != "iphone" {
+		t.Errorf("unexpected device info: %+v", devices[0])
+	}
+	if ip, _ := device.GetDeviceIP(context.Background(), ""); ip != "192.168.1.20" {
+		t.Errorf("unexpected ip %q", ip)
+	}
+}
@@ -0,0 +1,247 @@
+package ios
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// DefaultWdaURL is the address WebDriverAgent listens on when forwarded over USB (iproxy 8100 8100).
+	DefaultWdaURL = "http://localhost:8100"
+
+	// elementKey is the W3C key of an element reference.
+	elementKey = "element-6066-11e4-a52e-4f735466cecf"
+)
+
+// wdaResponse is the envelope of every WebDriverAgent response.
+type wdaResponse struct {
+	Value     json.RawMessage `json:"value"`
+	SessionID string          `json:"sessionId"`
+}
+
+// wdaError is the value of a failed WebDriverAgent response.
+type wdaError struct {
+	Error   string `json:"error"`
+	Message string `json:"message"`
+}
+
+// wdaStatus is the value of GET /status.
+type wdaStatus struct {
+	Ready bool   `json:"ready"`
+	State string `json:"state"`
+	OS    struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	} `json:"os"`
+	IOS struct {
+		IP string `json:"ip"`
+	} `json:"ios"`
+	Device string `json:"device"`
+}
+
+// wdaClient talks to one WebDriverAgent server and keeps its session.
+type wdaClient struct {
+	baseURL string
+	http    *http.Client
+
+	mu        sync.Mutex
+	sessionID string
+	scale     float64
+}
+
+func newWdaClient(baseURL string) *wdaClient {
+	if baseURL == "" {
+		baseURL = DefaultWdaURL
+	}
+	if !strings.Contains(baseURL, "://") {
+		baseURL = "http://" + baseURL
+	}
+	return &wdaClient{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		http:    &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// do sends a request and decodes the value of the response into out (when not nil).
+func (c *wdaClient) do(ctx context.Context, method, path string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	log.Debug().Str("method", method).Str("path", path).Msg("[WDA] request")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("wda request %s %s failed: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var envelope wdaResponse
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return fmt.Errorf("wda %s %s: invalid response (status %d): %w", method, path, resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e wdaError
+		_ = json.Unmarshal(envelope.Value, &e)
+		return &WdaError{Status: resp.StatusCode, Code: e.Error, Message: e.Message}
+	}
+	if out != nil && len(envelope.Value) > 0 {
+		if err := json.Unmarshal(envelope.Value, out); err != nil {
+			return fmt.Errorf("wda %s %s: invalid value: %w", method, path, err)
+		}
+	}
+	return nil
+}
+
+// WdaError is returned when WebDriverAgent answers with an error status.
+type WdaError struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *WdaError) Error() string {
+	return fmt.Sprintf("wda error %d %s: %s", e.Status, e.Code, e.Message)
+}
+
+func isInvalidSession(err error) bool {
+	e, ok := err.(*WdaError)
+	return ok && (e.Code == "invalid session id" || e.Status == http.StatusNotFound)
+}
+
+func (c *wdaClient) status(ctx context.Context) (*wdaStatus, error) {
+	var status wdaStatus
+	if err := c.do(ctx, http.MethodGet, "/status", nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// session returns the current session ID, creating a session if needed.
+func (c *wdaClient) session(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.sessionID != "" {
+		return c.sessionID, nil
+	}
+
+	var value struct {
+		SessionID string `json:"sessionId"`
+	}
+	body := map[string]any{"capabilities": map[string]any{"alwaysMatch": map[string]any{}}}
+	if err := c.do(ctx, http.MethodPost, "/session", body, &value); err != nil {
+		return "", fmt.Errorf("failed to create wda session: %w", err)
+	}
+	if value.SessionID == "" {
+		return "", fmt.Errorf("wda returned no session id")
+	}
+	log.Debug().Str("session", value.SessionID).Msg("[WDA] session created")
+	c.sessionID = value.SessionID
+	return c.sessionID, nil
+}
+
+// sessionDo sends a request under /session/{id}, recreating the session once if it expired.
+func (c *wdaClient) sessionDo(ctx context.Context, method, path string, body, out any) error {
+	for attempt := 0; ; attempt++ {
+		id, err := c.session(ctx)
+		if err != nil {
+			return err
+		}
+		err = c.do(ctx, method, "/session/"+id+path, body, out)
+		if err == nil || attempt > 0 || !isInvalidSession(err) {
+			return err
+		}
+		c.mu.Lock()
+		if c.sessionID == id {
+			c.sessionID = ""
+		}
+		c.mu.Unlock()
+	}
+}
+
+// deleteSession closes the current session, if any.
+func (c *wdaClient) deleteSession(ctx context.Context) error {
+	c.mu.Lock()
+	id := c.sessionID
+	c.sessionID = ""
+	c.mu.Unlock()
+	if id == "" {
+		return nil
+	}
+	return c.do(ctx, http.MethodDelete, "/session/"+id, nil, nil)
+}
+
+// screenScale returns the ratio between screenshot pixels and WDA points.
+func (c *wdaClient) screenScale(ctx context.Context) float64 {
+	c.mu.Lock()
+	scale := c.scale
+	c.mu.Unlock()
+	if scale > 0 {
+		return scale
+	}
+
+	var screen struct {
+		Scale float64 `json:"scale"`
+	}
+	if err := c.sessionDo(ctx, http.MethodGet, "/wda/screen", nil, &screen); err != nil || screen.Scale <= 0 {
+		log.Warn().Err(err).Msg("[WDA] failed to get screen scale, assuming 1")
+		return 1
+	}
+	c.mu.Lock()
+	c.scale = screen.Scale
+	c.mu.Unlock()
+	return screen.Scale
+}
+
+// pointerAction is one step of a W3C touch action sequence.
+type pointerAction map[string]any
+
+func pointerMove(x, y, durationMs int) pointerAction {
+	return pointerAction{"type": "pointerMove", "duration": durationMs, "x": x, "y": y}
+}
+
+func pointerDown() pointerAction { return pointerAction{"type": "pointerDown", "button": 0} }
+
+func pointerUp() pointerAction { return pointerAction{"type": "pointerUp", "button": 0} }
+
+func pause(durationMs int) pointerAction {
+	return pointerAction{"type": "pause", "duration": durationMs}
+}
+
+// performTouch runs a single-finger W3C action sequence.
+func (c *wdaClient) performTouch(ctx context.Context, actions ...pointerAction) error {
+	body := map[string]any{
+		"actions": []map[string]any{{
+			"type":       "pointer",
+			"id":         "finger1",
+			"parameters": map[string]any{"pointerType": "touch"},
+			"actions":    actions,
+		}},
+	}
+	return c.sessionDo(ctx, http.MethodPost, "/actions", body, nil)
+}
@@ -10,6 +10,7 @@ require (
 	github.com/sashabaranov/go-openai v1.41.2
 	github.com/spf13/cobra v1.10.2
 	github.com/valyala/fasttemplate v1.2.2
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -1,14 +1,18 @@
 package main
 
 import (
-	"bufio"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
 	"sort"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/rs/zerolog"
@@ -17,9 +21,11 @@ import (
 	"github.com/sashabaranov/go-openai"
 	"github.com/spance/autoglm-go/constants"
 	"github.com/spance/autoglm-go/examples"
+	"github.com/spance/autoglm-go/examples/android/adb"
 	"github.com/spance/autoglm-go/phoneagent"
 	"github.com/spance/autoglm-go/phoneagent/definitions"
 	"github.com/spance/autoglm-go/phoneagent/helper"
+	"github.com/spance/autoglm-go/phoneagent/redact"
 	"github.com/spance/autoglm-go/utils"
 	"github.com/spf13/cobra"
 )
@@ -46,6 +52,46 @@ type Config struct {
 	DeviceType string `json:"device_type"`
 	Task       string `json:"task"`
 	Debug      bool   `json:"debug"`
+	Stream     bool   `json:"stream"`
+	RecordDir  string `json:"record_dir"`
+	Session    string `json:"session"`
+	Resume     string `json:"resume"`
+
+	ADBTransport string `json:"adb_transport"`
+	ADBServer    string `json:"adb_server"`
+
+	CallAPIAsResult   bool `json:"call_api_as_result"`
+	ScreenshotRetries int  `json:"screenshot_retries"`
+
+	History          string `json:"history"`
+	HistoryTurns     int    `json:"history_turns"`
+	HistoryMaxTokens int    `json:"history_max_tokens"`
+
+	ImageMaxEdge   int    `json:"image_max_edge"`
+	ImageFormat    string `json:"image_format"`
+	ImageQuality   int    `json:"image_quality"`
+	ImageGrayscale bool   `json:"image_grayscale"`
+
+	SensitivePolicy string `json:"sensitive_policy"`
+	RedactRegions   string `json:"redact_regions"`
+
+	UIElements bool `json:"ui_elements"`
+	SnapTaps   bool `json:"snap_taps"`
+
+	TaskTimeout time.Duration `json:"task_timeout"`
+
+	Settle string `json:"settle"`
+
+	Devices     string `json:"devices"`
+	Parallelism int    `json:"parallelism"`
+
+	TasksFile    string `json:"tasks_file"`
+	RunMode      string `json:"run_mode"`
+	Report       string `json:"report"`
+	ReportFormat string `json:"report_format"`
+
+	// Command is the subcommand selected on the command line ("" for the root command)
+	Command string `json:"command,omitempty"`
 }
 
 var rootCmd = &cobra.Command{
@@ -133,6 +179,16 @@ func getEnvFloat32(key string, defaultValue float32) float32 {
 	return defaultValue
 }
 
+// Helper function to get environment variable as duration with default value
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if duration, err := time.ParseDuration(value); err == nil {
+			return duration
+		}
+	}
+	return defaultValue
+}
+
 func init() {
 	// Model options
 	rootCmd.PersistentFlags().StringVar(&config.BaseURL, "base-url",
@@ -165,6 +221,27 @@ func init() {
 	rootCmd.PersistentFlags().BoolVar(&config.ListDevices, "list-devices", false,
 		"List connected devices and exit")
 
+	rootCmd.PersistentFlags().StringVar(&config.Devices, "devices",
+		getEnv("PHONE_AGENT_DEVICES", ""),
+		"Run the task on several devices at once: comma-separated device IDs, or 'all' for every connected device")
+
+	rootCmd.PersistentFlags().IntVar(&config.Parallelism, "parallelism",
+		getEnvInt("PHONE_AGENT_PARALLELISM", 0),
+		"Maximum number of devices running at once with --devices (0: all)")
+
+	// Batch options
+	rootCmd.PersistentFlags().StringVar(&config.TasksFile, "tasks-file", "",
+		"Run the tasks of a .yaml or .jsonl file (keys: id, task, device, max_steps, lang, expect)")
+
+	rootCmd.PersistentFlags().StringVar(&config.RunMode, "run-mode", "each",
+		"How tasks are spread over --devices: each (every device runs every task) or shared (each task runs once, on the next free device)")
+
+	rootCmd.PersistentFlags().StringVar(&config.Report, "report", "",
+		"Write a summary report of --tasks-file or --devices runs to this file")
+
+	rootCmd.PersistentFlags().StringVar(&config.ReportFormat, "report-format", "",
+		"Report format: json or junit (default: junit for .xml files, json otherwise)")
+
 	// For enable-tcpip, we need custom handling to support optional argument
 	rootCmd.PersistentFlags().IntVar(&config.EnableTCPIP, "enable-tcpip", 0,
 		"Enable TCP/IP debugging on USB device (default port: 5555, use 0 for default)")
@@ -202,9 +279,96 @@ func init() {
 		"Device type: adb for Android, ios for iPhone (default: adb)",
 	)
 
+	rootCmd.PersistentFlags().StringVar(&config.ADBTransport, "adb-transport",
+		getEnv("PHONE_AGENT_ADB_TRANSPORT", examples.ADBTransportExec),
+		"How to reach the adb server: exec (run the adb binary) or native (speak its socket protocol)")
+
+	rootCmd.PersistentFlags().StringVar(&config.ADBServer, "adb-server",
+		getEnv("PHONE_AGENT_ADB_SERVER", adb.DefaultServerAddr),
+		"adb server address used by --adb-transport native")
+
 	rootCmd.PersistentFlags().BoolVar(&config.Debug, "debug", false,
 		"Enable debug mode (default: false)")
 
+	rootCmd.PersistentFlags().BoolVar(&config.Stream, "stream",
+		getEnv("PHONE_AGENT_STREAM", "false") == "true",
+		"Stream model responses to measure real TTFT and follow the thinking live (env PHONE_AGENT_STREAM=true)")
+
+	rootCmd.PersistentFlags().StringVar(&config.RecordDir, "record-dir",
+		getEnv("PHONE_AGENT_RECORD_DIR", ""),
+		"Record each run (screenshots, messages, actions) into this directory")
+
+	rootCmd.PersistentFlags().StringVar(&config.Session, "session",
+		getEnv("PHONE_AGENT_SESSION", ""),
+		"Save the agent session to this file after every step")
+
+	rootCmd.PersistentFlags().StringVar(&config.Resume, "resume", "",
+		"Resume an interrupted task from a session file saved with --session")
+
+	rootCmd.PersistentFlags().BoolVar(&config.CallAPIAsResult, "call-api-as-result", false,
+		"Use the latest call_api summary as the task result")
+
+	rootCmd.PersistentFlags().IntVar(&config.ScreenshotRetries, "screenshot-retries",
+		getEnvInt("PHONE_AGENT_SCREENSHOT_RETRIES", definitions.DefaultScreenshotRetries),
+		"Retries with backoff when a screenshot fails before aborting the task (-1: no retry)")
+
+	// Context window options
+	rootCmd.PersistentFlags().StringVar(&config.History, "history",
+		getEnv("PHONE_AGENT_HISTORY", "full"),
+		"History strategy: full, window (keep last turns) or summary (summarize older turns)")
+
+	rootCmd.PersistentFlags().IntVar(&config.HistoryTurns, "history-turns",
+		getEnvInt("PHONE_AGENT_HISTORY_TURNS", definitions.DefaultHistoryTurns),
+		"Number of recent turns kept when history is compacted")
+
+	rootCmd.PersistentFlags().IntVar(&config.HistoryMaxTokens, "history-max-tokens",
+		getEnvInt("PHONE_AGENT_HISTORY_MAX_TOKENS", 0),
+		"Compact history only when the estimated tokens exceed this value (0: whenever turns exceed --history-turns, twice as many with summary)")
+
+	// Screenshot processing options
+	rootCmd.PersistentFlags().IntVar(&config.ImageMaxEdge, "image-max-edge",
+		getEnvInt("PHONE_AGENT_IMAGE_MAX_EDGE", 0),
+		"Downscale screenshots sent to the model so the long edge is at most this many pixels (0: keep size)")
+
+	rootCmd.PersistentFlags().StringVar(&config.ImageFormat, "image-format",
+		getEnv("PHONE_AGENT_IMAGE_FORMAT", ""),
+		"Encoding of screenshots sent to the model: png or jpeg (default: as captured)")
+
+	rootCmd.PersistentFlags().IntVar(&config.ImageQuality, "image-quality",
+		getEnvInt("PHONE_AGENT_IMAGE_QUALITY", definitions.DefaultImageQuality),
+		"JPEG quality (1-100)")
+
+	rootCmd.PersistentFlags().BoolVar(&config.ImageGrayscale, "image-grayscale",
+		getEnv("PHONE_AGENT_IMAGE_GRAYSCALE", "false") == "true",
+		"Send grayscale screenshots to the model")
+
+	// Privacy options
+	rootCmd.PersistentFlags().StringVar(&config.SensitivePolicy, "sensitive-policy",
+		getEnv("PHONE_AGENT_SENSITIVE_POLICY", "skip-image"),
+		"Reaction to protected screens: skip-image (withhold the screenshot), takeover (ask the user) or send")
+
+	rootCmd.PersistentFlags().StringVar(&config.RedactRegions, "redact-regions",
+		getEnv("PHONE_AGENT_REDACT_REGIONS", ""),
+		"Screen regions pixelated before screenshots are recorded or sent, in 0-1000 coordinates: \"x1,y1,x2,y2;...\"")
+
+	// UI hierarchy options
+	rootCmd.PersistentFlags().BoolVar(&config.UIElements, "ui-elements",
+		getEnv("PHONE_AGENT_UI_ELEMENTS", "false") == "true",
+		"Send the list of on-screen elements from the accessibility tree with each step")
+
+	rootCmd.PersistentFlags().BoolVar(&config.SnapTaps, "snap-taps",
+		getEnv("PHONE_AGENT_SNAP_TAPS", "false") == "true",
+		"Move taps onto the nearest clickable element from the accessibility tree")
+
+	// Cancellation options
+	rootCmd.PersistentFlags().DurationVar(&config.TaskTimeout, "task-timeout",
+		getEnvDuration("PHONE_AGENT_TASK_TIMEOUT", 0),
+		"Maximum duration of a task, e.g. 10m; 0 means no limit")
+
+	// Settle options
+	rootCmd.PersistentFlags().StringVar(&config.Settle, "settle",
+		getEnv("PHONE_AGENT_SETTLE", ""),
+		"Wait after actions until the screen settles, per action: \"default=200ms-3s,Launch=1s-8s,Back=300ms\"; a single duration waits a fixed time")
 }
 
 func main() {
@@ -237,7 +401,11 @@ func main() {
 		return
 	}
 
-	device, err := examples.CreateDevice(config.DeviceType)
+	device, err := examples.CreateDevice(config.DeviceType, examples.Options{
+		WdaURL:       config.WdaUrl,
+		ADBTransport: config.ADBTransport,
+		ADBServer:    config.ADBServer,
+	})
 	if err != nil {
 		log.Error().Err(err).Msg("creating device failed")
 		return
@@ -248,13 +416,27 @@ func main() {
 		return
 	}
 
-	if passed := checkSystemRequirements(ctx, config.DeviceType, config.WdaUrl); !passed {
+	// With the native transport the checks talk to the adb server, not the adb binary
+	var adbClient *adb.Client
+	if config.DeviceType == constants.ADB && config.ADBTransport == examples.ADBTransportNative {
+		adbClient = adb.NewClient(config.ADBServer)
+	}
+	if passed := checkSystemRequirements(ctx, config.DeviceType, config.WdaUrl, adbClient); !passed {
 		log.Info().Msg(strings.Repeat("-", 50))
 		log.Error().Msg("❌ System check failed. Please fix the issues above.")
 		log.Error().Msg("❌ check system requirements failed")
 		return
 	}
 
+	if config.Command == "replay" {
+		replayCtx, stop := interruptible(ctx)
+		defer stop()
+		if err := runReplay(replayCtx, device); err != nil {
+			log.Error().Err(err).Msg("❌ Replay failed")
+		}
+		return
+	}
+
 	if passed := checkModelAPI(ctx, config.BaseURL, config.Model, config.APIKey); !passed {
 		log.Error().Msg("❌ Model API check failed. Please fix the issues above.")
 		log.Error().Msg("❌ check model api failed")
@@ -270,23 +452,114 @@ func main() {
 		Temperature:      getEnvFloat32("PHONE_AGENT_TEMPERATURE", 0.0),
 		TopP:             getEnvFloat32("PHONE_AGENT_TOP_P", 0.85),
 		FrequencyPenalty: getEnvFloat32("PHONE_AGENT_FREQUENCY_PENALTY", 0.2),
+		Stream:           config.Stream,
 	}
 	agentConfig := &definitions.AgentConfig{
 		MaxSteps: config.MaxSteps,
 		DeviceID: config.DeviceID,
 		Lang:     config.Lang,
 		WdaUrl:   config.WdaUrl,
+
+		TrajectoryDir: config.RecordDir,
+		SessionPath:   config.Session,
+
+		CallAPIAsResult:   config.CallAPIAsResult,
+		ScreenshotRetries: config.ScreenshotRetries,
+
+		HistoryTurns:     config.HistoryTurns,
+		HistoryMaxTokens: config.HistoryMaxTokens,
+
+		UIElements: config.UIElements,
+		SnapTaps:   config.SnapTaps,
+
+		TaskTimeout: config.TaskTimeout,
+
+		Image: definitions.ImageOptions{
+			MaxLongEdge: config.ImageMaxEdge,
+			Format:      definitions.ImageFormat(config.ImageFormat),
+			Grayscale:   config.ImageGrayscale,
+		},
+	}
+	if !agentConfig.Image.IsZero() {
+		agentConfig.Image.Quality = config.ImageQuality
+	}
+	if config.SensitivePolicy != "skip-image" {
+		agentConfig.SensitivePolicy = definitions.SensitivePolicy(config.SensitivePolicy)
+	}
+	if config.History != "full" {
+		agentConfig.HistoryStrategy = definitions.HistoryStrategy(config.History)
+	}
+	// Keep saving a resumed session to the file it was loaded from
+	if config.Resume != "" && agentConfig.SessionPath == "" {
+		agentConfig.SessionPath = config.Resume
 	}
 
-	phoneAgent := phoneagent.NewPhoneAgent(device, modelConfig, agentConfig)
+	agentConfig.Settle, _ = parseSettle(config.Settle)
+
+	var opts []phoneagent.Option
+	if regions, _ := parseRegions(config.RedactRegions); len(regions) > 0 {
+		opts = append(opts, phoneagent.WithRedactor(redact.Regions{Regions: regions}))
+	}
+
+	if config.Command == "serve" {
+		if err := runServer(ctx, device, modelConfig, agentConfig, opts); err != nil {
+			log.Error().Err(err).Msg("❌ Server failed")
+		}
+		return
+	}
+
+	phoneAgent := phoneagent.NewPhoneAgent(device, modelConfig, agentConfig, opts...)
 
 	// Print configuration information
 	printConfiguration(ctx, phoneAgent)
 
-	// Run with provided task or enter interactive mode
-	if config.Task != "" {
+	// Resume an interrupted task, run the provided task or enter interactive mode
+	if config.Resume != "" {
+		session, err := phoneagent.LoadSession(config.Resume)
+		if err != nil {
+			log.Error().Err(err).Msg("Error loading session")
+			return
+		}
+		if err := phoneAgent.RestoreSession(session); err != nil {
+			log.Error().Err(err).Str("session", config.Resume).Msg("Error restoring session")
+			return
+		}
+		log.Info().Str("task", session.Task).Int("step", session.StepCount).Msg("Resuming task")
+		taskCtx, stop := interruptible(ctx)
+		result, err := phoneAgent.Resume(taskCtx)
+		stop()
+		if err != nil {
+			log.Error().Err(err).Msg("Error resuming task")
+			return
+		}
+		log.Info().Msgf("🎉 %s: %s", helper.GetMessage("result", config.Lang), result)
+	} else if config.TasksFile != "" || config.Devices != "" {
+		tasks := []phoneagent.Task{{Prompt: config.Task}}
+		if config.TasksFile != "" {
+			if tasks, err = phoneagent.LoadTasks(config.TasksFile); err != nil {
+				log.Error().Err(err).Msg("Error loading tasks")
+				return
+			}
+		}
+		taskCtx, stop := interruptible(ctx)
+		report, err := runOnDevices(taskCtx, device, modelConfig, agentConfig, opts, tasks)
+		stop()
+		if err != nil {
+			log.Error().Err(err).Msg("Error running tasks")
+			return
+		}
+		if config.Report != "" {
+			if err := writeReport(report); err != nil {
+				log.Error().Err(err).Msg("Error writing report")
+				return
+			}
+			log.Info().Str("report", config.Report).Str("format", reportFormat()).Msg("Report written")
+		}
+	} else if config.Task != "" {
 		log.Info().Str("task", config.Task).Msg("Task")
-		result, err := phoneAgent.Run(ctx, config.Task)
+		taskCtx, stop := interruptible(ctx)
+		result, err := phoneAgent.Run(taskCtx, config.Task)
+		stop()
 		if err != nil {
 			log.Error().Err(err).Msg("Error running task")
 			return
@@ -296,17 +569,18 @@ func main() {
 		// Interactive mode
 		log.Info().Msg("Entering interactive mode. Type 'quit' to exit.")
 
-		reader := bufio.NewReader(os.Stdin)
+		// Read through the handler prompting for confirmations, so that a prompt
+		// abandoned by a canceled task does not eat the next task line
+		stdin := phoneagent.DefaultStdinHandler()
 		for {
-			fmt.Print("Enter your task: ")
-			task, err := reader.ReadString('\n')
+			task, err := stdin.ReadLine(ctx, "Enter your task: ")
 			if err != nil {
-				log.Error().Err(err).Msg("Error reading input")
-				continue
+				if !errors.Is(err, io.EOF) {
+					log.Error().Err(err).Msg("Error reading input")
+				}
+				break
 			}
 
-			task = strings.TrimSpace(task)
-
 			// Check for quit commands
 			if strings.ToLower(task) == "quit" || strings.ToLower(task) == "exit" || strings.ToLower(task) == "q" {
 				log.Info().Msg("Goodbye!")
@@ -319,16 +593,18 @@ func main() {
 			}
 
 			fmt.Println()
-			result, err := phoneAgent.Run(ctx, task)
+			// Ctrl-C stops the task and comes back to the prompt
+			taskCtx, stop := interruptible(ctx)
+			result, err := phoneAgent.Run(taskCtx, task)
+			stop()
+			// Reset agent for next task, also after a failed or canceled one
+			phoneAgent.Reset(ctx)
 			if err != nil {
 				log.Error().Err(err).Msg("Error")
 				continue
 			}
 
 			log.Info().Msgf("🎉 %s: %s", helper.GetMessage("result", config.Lang), result)
-
-			// Reset agent for next task
-			phoneAgent.Reset(ctx)
 		}
 	}
 
@@ -350,20 +626,133 @@ func validateArgs(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("invalid language option: %s. Must be 'cn' or 'en'", config.Lang)
 	}
 
-	// only allow adb for now
-	if config.DeviceType != "adb" {
-		return fmt.Errorf("invalid device type: %s. Must be 'adb'", config.DeviceType)
+	if config.History != "full" && config.History != string(definitions.HistoryWindow) && config.History != string(definitions.HistorySummary) {
+		return fmt.Errorf("invalid history option: %s. Must be 'full', 'window' or 'summary'", config.History)
+	}
+
+	if config.DeviceType != constants.ADB && config.DeviceType != constants.IOS {
+		return fmt.Errorf("invalid device type: %s. Must be 'adb' or 'ios'", config.DeviceType)
+	}
+
+	switch definitions.ImageFormat(config.ImageFormat) {
+	case definitions.ImageOriginal, definitions.ImagePNG, definitions.ImageJPEG:
+	default:
+		return fmt.Errorf("invalid image format: %s. Must be 'png' or 'jpeg'", config.ImageFormat)
+	}
+	if config.ImageQuality < 1 || config.ImageQuality > 100 {
+		return fmt.Errorf("invalid image quality: %d. Must be between 1 and 100", config.ImageQuality)
+	}
+
+	if config.ADBTransport != examples.ADBTransportExec && config.ADBTransport != examples.ADBTransportNative {
+		return fmt.Errorf("invalid adb transport: %s. Must be 'exec' or 'native'", config.ADBTransport)
+	}
+
+	if config.Devices != "" && config.Task == "" && config.TasksFile == "" {
+		return fmt.Errorf("--devices requires a task or --tasks-file")
+	}
+	if config.TasksFile != "" && config.Task != "" {
+		return fmt.Errorf("--tasks-file cannot be combined with a task argument")
+	}
+	if config.RunMode != "each" && config.RunMode != string(phoneagent.RunShared) {
+		return fmt.Errorf("invalid run mode: %s. Must be 'each' or 'shared'", config.RunMode)
+	}
+	if config.ReportFormat != "" && config.ReportFormat != "json" && config.ReportFormat != "junit" {
+		return fmt.Errorf("invalid report format: %s. Must be 'json' or 'junit'", config.ReportFormat)
+	}
+	if config.TaskTimeout < 0 {
+		return fmt.Errorf("invalid task timeout: %s. Must not be negative", config.TaskTimeout)
+	}
+	if config.Parallelism < 0 {
+		return fmt.Errorf("invalid parallelism: %d. Must not be negative", config.Parallelism)
+	}
+
+	if config.SensitivePolicy != "skip-image" && config.SensitivePolicy != string(definitions.SensitiveTakeover) && config.SensitivePolicy != string(definitions.SensitiveSend) {
+		return fmt.Errorf("invalid sensitive policy: %s. Must be 'skip-image', 'takeover' or 'send'", config.SensitivePolicy)
+	}
+	if _, err := parseRegions(config.RedactRegions); err != nil {
+		return err
+	}
+	if _, err := parseSettle(config.Settle); err != nil {
+		return err
 	}
 
 	return nil
 }
 
+// interruptible returns a context canceled by Ctrl-C or SIGTERM, so that the running
+// task stops at the next opportunity and restores the keyboard. Once interrupted, a
+// second Ctrl-C terminates the process as usual.
+func interruptible(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	context.AfterFunc(ctx, stop)
+	return ctx, stop
+}
+
+// parseRegions parses "x1,y1,x2,y2;..." into redaction regions.
+func parseRegions(value string) ([]redact.Region, error) {
+	var regions []redact.Region
+	for _, item := range strings.Split(value, ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		var region redact.Region
+		if _, err := fmt.Sscanf(item, "%d,%d,%d,%d", &region.X1, &region.Y1, &region.X2, &region.Y2); err != nil {
+			return nil, fmt.Errorf("invalid redact region %q: %w", item, err)
+		}
+		if region.X1 < 0 || region.Y1 < 0 || region.X2 > 1000 || region.Y2 > 1000 || region.X1 >= region.X2 || region.Y1 >= region.Y2 {
+			return nil, fmt.Errorf("invalid redact region %q: must be x1,y1,x2,y2 within 0-1000", item)
+		}
+		regions = append(regions, region)
+	}
+	return regions, nil
+}
+
+// parseSettle parses "action=min-max,..." into settle policies; "default" stands for
+// the other actions and a single duration means a fixed wait.
+func parseSettle(value string) (map[string]definitions.SettlePolicy, error) {
+	policies := make(map[string]definitions.SettlePolicy)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		action, durations, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid settle policy %q: must be action=min-max", item)
+		}
+		action = strings.TrimSpace(action)
+		if action == "default" {
+			action = ""
+		}
+		minValue, maxValue, _ := strings.Cut(durations, "-")
+		var policy definitions.SettlePolicy
+		var err error
+		if policy.Min, err = time.ParseDuration(strings.TrimSpace(minValue)); err != nil {
+			return nil, fmt.Errorf("invalid settle policy %q: %w", item, err)
+		}
+		if maxValue != "" {
+			if policy.Max, err = time.ParseDuration(strings.TrimSpace(maxValue)); err != nil {
+				return nil, fmt.Errorf("invalid settle policy %q: %w", item, err)
+			}
+		}
+		if policy.Min < 0 || policy.Max < 0 {
+			return nil, fmt.Errorf("invalid settle policy %q: durations must not be negative", item)
+		}
+		policies[action] = policy
+	}
+	if len(policies) == 0 {
+		return nil, nil
+	}
+	return policies, nil
+}
+
 func handleDeviceCommands(ctx context.Context, device phoneagent.Device) bool {
 	deviceType := config.DeviceType
 
 	// 处理iOS特定命令
-	if deviceType == constants.IOS {
-		return handleIOSDeviceCommands(ctx)
+	if deviceType == constants.IOS && handleIOSDeviceCommands(ctx, device) {
+		return true
 	}
 
 	// 处理 --list-devices
@@ -456,12 +845,50 @@ func handleDeviceCommands(ctx context.Context, device phoneagent.Device) bool {
 	return false
 }
 
-func handleIOSDeviceCommands(ctx context.Context) bool {
-	// todo
+func handleIOSDeviceCommands(ctx context.Context, device phoneagent.Device) bool {
+	// 处理 --pair
+	if config.Pair {
+		log.Info().Msg("Pairing with iOS device...")
+		output, err := exec.CommandContext(ctx, "idevicepair", "pair").CombinedOutput()
+		if err != nil {
+			log.Error().Err(err).Str("output", strings.TrimSpace(string(output))).Msg("❌ Pairing failed")
+			log.Info().Msg("   Unlock the device, tap 'Trust This Computer' and try again")
+		} else {
+			log.Info().Msgf("✅ %s", strings.TrimSpace(string(output)))
+		}
+		return true
+	}
+
+	// 处理 --wda-status
+	if config.WdaStatus {
+		log.Info().Msgf("Checking WebDriverAgent at %s...", config.WdaUrl)
+		info, err := device.GetDeviceInfo(ctx, config.DeviceID)
+		if err != nil {
+			log.Error().Err(err).Msg("❌ WebDriverAgent is not reachable")
+			log.Info().Msg("   Start WebDriverAgent from Xcode and forward the port: iproxy 8100 8100")
+			return true
+		}
+		if info.Status != "device" {
+			log.Error().Msg("❌ WebDriverAgent is not ready")
+			return true
+		}
+		log.Info().Msg("✅ WebDriverAgent is ready")
+		if info.Model != "" {
+			log.Info().Msgf("   Device: %s", info.Model)
+		}
+		if ip, err := device.GetDeviceIP(ctx, config.DeviceID); err == nil && ip != "" {
+			log.Info().Msgf("   IP: %s", ip)
+		}
+		return true
+	}
+
 	return false
 }
 
-func checkSystemRequirements(ctx context.Context, deviceType string, wdaURL string) bool {
+// checkSystemRequirements checks the device tools, the connected devices and the
+// input method. A non-nil adbClient runs the ADB checks through the adb server
+// instead of the adb binary.
+func checkSystemRequirements(ctx context.Context, deviceType string, wdaURL string, adbClient *adb.Client) bool {
 	log.Info().Msg("🔍 Checking system requirements...")
 	log.Info().Msg(strings.Repeat("-", 50))
 
@@ -476,52 +903,39 @@ func checkSystemRequirements(ctx context.Context, deviceType string, wdaURL stri
 	}
 
 	// Check 1: Tool installed
-	log.Info().Msgf("1. Checking %s installation... ", toolName)
-	_, err := exec.LookPath(toolCmd)
-	if err != nil {
-		log.Error().Msg("❌ FAILED")
-		log.Info().Msgf("   Error: %s is not installed or not in PATH.", toolName)
-		log.Info().Msgf("   Solution: Install %s:", toolName)
-		if deviceType == constants.ADB {
-			log.Info().Msg("     - macOS: brew install android-platform-tools")
-			log.Info().Msg("     - Linux: sudo apt install android-tools-adb")
-			log.Info().Msg("     - Windows: Download from https://developer.android.com/studio/releases/platform-tools")
-		} else { // IOS
-			log.Info().Msg("     - macOS: brew install libimobiledevice")
-			log.Info().Msg("     - Linux: sudo apt-get install libimobiledevice-utils")
+	if adbClient != nil {
+		log.Info().Msgf("1. Checking ADB server (%s)... ", adbClient.Addr)
+		version, err := adbClient.HostCommand(ctx, "host:version")
+		if err != nil {
+			log.Error().Msg("❌ FAILED")
+			log.Info().Msgf("   Error: ADB server is not reachable: %v", err)
+			log.Info().Msg("   Solution: Start the server with adb start-server, or pass its address with --adb-server")
+			return false
 		}
+		log.Info().Msgf("✅ OK (protocol version %s)", version)
+	} else if !checkToolInstalled(deviceType, toolName, toolCmd) {
 		return false
 	}
-	// Double check by running version command
-	var versionCmd *exec.Cmd
-	if deviceType == constants.ADB {
-		versionCmd = exec.Command(toolCmd, "version")
-	} else { // IOS
-		versionCmd = exec.Command(toolCmd, "-ln")
-	}
-
-	output, err := versionCmd.Output()
-	if err != nil {
-		log.Error().Msg("❌ FAILED")
-		log.Info().Msgf("   Error: %s command failed to run: %v", toolName, err)
-		return false
-	}
-	lines := strings.Split(string(output), "\n")
-	versionLine := ""
-	if len(lines) > 0 {
-		versionLine = strings.TrimSpace(lines[0])
-	}
-	if versionLine == "" {
-		versionLine = "installed"
-	}
-	log.Info().Msgf("✅ OK (%s)", versionLine)
 
 	// Check 2: Device connected
 	log.Info().Msg("2. Checking connected devices... ")
 	var devices []string
 	var deviceIDs []string
 
-	if deviceType == constants.ADB {
+	if adbClient != nil {
+		entries, err := adbClient.Devices(ctx)
+		if err != nil {
+			log.Error().Msg("❌ FAILED")
+			log.Info().Msgf("   Error: listing devices failed: %v", err)
+			return false
+		}
+		for _, entry := range entries {
+			if entry.State == "device" {
+				devices = append(devices, entry.Serial)
+				deviceIDs = append(deviceIDs, entry.Serial)
+			}
+		}
+	} else if deviceType == constants.ADB {
 		cmd := exec.Command("adb", "devices")
 		output, err := cmd.CombinedOutput() // 捕获 stdout + stderr
 		if err != nil {
@@ -541,7 +955,20 @@ func checkSystemRequirements(ctx context.Context, deviceType string, wdaURL stri
 			}
 		}
 	} else { // IOS
-		// todo
+		cmd := exec.Command("idevice_id", "-l")
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			log.Error().Msg("❌ FAILED")
+			log.Info().Msgf("   Error: %s command failed: %v", toolName, err)
+			return false
+		}
+		for _, line := range strings.Split(string(output), "\n") {
+			line = strings.TrimSpace(line)
+			if line != "" {
+				devices = append(devices, line)
+				deviceIDs = append(deviceIDs, line)
+			}
+		}
 	}
 
 	if len(devices) == 0 {
@@ -570,8 +997,13 @@ func checkSystemRequirements(ctx context.Context, deviceType string, wdaURL stri
 	// Check 3: ADB Keyboard installed (only for ADB) or WebDriverAgent (for iOS)
 	if deviceType == constants.ADB {
 		log.Info().Msg("3. Checking ADB Keyboard... ")
-		cmd := exec.Command("adb", "shell", "ime", "list", "-s")
-		output, err := cmd.CombinedOutput()
+		var output []byte
+		var err error
+		if adbClient != nil {
+			output, err = adbClient.Shell(ctx, "", "ime list -s")
+		} else {
+			output, err = exec.Command("adb", "shell", "ime", "list", "-s").CombinedOutput()
+		}
 		if err != nil {
 			log.Error().Msg("❌ FAILED")
 			log.Info().Msgf("   Error: ADB command timed out: %v", err)
@@ -593,7 +1025,30 @@ func checkSystemRequirements(ctx context.Context, deviceType string, wdaURL stri
 		}
 
 	} else { // IOS
-		// todo
+		log.Info().Msgf("3. Checking WebDriverAgent (%s)... ", wdaURL)
+		statusCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		req, err := http.NewRequestWithContext(statusCtx, http.MethodGet, strings.TrimRight(wdaURL, "/")+"/status", nil)
+		var resp *http.Response
+		if err == nil {
+			resp, err = http.DefaultClient.Do(req)
+		}
+		if err != nil {
+			log.Error().Msg("❌ FAILED")
+			log.Info().Msgf("   Error: WebDriverAgent is not reachable: %v", err)
+			log.Info().Msg("   Solution:")
+			log.Info().Msg("     1. Build and run WebDriverAgentRunner on the device from Xcode")
+			log.Info().Msg("     2. Forward the port over USB: iproxy 8100 8100")
+			log.Info().Msg("     3. Or pass the device address: --wda-url http://<device-ip>:8100")
+			return false
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			log.Error().Msg("❌ FAILED")
+			log.Info().Msgf("   Error: WebDriverAgent returned status %d", resp.StatusCode)
+			return false
+		}
+		log.Info().Msg("✅ OK")
 	}
 
 	log.Info().Msg(strings.Repeat("-", 50))
@@ -602,6 +1057,52 @@ func checkSystemRequirements(ctx context.Context, deviceType string, wdaURL stri
 	return true
 }
 
+// checkToolInstalled checks that the device tool is on PATH and runs.
+func checkToolInstalled(deviceType, toolName, toolCmd string) bool {
+	log.Info().Msgf("1. Checking %s installation... ", toolName)
+	_, err := exec.LookPath(toolCmd)
+
+	if err != nil {
+		log.Error().Msg("❌ FAILED")
+		log.Info().Msgf("   Error: %s is not installed or not in PATH.", toolName)
+		log.Info().Msgf("   Solution: Install %s:", toolName)
+		if deviceType == constants.ADB {
+			log.Info().Msg("     - macOS: brew install android-platform-tools")
+			log.Info().Msg("     - Linux: sudo apt install android-tools-adb")
+			log.Info().Msg("     - Windows: Download from https://developer.android.com/studio/releases/platform-tools")
+		} else { // IOS
+			log.Info().Msg("     - macOS: brew install libimobiledevice")
+			log.Info().Msg("     - Linux: sudo apt-get install libimobiledevice-utils")
+		}
+		return false
+	}
+	// Double check by running version command
+	var versionCmd *exec.Cmd
+	if deviceType == constants.ADB {
+		versionCmd = exec.Command(toolCmd, "version")
+	} else { // IOS
+		versionCmd = exec.Command(toolCmd, "-ln")
+	}
+
+	output, err := versionCmd.Output()
+	if err != nil {
+		log.Error().Msg("❌ FAILED")
+		log.Info().Msgf("   Error: %s command failed to run: %v", toolName, err)
+		return false
+	}
+	lines := strings.Split(string(output), "\n")
+	versionLine := ""
+	if len(lines) > 0 {
+		versionLine = strings.TrimSpace(lines[0])
+	}
+	if versionLine == "" {
+		versionLine = "installed"
+	}
+	log.Info().Msgf("✅ OK (%s)", versionLine)
+
+	return true
+}
+
 // printConfiguration prints the configuration information
 func printConfiguration(ctx context.Context, phoneAgent *phoneagent.PhoneAgent) {
 
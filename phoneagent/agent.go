@@ -1,19 +1,20 @@
 package phoneagent
 
 import (
-	"bufio"
 	"context"
+	"errors"
 	"fmt"
-	"os"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/sashabaranov/go-openai"
 	"github.com/spance/autoglm-go/constants"
 	"github.com/spance/autoglm-go/phoneagent/definitions"
 	"github.com/spance/autoglm-go/phoneagent/helper"
+	"github.com/spance/autoglm-go/phoneagent/imageutil"
 	"github.com/spance/autoglm-go/phoneagent/llm"
 	"github.com/spance/autoglm-go/utils"
 )
@@ -24,18 +25,77 @@ type PhoneAgent struct {
 	AgentConfig *definitions.AgentConfig
 	State       []openai.ChatCompletionMessage
 	StepCount   int
+	Task        string   // task of the current run, set by the first step
+	Notes       []string // contents recorded with record_note during the current run
 	ModelClient *llm.ModelClient
+
+	ConfirmationHandler ConfirmationHandler
+	TakeoverHandler     TakeoverHandler
+	InteractHandler     InteractHandler
+
+	observers  []StepObserver
+	trajectory *trajectoryObserver
+	redactors  []Redactor
+	logger     zerolog.Logger
+
+	lastScreenshot *definitions.Screenshot // screenshot of the current step, used by call_api
+	lastUI         *definitions.UINode     // view tree of the current step, when enabled
+	lastSummary    string                  // latest call_api answer
+	settled        *definitions.Screenshot // taken once the screen settled after the last action
+	succeeded      bool                    // the step that finished the task succeeded
+	finished       bool                    // the task finished, with result
+	result         string
+}
+
+// Option customizes a PhoneAgent created by NewPhoneAgent.
+type Option func(*PhoneAgent)
+
+// WithLogger sets the logger used by the agent, e.g. one tagged with the device ID.
+// The global zerolog logger is used by default.
+func WithLogger(logger zerolog.Logger) Option {
+	return func(r *PhoneAgent) {
+		r.logger = logger
+	}
 }
 
-func NewPhoneAgent(device Device, modelConfig *definitions.ModelConfig, agentConfig *definitions.AgentConfig) *PhoneAgent {
+// WithConfirmationHandler replaces the stdin prompt used for sensitive operations.
+func WithConfirmationHandler(h ConfirmationHandler) Option {
+	return func(r *PhoneAgent) {
+		r.ConfirmationHandler = h
+	}
+}
+
+// WithTakeoverHandler replaces the stdin prompt used for manual takeover.
+func WithTakeoverHandler(h TakeoverHandler) Option {
+	return func(r *PhoneAgent) {
+		r.TakeoverHandler = h
+	}
+}
+
+// WithInteractHandler replaces the stdin prompt used when the model asks the user to choose.
+func WithInteractHandler(h InteractHandler) Option {
+	return func(r *PhoneAgent) {
+		r.InteractHandler = h
+	}
+}
+
+func NewPhoneAgent(device Device, modelConfig *definitions.ModelConfig, agentConfig *definitions.AgentConfig, opts ...Option) *PhoneAgent {
 	agentConfig.InitSystemPrompt()
+	stdin := DefaultStdinHandler()
 	result := &PhoneAgent{
-		ModelConfig: modelConfig,
-		AgentConfig: agentConfig,
-		State:       []openai.ChatCompletionMessage{},
-		StepCount:   0,
-		Device:      device,
-		ModelClient: llm.NewModelClient(modelConfig),
+		ModelConfig:         modelConfig,
+		AgentConfig:         agentConfig,
+		State:               []openai.ChatCompletionMessage{},
+		StepCount:           0,
+		Device:              device,
+		ModelClient:         llm.NewModelClient(modelConfig),
+		ConfirmationHandler: stdin,
+		TakeoverHandler:     stdin,
+		InteractHandler:     stdin,
+		logger:              log.Logger,
+	}
+	for _, opt := range opts {
+		opt(result)
 	}
 	return result
 }
@@ -46,35 +106,79 @@ type StepResult struct {
 	Action   map[string]interface{}
 	Thinking string
 	Message  string
+	Notes    []string // contents recorded with record_note so far
 }
 
 func (r *PhoneAgent) Run(ctx context.Context, task string) (string, error) {
+	result, finished, err := r.run(ctx, task)
+	r.closeTrajectory(finished, result, err)
+	return result, err
+}
+
+// ErrTaskTimeout is the cause of the error returned when AgentConfig.TaskTimeout expires.
+var ErrTaskTimeout = errors.New("task timeout exceeded")
+
+func (r *PhoneAgent) run(ctx context.Context, task string) (string, bool, error) {
+	ctx, cancel := r.taskContext(ctx)
+	defer cancel()
+
 	result, err := r.ExecuteStep(ctx, task, true)
 	if err != nil {
-		log.Error().Int("step", r.StepCount).Err(err).Msg("Failed to execute step")
-		return "", err
+		r.logger.Error().Int("step", r.StepCount).Err(err).Msg("Failed to execute step")
+		return "", false, err
 	}
 	if result.Finished {
-		return result.Message, nil
+		r.succeeded = result.Success
+		return result.Message, true, nil
 	}
+	return r.continueRun(ctx)
+}
+
+// continueRun executes follow-up steps until the task finishes or MaxSteps is reached.
+func (r *PhoneAgent) continueRun(ctx context.Context) (string, bool, error) {
 	// Continue until finished or max steps reached
 	for r.StepCount < r.AgentConfig.MaxSteps {
-		result, err = r.ExecuteStep(ctx, "", false)
+		if err := r.stopped(ctx); err != nil {
+			r.logger.Warn().Int("step", r.StepCount).Err(err).Msg("Task stopped")
+			return "", false, err
+		}
+		result, err := r.ExecuteStep(ctx, "", false)
 		if err != nil {
-			log.Error().Int("step", r.StepCount).Err(err).Msg("Failed to execute step")
-			return "", err
+			r.logger.Error().Int("step", r.StepCount).Err(err).Msg("Failed to execute step")
+			return "", false, err
 		}
 		if result.Finished {
-			return result.Message, nil
+			r.succeeded = result.Success
+			return result.Message, true, nil
 		}
 	}
-	return "Max steps reached", nil
+	r.notify(func(o StepObserver) {
+		o.OnFinish(ctx, r.StepCount, &StepResult{Success: false, Finished: true, Message: "Max steps reached"})
+	})
+	return "Max steps reached", false, nil
+}
+
+// taskContext applies AgentConfig.TaskTimeout to the context of a task.
+func (r *PhoneAgent) taskContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if r.AgentConfig.TaskTimeout > 0 {
+		return context.WithTimeoutCause(ctx, r.AgentConfig.TaskTimeout, ErrTaskTimeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// stopped returns the error ending the task once ctx is done (canceled, or
+// ErrTaskTimeout), nil otherwise.
+func (r *PhoneAgent) stopped(ctx context.Context) error {
+	if ctx.Err() == nil {
+		return nil
+	}
+	return fmt.Errorf("task stopped at step %d: %w", r.StepCount, context.Cause(ctx))
 }
 
 func (r *PhoneAgent) Step(ctx context.Context, task string) (*StepResult, error) {
 	isFirst := len(r.State) == 0
 	if isFirst && len(task) == 0 {
-		log.Error().Msg("task is required for the first step")
+		r.logger.Error().Msg("task is required for the first step")
 		return nil, fmt.Errorf("task is required for the first step")
 	}
 	return r.ExecuteStep(ctx, task, isFirst)
@@ -82,23 +186,51 @@ func (r *PhoneAgent) Step(ctx context.Context, task string) (*StepResult, error)
 
 func (r *PhoneAgent) ExecuteStep(ctx context.Context, userPrompt string, isFirstStep bool) (*StepResult, error) {
 	r.StepCount += 1
+	if isFirstStep {
+		r.Task = userPrompt
+		r.Notes = nil
+		r.lastSummary = ""
+		r.settled = nil
+		r.succeeded = false
+		r.finished, r.result = false, ""
+		r.startTrajectory(userPrompt)
+	}
+	defer r.autoSaveSession()
+	defer r.endTrajectoryStep()
+	r.notify(func(o StepObserver) { o.OnStepStart(ctx, r.StepCount, userPrompt) })
 
 	device := r.Device
-	screenshot, err := device.GetScreenshot(ctx, r.AgentConfig.DeviceID)
+	screenshot, err := r.captureScreenshot(ctx)
 	if err != nil {
-		log.Error().Int("step", r.StepCount).Err(err).Msg("Failed to get screenshot")
+		r.logger.Error().Int("step", r.StepCount).Err(err).Msg("Failed to get screenshot")
+		r.notifyError(ctx, err)
 		return &StepResult{
 			Success:  false,
-			Finished: false,
+			Finished: true,
 			Message:  fmt.Sprintf("Failed to get screenshot: %v", err),
 		}, err
 	}
 
+	screenshot, err = r.handleSensitiveScreen(ctx, screenshot)
+	if err != nil {
+		r.logger.Error().Int("step", r.StepCount).Err(err).Msg("Failed to handle sensitive screen")
+		r.notifyError(ctx, err)
+		if errors.Is(err, ErrScreenshotFailed) {
+			return &StepResult{Success: false, Finished: true, Message: fmt.Sprintf("Failed to get screenshot: %v", err)}, err
+		}
+		err = fmt.Errorf("takeover failed: %w", err)
+		return &StepResult{Success: false, Finished: true, Message: err.Error()}, err
+	}
+	captured := screenshot // before redaction, to verify the action against
+	screenshot, sendImage := r.redact(ctx, screenshot)
+
 	currentApp, err := device.GetCurrentApp(ctx, r.AgentConfig.DeviceID)
 	if err != nil {
-		log.Warn().Int("step", r.StepCount).Err(err).Msg("Failed to get current app, continuing anyway")
+		r.logger.Warn().Int("step", r.StepCount).Err(err).Msg("Failed to get current app, continuing anyway")
 		currentApp = "" // Use empty string as fallback
 	}
+	r.notify(func(o StepObserver) { o.OnScreenshot(ctx, r.StepCount, screenshot, currentApp) })
+	r.loadUIHierarchy(ctx)
 
 	var textContent string
 	if isFirstStep {
@@ -114,6 +246,9 @@ func (r *PhoneAgent) ExecuteStep(ctx context.Context, userPrompt string, isFirst
 			textContent = userPrompt
 		}
 	} else {
+		// Keep the context window under control before adding a new turn
+		r.compactHistory(ctx)
+
 		var sb strings.Builder
 		if len(userPrompt) > 0 {
 			sb.WriteString(userPrompt)
@@ -124,20 +259,53 @@ func (r *PhoneAgent) ExecuteStep(ctx context.Context, userPrompt string, isFirst
 			sb.WriteString("** Screen Info **\n\n")
 			sb.WriteString(screenInfo)
 		}
+		if len(r.Notes) > 0 {
+			sb.WriteString("\n\n" + helper.NotesHeader + "\n\n")
+			sb.WriteString(r.notesText())
+		}
 		textContent = sb.String()
 	}
+	// The element texts would reveal what a withheld or redacted screenshot hides
+	if r.AgentConfig.UIElements && r.lastUI != nil && sendImage {
+		if elements := helper.BuildElementList(r.lastUI, screenshot.Width, screenshot.Height, screenshot.Redacted); elements != "" {
+			textContent += "\n\n** UI Elements **\n\n" + elements
+		}
+	}
+
+	var modelScreenshot *definitions.Screenshot
+	if sendImage {
+		// Downscale/re-encode the image for the model; Width/Height keep the device size
+		modelScreenshot, err = imageutil.Prepare(screenshot, r.AgentConfig.Image)
+		if err != nil {
+			r.logger.Warn().Int("step", r.StepCount).Err(err).Msg("Failed to prepare screenshot, sending it unchanged")
+			modelScreenshot = screenshot
+		}
+	} else {
+		r.logger.Info().Int("step", r.StepCount).Msg("Sensitive screen, screenshot not sent to the model")
+		textContent += "\n\n" + sensitiveScreenNote
+	}
+	r.lastScreenshot = modelScreenshot
 
 	// user prompt
 	r.State = append(r.State,
-		helper.CreateUserMessage(textContent, screenshot),
+		helper.CreateUserMessage(textContent, modelScreenshot),
 	)
 
 	// print user message
 	helper.PrintChatMessage(&r.State[len(r.State)-1], r.StepCount)
 
-	response, err := r.ModelClient.Request(ctx, r.State)
+	var response *llm.ModelResponse
+	if r.ModelConfig != nil && r.ModelConfig.Stream {
+		response, err = r.ModelClient.RequestStream(ctx, r.State, r.thinkingCallback(ctx))
+	} else {
+		response, err = r.ModelClient.Request(ctx, r.State)
+	}
 	if err != nil {
-		log.Error().Int("step", r.StepCount).Err(err).Msg("failed to get model response")
+		r.logger.Error().Int("step", r.StepCount).Err(err).Msg("failed to get model response")
+		r.notifyError(ctx, err)
+		if stopErr := r.stopped(ctx); stopErr != nil {
+			return &StepResult{Success: false, Finished: true, Message: stopErr.Error()}, stopErr
+		}
 		return &StepResult{
 			Success:  false,
 			Finished: false,
@@ -145,14 +313,16 @@ func (r *PhoneAgent) ExecuteStep(ctx context.Context, userPrompt string, isFirst
 		}, nil
 	}
 
-	log.Trace().Str("response", utils.JsonString(response)).Msg("💭 model response")
+	r.logger.Trace().Str("response", utils.JsonString(response)).Msg("💭 model response")
+	r.notify(func(o StepObserver) { o.OnModelResponse(ctx, r.StepCount, response) })
 
 	// Parse action from function call
 	var action helper.Action
 	if len(response.ToolCalls) > 0 {
 		action, err = helper.ParseFunctionCall(response.ToolCalls[0])
 		if err != nil {
-			log.Error().Int("step", r.StepCount).Err(err).Msg("failed to parse function call")
+			r.logger.Error().Int("step", r.StepCount).Err(err).Msg("failed to parse function call")
+			r.notifyError(ctx, err)
 			return &StepResult{
 				Success:  false,
 				Finished: false,
@@ -161,7 +331,8 @@ func (r *PhoneAgent) ExecuteStep(ctx context.Context, userPrompt string, isFirst
 		}
 	} else {
 		// No tool call, might be a thinking step or error
-		log.Warn().Int("step", r.StepCount).Msg("No tool call in response")
+		r.logger.Warn().Int("step", r.StepCount).Msg("No tool call in response")
+		r.notifyError(ctx, fmt.Errorf("model did not return a tool call"))
 		return &StepResult{
 			Success:  false,
 			Finished: false,
@@ -170,10 +341,11 @@ func (r *PhoneAgent) ExecuteStep(ctx context.Context, userPrompt string, isFirst
 	}
 
 	// Print action
-	log.Debug().Int("step", r.StepCount).Str("action", response.Action).Str("details", utils.JsonString(action)).Msg("parsed action")
+	r.logger.Debug().Int("step", r.StepCount).Str("action", response.Action).Str("details", utils.JsonString(action)).Msg("parsed action")
 
-	// Remove image from context to save space
+	// Remove image and notes from context to save space, the next turn repeats the notes
 	helper.RemoveImagesFromMessage(&r.State[len(r.State)-1])
+	helper.RemoveNotesFromMessage(&r.State[len(r.State)-1])
 
 	// Add assistant message to state (including tool call)
 	assistantMsg := openai.ChatCompletionMessage{
@@ -186,19 +358,22 @@ func (r *PhoneAgent) ExecuteStep(ctx context.Context, userPrompt string, isFirst
 	// Execute action
 	actionResult, err := r.ExecuteAction(ctx, action, screenshot.Width, screenshot.Height)
 	if err != nil {
-		log.Error().Int("step", r.StepCount).Err(err).Msg("failed to execute action")
+		r.logger.Error().Int("step", r.StepCount).Err(err).Msg("failed to execute action")
+		r.notifyError(ctx, err)
 		actionResult = helper.ActionResult{
 			Success:      true,
 			ShouldFinish: false,
 			Message:      fmt.Sprintf("Action execution error: %v", err),
 		}
 	}
+	actionResult.Verification = r.verifyAction(ctx, action, actionResult, captured, currentApp)
+	r.notify(func(o StepObserver) { o.OnActionExecuted(ctx, r.StepCount, action, actionResult) })
 
 	// Add tool response message to state
 	if len(response.ToolCalls) > 0 {
 		toolMsg := openai.ChatCompletionMessage{
 			Role:       openai.ChatMessageRoleTool,
-			Content:    actionResult.Message,
+			Content:    helper.BuildToolResult(actionResult),
 			ToolCallID: response.ToolCalls[0].ID,
 		}
 		r.State = append(r.State, toolMsg)
@@ -212,7 +387,7 @@ func (r *PhoneAgent) ExecuteStep(ctx context.Context, userPrompt string, isFirst
 			displayMsg = helper.GetMessage("done", r.AgentConfig.Lang)
 		}
 
-		log.Debug().Int("step", r.StepCount).Msgf("✅ %s: %s", helper.GetMessage("task_completed", r.AgentConfig.Lang), displayMsg)
+		r.logger.Debug().Int("step", r.StepCount).Msgf("✅ %s: %s", helper.GetMessage("task_completed", r.AgentConfig.Lang), displayMsg)
 	}
 
 	stepResult := &StepResult{
@@ -226,11 +401,36 @@ func (r *PhoneAgent) ExecuteStep(ctx context.Context, userPrompt string, isFirst
 	} else {
 		stepResult.Message = utils.AnyToString(action["message"])
 	}
+	if len(r.Notes) > 0 {
+		stepResult.Notes = append([]string(nil), r.Notes...)
+	}
+	if stepResult.Finished && r.AgentConfig.CallAPIAsResult && r.lastSummary != "" {
+		stepResult.Message = r.lastSummary
+	}
+	if stepResult.Finished {
+		r.finished, r.result = true, stepResult.Message
+		r.notify(func(o StepObserver) { o.OnFinish(ctx, r.StepCount, stepResult) })
+	}
 
 	return stepResult, nil
 }
 
+func (r *PhoneAgent) notifyError(ctx context.Context, err error) {
+	r.notify(func(o StepObserver) { o.OnError(ctx, r.StepCount, err) })
+}
+
+// ExecuteAction runs an action on the device. After an action that may change the
+// screen it waits for the UI to settle, see definitions.SettlePolicy.
 func (r *PhoneAgent) ExecuteAction(ctx context.Context, action helper.Action, screenWidth, screenHeight int) (helper.ActionResult, error) {
+	result, err := r.executeAction(ctx, action, screenWidth, screenHeight)
+	actionName := utils.AnyToString(action["action"])
+	if err == nil && result.Success && utils.AnyToString(action["_metadata"]) == "do" && settleActions[actionName] {
+		err = r.settle(ctx, actionName)
+	}
+	return result, err
+}
+
+func (r *PhoneAgent) executeAction(ctx context.Context, action helper.Action, screenWidth, screenHeight int) (helper.ActionResult, error) {
 	actionType := utils.AnyToString(action["_metadata"])
 
 	if actionType == "finish" {
@@ -254,6 +454,8 @@ func (r *PhoneAgent) ExecuteAction(ctx context.Context, action helper.Action, sc
 		return r.handleLaunch(ctx, action, screenWidth, screenHeight)
 	case "Tap":
 		return r.handleTap(ctx, action, screenWidth, screenHeight)
+	case "Tap_Element":
+		return r.handleTapElement(ctx, action, screenWidth, screenHeight)
 	case "Type":
 		return r.handleType(ctx, action, screenWidth, screenHeight)
 	case "Type_Name":
@@ -304,7 +506,7 @@ func (r *PhoneAgent) handleLaunch(ctx context.Context, action helper.Action, scr
 
 	_, err := r.Device.LaunchApp(ctx, packageName, r.AgentConfig.DeviceID)
 	if err != nil {
-		log.Error().Int("step", r.StepCount).Err(err).Msg("failed to launch app")
+		r.logger.Error().Int("step", r.StepCount).Err(err).Msg("failed to launch app")
 		return helper.ActionResult{
 			Success:      false,
 			ShouldFinish: false,
@@ -324,21 +526,42 @@ func (r *PhoneAgent) convertRelativeToAbsolute(element []int, screenWidth, scree
 	return x, y
 }
 
+// DefaultConfirmation asks for confirmation on the terminal.
+//
+// Deprecated: set ConfirmationHandler (or use WithConfirmationHandler) instead.
 func (r *PhoneAgent) DefaultConfirmation(message string) bool {
-	reader := bufio.NewReader(os.Stdin)
-	fmt.Printf("Sensitive operation: %s\nConfirm? (Y/N): ", message)
+	ok, _ := DefaultStdinHandler().Confirm(context.Background(), message)
+	return ok
+}
 
-	response, _ := reader.ReadString('\n')
-	response = strings.TrimSpace(response)
-	response = strings.ToUpper(response)
+// DefaultTakeover waits on the terminal for a manual operation to complete.
+//
+// Deprecated: set TakeoverHandler (or use WithTakeoverHandler) instead.
+func (r *PhoneAgent) DefaultTakeover(message string) {
+	_ = DefaultStdinHandler().Takeover(context.Background(), message)
+}
 
-	return response == "Y"
+// handlerContext derives the context passed to human-in-the-loop handlers,
+// applying AgentConfig.HandlerTimeout when set.
+func (r *PhoneAgent) handlerContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if r.AgentConfig.HandlerTimeout > 0 {
+		return context.WithTimeout(ctx, r.AgentConfig.HandlerTimeout)
+	}
+	return context.WithCancel(ctx)
 }
 
-func (r *PhoneAgent) DefaultTakeover(message string) {
-	reader := bufio.NewReader(os.Stdin)
-	fmt.Printf("%s\nPress Enter after completing manual operation...", message)
-	_, _ = reader.ReadString('\n')
+func (r *PhoneAgent) confirm(ctx context.Context, message string) bool {
+	if r.ConfirmationHandler == nil {
+		return false
+	}
+	hctx, cancel := r.handlerContext(ctx)
+	defer cancel()
+	ok, err := r.ConfirmationHandler.Confirm(hctx, message)
+	if err != nil {
+		r.logger.Warn().Int("step", r.StepCount).Err(err).Msg("confirmation failed, treating as rejected")
+		return false
+	}
+	return ok
 }
 
 func (r *PhoneAgent) handleTap(ctx context.Context, action helper.Action, screenWidth, screenHeight int) (helper.ActionResult, error) {
@@ -352,8 +575,9 @@ func (r *PhoneAgent) handleTap(ctx context.Context, action helper.Action, screen
 	}
 
 	x, y := r.convertRelativeToAbsolute(element, screenWidth, screenHeight)
+	x, y = r.snapTap(x, y, screenWidth)
 	if msg, ok := action["message"]; ok {
-		if !r.DefaultConfirmation(utils.AnyToString(msg)) {
+		if !r.confirm(ctx, utils.AnyToString(msg)) {
 			return helper.ActionResult{
 				Success:      false,
 				ShouldFinish: true,
@@ -367,30 +591,54 @@ func (r *PhoneAgent) handleTap(ctx context.Context, action helper.Action, screen
 }
 
 func (r *PhoneAgent) Reset(ctx context.Context) {
+	r.closeTrajectory(false, "", nil)
 	r.State = []openai.ChatCompletionMessage{}
 	r.StepCount = 0
+	r.Task = ""
+	r.Notes = nil
+	r.lastScreenshot = nil
+	r.lastUI = nil
+	r.lastSummary = ""
+	r.settled = nil
 }
 
+const (
+	adbKeyboardIME      = "com.android.adbkeyboard/.AdbIME"
+	keyboardSwitchDelay = time.Second
+	typeInputDelay      = 300 * time.Millisecond
+
+	// restoreKeyboardTimeout bounds restoring the keyboard after typing, which also
+	// runs once the task context is canceled.
+	restoreKeyboardTimeout = 5 * time.Second
+)
+
 func (r *PhoneAgent) handleType(ctx context.Context, action helper.Action, width int, height int) (helper.ActionResult, error) {
 	text := utils.AnyToString(action["text"])
 	device := r.Device
 	deviceID := r.AgentConfig.DeviceID
 
-	// Switch to ADB keyboard
+	// Switch to ADB keyboard, which needs a moment to become active
 	originalIME, _ := device.DetectAndSetADBKeyboard(ctx, deviceID)
-	time.Sleep(time.Second * 1)
-
-	// Clear existing text and type new text
-	_ = device.ClearText(ctx, deviceID)
-	time.Sleep(time.Second * 1)
+	var err error
+	if originalIME != adbKeyboardIME {
+		err = utils.Sleep(ctx, keyboardSwitchDelay)
+	}
 
-	// Handle multiline text by splitting on newlines
-	_ = device.TypeText(ctx, text, deviceID)
-	time.Sleep(time.Second * 1)
+	// Clear existing text and type new text, then let the keyboard handle the input
+	// before it is switched back
+	if err == nil {
+		_ = device.ClearText(ctx, deviceID)
+		_ = device.TypeText(ctx, text, deviceID)
+		err = utils.Sleep(ctx, typeInputDelay)
+	}
 
-	// Restore original keyboard
-	_ = device.RestoreKeyboard(ctx, originalIME, deviceID)
-	time.Sleep(time.Second * 1)
+	// Restore original keyboard, even when the task is being canceled
+	restoreCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), restoreKeyboardTimeout)
+	_ = device.RestoreKeyboard(restoreCtx, originalIME, deviceID)
+	cancel()
+	if err != nil {
+		return helper.ActionResult{Success: false, ShouldFinish: false, Message: "Typing interrupted"}, err
+	}
 
 	return helper.ActionResult{Success: true, ShouldFinish: false}, nil
 }
@@ -431,6 +679,7 @@ func (r *PhoneAgent) handleDoubleTap(ctx context.Context, action helper.Action,
 		}, nil
 	}
 	x, y := r.convertRelativeToAbsolute(element, screenWidth, screenHeight)
+	x, y = r.snapTap(x, y, screenWidth)
 	_ = r.Device.DoubleTap(ctx, x, y, r.AgentConfig.DeviceID)
 	return helper.ActionResult{Success: true, ShouldFinish: false}, nil
 }
@@ -445,18 +694,30 @@ func (r *PhoneAgent) handleLongPress(ctx context.Context, action helper.Action,
 		}, nil
 	}
 	x, y := r.convertRelativeToAbsolute(element, screenWidth, screenHeight)
+	x, y = r.snapTap(x, y, screenWidth)
 	_ = r.Device.LongPress(ctx, x, y, r.AgentConfig.DeviceID)
 	return helper.ActionResult{Success: true, ShouldFinish: false}, nil
 }
 
 func (r *PhoneAgent) handleWait(ctx context.Context, action helper.Action, screenWidth, screenHeight int) (helper.ActionResult, error) {
-	durationStr := utils.AnyToString(action["duration"])
-	duration, err := strconv.ParseFloat(strings.ReplaceAll(durationStr, "seconds", ""), 64)
-	if err != nil {
-		log.Warn().Int("step", r.StepCount).Err(err).Msg("failed to parse duration, using default 1.0s")
-		duration = 1.0
+	// The wait tool sends a number, the text format "2 seconds"
+	var duration float64
+	switch v := action["duration"].(type) {
+	case float64:
+		duration = v
+	case int:
+		duration = float64(v)
+	default:
+		var err error
+		duration, err = strconv.ParseFloat(strings.TrimSpace(strings.ReplaceAll(utils.AnyToString(v), "seconds", "")), 64)
+		if err != nil {
+			r.logger.Warn().Int("step", r.StepCount).Err(err).Msg("failed to parse duration, using default 1.0s")
+			duration = 1.0
+		}
+	}
+	if err := utils.Sleep(ctx, time.Duration(duration*float64(time.Second))); err != nil {
+		return helper.ActionResult{Success: false, ShouldFinish: false, Message: "Wait interrupted"}, err
 	}
-	time.Sleep(time.Duration(duration) * time.Second)
 	return helper.ActionResult{Success: true, ShouldFinish: false}, nil
 }
 
@@ -465,23 +726,123 @@ func (r *PhoneAgent) handleTakeover(ctx context.Context, action helper.Action, s
 	if message == "" {
 		message = "User intervention required"
 	}
-	r.DefaultTakeover(message)
+	if r.TakeoverHandler == nil {
+		return helper.ActionResult{Success: false, ShouldFinish: true, Message: "No takeover handler configured"}, nil
+	}
+
+	hctx, cancel := r.handlerContext(ctx)
+	defer cancel()
+	if err := r.TakeoverHandler.Takeover(hctx, message); err != nil {
+		r.logger.Warn().Int("step", r.StepCount).Err(err).Msg("takeover failed")
+		return helper.ActionResult{
+			Success:      false,
+			ShouldFinish: true,
+			Message:      fmt.Sprintf("Manual takeover not completed: %v", err),
+		}, nil
+	}
 	return helper.ActionResult{Success: true, ShouldFinish: false}, nil
 }
 
 func (r *PhoneAgent) handleNote(ctx context.Context, action helper.Action, screenWidth, screenHeight int) (helper.ActionResult, error) {
 	// This action is typically used for recording page content
-	// Implementation depends on specific requirements
-	return helper.ActionResult{Success: true, ShouldFinish: false}, nil
+	note := strings.TrimSpace(utils.AnyToString(action["message"]))
+	if note == "" {
+		return helper.ActionResult{
+			Success:      false,
+			ShouldFinish: false,
+			Message:      "No content to record",
+		}, nil
+	}
+	r.Notes = append(r.Notes, note)
+	return helper.ActionResult{
+		Success:      true,
+		ShouldFinish: false,
+		Message:      fmt.Sprintf("Note %d recorded", len(r.Notes)),
+	}, nil
 }
 
 func (r *PhoneAgent) handleCallAPI(ctx context.Context, action helper.Action, screenWidth, screenHeight int) (helper.ActionResult, error) {
 	// This action is typically used for content summarization
-	// Implementation depends on specific requirements
-	return helper.ActionResult{Success: true, ShouldFinish: false}, nil
+	instruction := utils.AnyToString(action["instruction"])
+	if instruction == "" {
+		return helper.ActionResult{
+			Success:      false,
+			ShouldFinish: false,
+			Message:      "No instruction specified",
+		}, nil
+	}
+
+	if r.ModelClient == nil {
+		return helper.ActionResult{
+			Success:      false,
+			ShouldFinish: false,
+			Message:      "No model available for call_api",
+		}, nil
+	}
+
+	var sb strings.Builder
+	sb.WriteString(instruction)
+	if len(r.Notes) > 0 {
+		sb.WriteString("\n\n" + helper.NotesHeader + "\n\n")
+		sb.WriteString(r.notesText())
+	}
+
+	prompt := constants.CallAPIPrompt_ZH
+	if r.AgentConfig.Lang == "en" {
+		prompt = constants.CallAPIPrompt_EN
+	}
+	messages := []openai.ChatCompletionMessage{helper.CreateSystemMessage(prompt)}
+	if r.lastScreenshot != nil {
+		messages = append(messages, helper.CreateUserMessage(sb.String(), r.lastScreenshot))
+	} else {
+		messages = append(messages, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: sb.String()})
+	}
+
+	summary, err := r.ModelClient.Complete(ctx, messages)
+	if err != nil {
+		r.logger.Error().Int("step", r.StepCount).Err(err).Msg("call_api request failed")
+		return helper.ActionResult{
+			Success:      false,
+			ShouldFinish: false,
+			Message:      fmt.Sprintf("call_api failed, err: %v", err),
+		}, nil
+	}
+	r.lastSummary = summary
+	return helper.ActionResult{Success: true, ShouldFinish: false, Message: summary}, nil
+}
+
+// notesText formats the recorded notes as a numbered list.
+func (r *PhoneAgent) notesText() string {
+	var sb strings.Builder
+	for i, note := range r.Notes {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		sb.WriteString(fmt.Sprintf("%d. %s", i+1, note))
+	}
+	return sb.String()
 }
 
 func (r *PhoneAgent) handleInteract(ctx context.Context, action helper.Action, screenWidth, screenHeight int) (helper.ActionResult, error) {
 	// This action signals that user input is needed
-	return helper.ActionResult{Success: true, ShouldFinish: false, Message: "User interaction required"}, nil
+	message := utils.AnyToString(action["message"])
+	if message == "" {
+		message = "User interaction required"
+	}
+	if r.InteractHandler == nil {
+		return helper.ActionResult{Success: true, ShouldFinish: false, Message: "User interaction required"}, nil
+	}
+
+	hctx, cancel := r.handlerContext(ctx)
+	defer cancel()
+	answer, err := r.InteractHandler.Interact(hctx, message)
+	if err != nil {
+		r.logger.Warn().Int("step", r.StepCount).Err(err).Msg("interaction failed")
+		return helper.ActionResult{
+			Success:      false,
+			ShouldFinish: false,
+			Message:      fmt.Sprintf("User interaction not completed: %v", err),
+		}, nil
+	}
+	return helper.ActionResult{Success: true, ShouldFinish: false, Message: fmt.Sprintf("User replied: %s", answer)}, nil
 }
@@ -0,0 +1,869 @@
+package phoneagent_test
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/spance/autoglm-go/phoneagent"
+	"github.com/spance/autoglm-go/phoneagent/definitions"
+	"github.com/spance/autoglm-go/phoneagent/devicetest"
+	"github.com/spance/autoglm-go/phoneagent/helper"
+	"github.com/spance/autoglm-go/phoneagent/llm"
+	"github.com/spance/autoglm-go/phoneagent/llm/llmtest"
+	"github.com/spance/autoglm-go/phoneagent/redact"
+	"github.com/spance/autoglm-go/phoneagent/trajectory"
+)
+
+var (
+	_ phoneagent.Device              = (*devicetest.FakeDevice)(nil)
+	_ phoneagent.UIHierarchyProvider = (*devicetest.FakeDevice)(nil)
+)
+
+// noSettle disables waiting for the screen to settle after actions
+var noSettle = map[string]definitions.SettlePolicy{"": {}}
+
+func newTestAgent(device phoneagent.Device, opts ...phoneagent.Option) *phoneagent.PhoneAgent {
+	agentConfig := &definitions.AgentConfig{MaxSteps: 10, DeviceID: devicetest.DefaultDeviceID, Lang: "en", Settle: noSettle}
+	return phoneagent.NewPhoneAgent(device, &definitions.ModelConfig{}, agentConfig, opts...)
+}
+
+func TestExecuteActionTap(t *testing.T) {
+	device := devicetest.NewFakeDevice(devicetest.SolidScreen(1000, 2000, color.White, "Home"))
+	agent := newTestAgent(device)
+
+	action := helper.Action{"_metadata": "do", "action": "Tap", "element": []int{500, 250}}
+	result, err := agent.ExecuteAction(context.Background(), action, 1080, 2400)
+	if err != nil || !result.Success {
+		t.Fatalf("tap failed: %+v, %v", result, err)
+	}
+
+	taps := device.CallsTo("Tap")
+	if len(taps) != 1 || taps[0].Args[0] != 540 || taps[0].Args[1] != 600 {
+		t.Errorf("expected tap at (540, 600), got %+v", taps)
+	}
+}
+
+func TestExecuteActionSensitiveTapRejected(t *testing.T) {
+	device := devicetest.NewFakeDevice(devicetest.SolidScreen(10, 10, color.White, "Home"))
+	var asked string
+	agent := newTestAgent(device, phoneagent.WithConfirmationHandler(phoneagent.ConfirmationFunc(
+		func(ctx context.Context, message string) (bool, error) {
+			asked = message
+			return false, nil
+		})))
+
+	action := helper.Action{"_metadata": "do", "action": "Tap", "element": []int{500, 500}, "message": "Pay 10 CNY"}
+	result, _ := agent.ExecuteAction(context.Background(), action, 1080, 2400)
+	if asked != "Pay 10 CNY" {
+		t.Errorf("confirmation handler not called with message, got %q", asked)
+	}
+	if result.Success || !result.ShouldFinish {
+		t.Errorf("expected rejected tap to finish the task, got %+v", result)
+	}
+	if n := len(device.CallsTo("Tap")); n != 0 {
+		t.Errorf("rejected tap must not reach the device, got %d taps", n)
+	}
+}
+
+func TestExecuteActionTakeover(t *testing.T) {
+	device := devicetest.NewFakeDevice(devicetest.SolidScreen(10, 10, color.White, "Home"))
+	called := false
+	agent := newTestAgent(device, phoneagent.WithTakeoverHandler(phoneagent.TakeoverFunc(
+		func(ctx context.Context, message string) error {
+			called = true
+			return context.DeadlineExceeded
+		})))
+
+	action := helper.Action{"_metadata": "do", "action": "Take_over", "message": "Please log in"}
+	result, _ := agent.ExecuteAction(context.Background(), action, 1080, 2400)
+	if !called || result.Success || !result.ShouldFinish {
+		t.Errorf("expected failed takeover to finish the task, got %+v (called=%v)", result, called)
+	}
+}
+
+func TestExecuteActionType(t *testing.T) {
+	device := devicetest.NewFakeDevice(devicetest.SolidScreen(10, 10, color.White, "Home"))
+	agent := newTestAgent(device)
+
+	action := helper.Action{"_metadata": "do", "action": "Type", "text": "hello"}
+	if _, err := agent.ExecuteAction(context.Background(), action, 1080, 2400); err != nil {
+		t.Fatalf("type failed: %v", err)
+	}
+	typed := device.CallsTo("TypeText")
+	if len(typed) != 1 || typed[0].Args[0] != "hello" {
+		t.Errorf("unexpected typed text: %+v", typed)
+	}
+	if device.IME() != "com.android.inputmethod.latin/.LatinIME" {
+		t.Errorf("keyboard was not restored, got %q", device.IME())
+	}
+}
+
+func TestExecuteActionTypeCanceled(t *testing.T) {
+	device := devicetest.NewFakeDevice(devicetest.SolidScreen(10, 10, color.White, "Home"))
+	agent := newTestAgent(device)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	action := helper.Action{"_metadata": "do", "action": "Type", "text": "hello"}
+	start := time.Now()
+	result, err := agent.ExecuteAction(ctx, action, 1080, 2400)
+	if !errors.Is(err, context.DeadlineExceeded) || result.Success {
+		t.Fatalf("expected an interrupted action, got %+v, %v", result, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("typing was not interrupted, took %s", elapsed)
+	}
+	if device.IME() != "com.android.inputmethod.latin/.LatinIME" {
+		t.Errorf("keyboard was not restored, got %q", device.IME())
+	}
+}
+
+func TestRunCanceledDuringWait(t *testing.T) {
+	server := llmtest.NewServer(llmtest.ToolCall("Let the page load.", "wait", map[string]any{"duration": 30}))
+	defer server.Close()
+	device := devicetest.NewFakeDevice(devicetest.SolidScreen(10, 10, color.White, "Home"))
+	agentConfig := &definitions.AgentConfig{MaxSteps: 10, DeviceID: devicetest.DefaultDeviceID, Lang: "en", Settle: noSettle}
+	modelConfig := &definitions.ModelConfig{BaseURL: server.BaseURL(), ModelName: "autoglm-phone"}
+	agent := phoneagent.NewPhoneAgent(device, modelConfig, agentConfig)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := agent.Run(ctx, "Open the page")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected a canceled task, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("wait was not interrupted, took %s", elapsed)
+	}
+	if agent.StepCount != 1 || len(server.Requests()) != 1 {
+		t.Errorf("expected to stop after the first step, got %d steps and %d requests", agent.StepCount, len(server.Requests()))
+	}
+}
+
+func TestRunTaskTimeout(t *testing.T) {
+	server := llmtest.NewServerFunc(func(req *openai.ChatCompletionRequest) llmtest.Response {
+		return llmtest.ToolCall("Still loading.", "wait", map[string]any{"duration": 0.05})
+	})
+	defer server.Close()
+	device := devicetest.NewFakeDevice(devicetest.SolidScreen(10, 10, color.White, "Home"))
+	agentConfig := &definitions.AgentConfig{MaxSteps: 1000, DeviceID: devicetest.DefaultDeviceID, Lang: "en", TaskTimeout: 200 * time.Millisecond, Settle: noSettle}
+	modelConfig := &definitions.ModelConfig{BaseURL: server.BaseURL(), ModelName: "autoglm-phone"}
+	agent := phoneagent.NewPhoneAgent(device, modelConfig, agentConfig)
+
+	_, err := agent.Run(context.Background(), "Wait for the page")
+	if !errors.Is(err, phoneagent.ErrTaskTimeout) {
+		t.Fatalf("expected a task timeout, got %v", err)
+	}
+	if agent.StepCount < 2 || agent.StepCount > 10 {
+		t.Errorf("unexpected step count %d", agent.StepCount)
+	}
+}
+
+func TestSettleAfterAction(t *testing.T) {
+	server := llmtest.NewServer(
+		llmtest.ToolCall("Open the menu.", "tap", map[string]any{"element": []int{500, 500}}),
+		llmtest.Finish("done"),
+	)
+	defer server.Close()
+	// The menu animates over two frames, then stays put
+	device := devicetest.NewFakeDevice(
+		devicetest.SolidScreen(10, 10, color.White, "Home"),
+		devicetest.SolidScreen(10, 10, color.Black, "Home"),
+		devicetest.SolidScreen(10, 10, color.Gray{Y: 128}, "Home"),
+		devicetest.SolidScreen(10, 10, color.Gray{Y: 128}, "Home"),
+	)
+	agentConfig := &definitions.AgentConfig{MaxSteps: 10, Lang: "en", Settle: map[string]definitions.SettlePolicy{
+		"Tap": {Max: 2 * time.Second, Interval: time.Millisecond},
+	}}
+	modelConfig := &definitions.ModelConfig{BaseURL: server.BaseURL(), ModelName: "autoglm-phone"}
+	agent := phoneagent.NewPhoneAgent(device, modelConfig, agentConfig)
+
+	if _, err := agent.Run(context.Background(), "Open the menu"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	// One screenshot for the first step, three until the screen settled; the last
+	// one is reused for the second step
+	if n := len(device.CallsTo("GetScreenshot")); n != 4 {
+		t.Errorf("expected 4 screenshots, got %d", n)
+	}
+
+	// Max not above Min waits a fixed time without screenshots
+	agentConfig.Settle = map[string]definitions.SettlePolicy{"": {Min: 50 * time.Millisecond}}
+	start := time.Now()
+	action := helper.Action{"_metadata": "do", "action": "Back"}
+	if result, err := agent.ExecuteAction(context.Background(), action, 1080, 2400); err != nil || !result.Success {
+		t.Fatalf("back failed: %+v, %v", result, err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("expected a 50ms wait, got %v", elapsed)
+	}
+	if n := len(device.CallsTo("GetScreenshot")); n != 4 {
+		t.Errorf("fixed wait took screenshots, got %d", n)
+	}
+}
+
+// stripedScreen returns a screen of vertical stripes; inverted swaps dark and light.
+func stripedScreen(app string, inverted bool) devicetest.Screen {
+	img := image.NewGray(image.Rect(0, 0, 90, 160))
+	for y := 0; y < 160; y++ {
+		for x := 0; x < 90; x++ {
+			if ((x/10)%2 == 0) != inverted {
+				img.SetGray(x, y, color.Gray{Y: 220})
+			}
+		}
+	}
+	return devicetest.ImageScreen(img, app)
+}
+
+func TestRunVerifiesActions(t *testing.T) {
+	server := llmtest.NewServer(
+		llmtest.ToolCall("Tap the button.", "tap", map[string]any{"element": []int{500, 500}}),
+		llmtest.ToolCall("Nothing happened, open settings.", "launch_app", map[string]any{"app": "Settings"}),
+		llmtest.Finish("done"),
+	)
+	defer server.Close()
+	// The tap leaves the home screen unchanged, the launch opens Settings
+	device := devicetest.NewFakeDevice(
+		stripedScreen("Home", false),
+		stripedScreen("Home", false),
+		stripedScreen("Settings", true),
+	)
+	agentConfig := &definitions.AgentConfig{MaxSteps: 10, Lang: "en", Settle: noSettle}
+	modelConfig := &definitions.ModelConfig{BaseURL: server.BaseURL(), ModelName: "autoglm-phone"}
+	agent := phoneagent.NewPhoneAgent(device, modelConfig, agentConfig)
+
+	if _, err := agent.Run(context.Background(), "Open settings"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	var toolResults []string
+	for _, msg := range agent.State {
+		if msg.Role == openai.ChatMessageRoleTool {
+			toolResults = append(toolResults, msg.Content)
+		}
+	}
+	want := []string{
+		`{"screen_changed":false,"app_changed":false,"hash_distance":0}`,
+		`{"screen_changed":true,"app_changed":true,"new_app":"Settings","hash_distance":64}`,
+	}
+	if len(toolResults) < 2 || toolResults[0] != want[0] || toolResults[1] != want[1] {
+		t.Fatalf("unexpected tool results %q, want %q", toolResults, want)
+	}
+	// The screenshot taken to verify an action is reused by the next step
+	if n := len(device.CallsTo("GetScreenshot")); n != 3 {
+		t.Errorf("expected 3 screenshots, got %d", n)
+	}
+}
+
+func TestRunVerifiesSmallChanges(t *testing.T) {
+	// A white screen with dark rectangles at the given positions
+	screen := func(rects ...image.Rectangle) devicetest.Screen {
+		img := image.NewGray(image.Rect(0, 0, 1080, 2400))
+		for i := range img.Pix {
+			img.Pix[i] = 255
+		}
+		for _, rect := range rects {
+			for y := rect.Min.Y; y < rect.Max.Y; y++ {
+				for x := rect.Min.X; x < rect.Max.X; x++ {
+					img.SetGray(x, y, color.Gray{Y: 60})
+				}
+			}
+		}
+		return devicetest.ImageScreen(img, "Settings")
+	}
+	toggle := image.Rect(500, 1200, 560, 1236)
+	clock := image.Rect(40, 20, 140, 60)
+
+	server := llmtest.NewServer(
+		llmtest.ToolCall("Toggle dark mode.", "tap", map[string]any{"element": []int{490, 507}}),
+		llmtest.ToolCall("Tap the title.", "tap", map[string]any{"element": []int{500, 100}}),
+		llmtest.Finish("done"),
+	)
+	defer server.Close()
+	// The first tap only flips a switch, the second one only sees the clock tick
+	device := devicetest.NewFakeDevice(screen(), screen(toggle), screen(toggle, clock))
+	agentConfig := &definitions.AgentConfig{MaxSteps: 10, Lang: "en", Settle: noSettle}
+	modelConfig := &definitions.ModelConfig{BaseURL: server.BaseURL(), ModelName: "autoglm-phone"}
+	agent := phoneagent.NewPhoneAgent(device, modelConfig, agentConfig)
+
+	if _, err := agent.Run(context.Background(), "Enable dark mode"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	var toolResults []string
+	for _, msg := range agent.State {
+		if msg.Role == openai.ChatMessageRoleTool {
+			toolResults = append(toolResults, msg.Content)
+		}
+	}
+	if len(toolResults) < 2 || !strings.Contains(toolResults[0], `"screen_changed":true`) || !strings.Contains(toolResults[1], `"screen_changed":false`) {
+		t.Fatalf("unexpected tool results %q", toolResults)
+	}
+}
+
+func TestRunEndToEnd(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		server := llmtest.NewServer(
+			llmtest.ToolCall("Open settings first.", "launch_app", map[string]any{"app": "Settings"}),
+			llmtest.ToolCall("Tap the display entry.", "tap", map[string]any{"element": []int{500, 500}}),
+			llmtest.Finish("Dark mode enabled"),
+		)
+		device := devicetest.NewFakeDevice(
+			devicetest.SolidScreen(100, 200, color.White, "System Home"),
+			devicetest.SolidScreen(100, 200, color.Black, "Settings"),
+		)
+		agentConfig := &definitions.AgentConfig{MaxSteps: 10, DeviceID: devicetest.DefaultDeviceID, Lang: "en", Settle: noSettle}
+		modelConfig := &definitions.ModelConfig{BaseURL: server.BaseURL(), ModelName: "autoglm-phone", Stream: stream}
+		agent := phoneagent.NewPhoneAgent(device, modelConfig, agentConfig)
+
+		result, err := agent.Run(context.Background(), "Enable dark mode")
+		server.Close()
+		if err != nil {
+			t.Fatalf("stream=%v: Run failed: %v", stream, err)
+		}
+		if result != "Dark mode enabled" {
+			t.Errorf("stream=%v: unexpected result %q", stream, result)
+		}
+		if agent.StepCount != 3 {
+			t.Errorf("stream=%v: expected 3 steps, got %d", stream, agent.StepCount)
+		}
+		if n := len(device.CallsTo("LaunchApp")); n != 1 {
+			t.Errorf("stream=%v: expected 1 launch, got %d", stream, n)
+		}
+		taps := device.CallsTo("Tap")
+		if len(taps) != 1 || taps[0].Args[0] != 50 || taps[0].Args[1] != 100 {
+			t.Errorf("stream=%v: unexpected taps %+v", stream, taps)
+		}
+
+		requests := server.Requests()
+		if len(requests) != 3 {
+			t.Fatalf("stream=%v: expected 3 model requests, got %d", stream, len(requests))
+		}
+		// Only the latest user message carries a screenshot
+		last := requests[2].Messages
+		images := 0
+		for _, msg := range last {
+			for _, part := range msg.MultiContent {
+				if part.Type == openai.ChatMessagePartTypeImageURL {
+					images++
+				}
+			}
+		}
+		if images != 1 {
+			t.Errorf("stream=%v: expected 1 image in the last request, got %d", stream, images)
+		}
+	}
+}
+
+func TestTrajectoryWithHistoryWindow(t *testing.T) {
+	server := llmtest.NewServer(
+		llmtest.ToolCall("Back once.", "press_back", nil),
+		llmtest.ToolCall("Back twice.", "press_back", nil),
+		llmtest.ToolCall("Back again.", "press_back", nil),
+		llmtest.Finish("done"),
+	)
+	defer server.Close()
+	device := devicetest.NewFakeDevice(devicetest.SolidScreen(10, 10, color.White, "Home"))
+	dir := t.TempDir()
+	agentConfig := &definitions.AgentConfig{MaxSteps: 10, Lang: "en", Settle: noSettle,
+		HistoryStrategy: definitions.HistoryWindow, HistoryTurns: 1, TrajectoryDir: dir}
+	modelConfig := &definitions.ModelConfig{BaseURL: server.BaseURL(), ModelName: "autoglm-phone"}
+	agent := phoneagent.NewPhoneAgent(device, modelConfig, agentConfig)
+
+	if _, err := agent.Run(context.Background(), "Go back"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	runs, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(runs) != 1 {
+		t.Fatalf("expected one recorded run, got %v", runs)
+	}
+	run, err := trajectory.Load(runs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(run.Steps) != 4 {
+		t.Fatalf("expected 4 recorded steps, got %d", len(run.Steps))
+	}
+	// Compacted steps still record the user, assistant and tool messages they added
+	for _, step := range run.Steps {
+		roles := make([]string, 0, len(step.Messages))
+		for _, msg := range step.Messages {
+			roles = append(roles, msg.Role)
+		}
+		if strings.Join(roles, " ") != "user assistant tool" && !(step.Step == 1 && strings.Join(roles, " ") == "system user assistant tool") {
+			t.Errorf("step %d recorded messages %v", step.Step, roles)
+		}
+	}
+}
+
+func TestRunDownscaledScreenshot(t *testing.T) {
+	server := llmtest.NewServer(
+		llmtest.ToolCall("Tap the center.", "tap", map[string]any{"element": []int{500, 500}}),
+		llmtest.Finish("done"),
+	)
+	defer server.Close()
+	device := devicetest.NewFakeDevice(devicetest.SolidScreen(1440, 3200, color.White, "Settings"))
+	agentConfig := &definitions.AgentConfig{
+		MaxSteps: 10,
+		Lang:     "en",
+		Image:    definitions.ImageOptions{MaxLongEdge: 800, Format: definitions.ImageJPEG, Quality: 60},
+		Settle:   noSettle,
+	}
+	modelConfig := &definitions.ModelConfig{BaseURL: server.BaseURL(), ModelName: "autoglm-phone"}
+	agent := phoneagent.NewPhoneAgent(device, modelConfig, agentConfig)
+
+	if _, err := agent.Run(context.Background(), "Tap the center"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	first := server.Requests()[0].Messages
+	parts := first[len(first)-1].MultiContent
+	if url := parts[len(parts)-1].ImageURL.URL; !strings.HasPrefix(url, "data:image/jpeg;base64,") {
+		t.Errorf("expected a JPEG data URL, got %.40s", url)
+	}
+	// Coordinates are still converted against the real screen size
+	taps := device.CallsTo("Tap")
+	if len(taps) != 1 || taps[0].Args[0] != 720 || taps[0].Args[1] != 1600 {
+		t.Errorf("expected tap at (720, 1600), got %+v", taps)
+	}
+}
+
+func TestScreenshotRetry(t *testing.T) {
+	server := llmtest.NewServer(llmtest.Finish("done"))
+	defer server.Close()
+	device := devicetest.NewFakeDevice(devicetest.SolidScreen(10, 10, color.White, "Home"))
+	device.FailNext("GetScreenshot", errors.New("device busy"))
+	device.FailNext("GetScreenshot", errors.New("device busy"))
+
+	agentConfig := &definitions.AgentConfig{MaxSteps: 10, Lang: "en", ScreenshotRetryDelay: time.Millisecond, Settle: noSettle}
+	modelConfig := &definitions.ModelConfig{BaseURL: server.BaseURL(), ModelName: "autoglm-phone"}
+	agent := phoneagent.NewPhoneAgent(device, modelConfig, agentConfig)
+
+	if result, err := agent.Run(context.Background(), "Do nothing"); err != nil || result != "done" {
+		t.Fatalf("unexpected result %q, %v", result, err)
+	}
+	if n := len(device.CallsTo("GetScreenshot")); n != 3 {
+		t.Errorf("expected 3 capture attempts, got %d", n)
+	}
+}
+
+func TestScreenshotFallbackAborts(t *testing.T) {
+	server := llmtest.NewServer(llmtest.Finish("done"))
+	defer server.Close()
+	blank := devicetest.SolidScreen(10, 10, color.Black, "Home")
+	blank.Fallback = true
+	device := devicetest.NewFakeDevice(blank)
+
+	agentConfig := &definitions.AgentConfig{MaxSteps: 10, Lang: "en", ScreenshotRetries: 2, ScreenshotRetryDelay: time.Millisecond, Settle: noSettle}
+	modelConfig := &definitions.ModelConfig{BaseURL: server.BaseURL(), ModelName: "autoglm-phone"}
+	agent := phoneagent.NewPhoneAgent(device, modelConfig, agentConfig)
+
+	_, err := agent.Run(context.Background(), "Do nothing")
+	var screenshotErr *phoneagent.ScreenshotError
+	if !errors.Is(err, phoneagent.ErrScreenshotFailed) || !errors.As(err, &screenshotErr) {
+		t.Fatalf("expected ErrScreenshotFailed, got %v", err)
+	}
+	if screenshotErr.Attempts != 3 || !screenshotErr.Fallback {
+		t.Errorf("unexpected error: %+v", screenshotErr)
+	}
+	if n := len(server.Requests()); n != 0 {
+		t.Errorf("the model must not see fallback screenshots, got %d requests", n)
+	}
+}
+
+func TestSensitiveScreenWithholdsImage(t *testing.T) {
+	server := llmtest.NewServer(llmtest.Finish("done"))
+	defer server.Close()
+	secure := devicetest.SolidScreen(10, 10, color.Black, "Alipay")
+	secure.Sensitive = true
+	device := devicetest.NewFakeDevice(secure)
+
+	agentConfig := &definitions.AgentConfig{MaxSteps: 10, Lang: "en", Settle: noSettle}
+	modelConfig := &definitions.ModelConfig{BaseURL: server.BaseURL(), ModelName: "autoglm-phone"}
+	agent := phoneagent.NewPhoneAgent(device, modelConfig, agentConfig)
+
+	if _, err := agent.Run(context.Background(), "Pay the bill"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	messages := server.Requests()[0].Messages
+	parts := messages[len(messages)-1].MultiContent
+	if len(parts) != 1 || parts[0].Type != openai.ChatMessagePartTypeText {
+		t.Fatalf("expected a text-only message, got %+v", parts)
+	}
+	if !strings.Contains(parts[0].Text, "Sensitive Screen") {
+		t.Errorf("expected the sensitive screen note, got %q", parts[0].Text)
+	}
+}
+
+func TestSensitiveScreenTakeover(t *testing.T) {
+	server := llmtest.NewServer(llmtest.Finish("done"))
+	defer server.Close()
+	secure := devicetest.SolidScreen(10, 10, color.Black, "Alipay")
+	secure.Sensitive = true
+	device := devicetest.NewFakeDevice(secure, devicetest.SolidScreen(10, 10, color.White, "Alipay"))
+
+	called := false
+	agentConfig := &definitions.AgentConfig{MaxSteps: 10, Lang: "en", SensitivePolicy: definitions.SensitiveTakeover, Settle: noSettle}
+	modelConfig := &definitions.ModelConfig{BaseURL: server.BaseURL(), ModelName: "autoglm-phone"}
+	agent := phoneagent.NewPhoneAgent(device, modelConfig, agentConfig,
+		phoneagent.WithTakeoverHandler(phoneagent.TakeoverFunc(func(ctx context.Context, message string) error {
+			called = true
+			return nil
+		})))
+
+	if _, err := agent.Run(context.Background(), "Pay the bill"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !called {
+		t.Fatal("expected the user to be asked to take over")
+	}
+	messages := server.Requests()[0].Messages
+	parts := messages[len(messages)-1].MultiContent
+	if len(parts) != 2 || parts[1].Type != openai.ChatMessagePartTypeImageURL {
+		t.Errorf("expected the screen captured after the takeover to be sent, got %+v", parts)
+	}
+}
+
+func TestSensitiveScreenTakeoverFails(t *testing.T) {
+	secure := devicetest.SolidScreen(10, 10, color.Black, "Alipay")
+	secure.Sensitive = true
+	device := devicetest.NewFakeDevice(secure)
+	agentConfig := &definitions.AgentConfig{MaxSteps: 10, Lang: "en", SensitivePolicy: definitions.SensitiveTakeover, Settle: noSettle}
+	refused := errors.New("nobody at the phone")
+	agent := phoneagent.NewPhoneAgent(device, &definitions.ModelConfig{}, agentConfig,
+		phoneagent.WithTakeoverHandler(phoneagent.TakeoverFunc(func(ctx context.Context, message string) error {
+			return refused
+		})))
+
+	if _, err := agent.Run(context.Background(), "Pay the bill"); !errors.Is(err, refused) {
+		t.Fatalf("expected the takeover error, got %v", err)
+	}
+}
+
+func TestRedactorFailureWithholdsImage(t *testing.T) {
+	server := llmtest.NewServer(llmtest.Finish("done"))
+	defer server.Close()
+	device := devicetest.NewFakeDevice(devicetest.SolidScreen(10, 10, color.White, "Home"))
+
+	agentConfig := &definitions.AgentConfig{MaxSteps: 10, Lang: "en", SensitivePolicy: definitions.SensitiveSend, Settle: noSettle}
+	modelConfig := &definitions.ModelConfig{BaseURL: server.BaseURL(), ModelName: "autoglm-phone"}
+	agent := phoneagent.NewPhoneAgent(device, modelConfig, agentConfig,
+		phoneagent.WithRedactor(phoneagent.RedactorFunc(func(ctx context.Context, s *definitions.Screenshot) (*definitions.Screenshot, error) {
+			return nil, errors.New("ocr unavailable")
+		})))
+
+	if _, err := agent.Run(context.Background(), "Do nothing"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	messages := server.Requests()[0].Messages
+	if parts := messages[len(messages)-1].MultiContent; len(parts) != 1 {
+		t.Errorf("an unredacted screen must not be sent, got %d parts", len(parts))
+	}
+}
+
+func TestUIElementsAndSnapTaps(t *testing.T) {
+	server := llmtest.NewServer(
+		// Slightly off the button, which spans x 400-600 and y 480-520 in 0-1000 space
+		llmtest.ToolCall("Tap login.", "tap", map[string]any{"element": []int{390, 500}}),
+		llmtest.Finish("done"),
+	)
+	defer server.Close()
+	screen := devicetest.SolidScreen(1000, 2000, color.White, "Settings")
+	screen.UI = &definitions.UINode{
+		Bounds: definitions.Bounds{X2: 1000, Y2: 2000},
+		Children: []*definitions.UINode{
+			{Class: "android.widget.TextView", Text: "Welcome", Bounds: definitions.Bounds{X1: 100, Y1: 200, X2: 900, Y2: 300}},
+			{Class: "android.widget.Button", Text: "Login", ResourceID: "com.example:id/login", Clickable: true, Enabled: true,
+				Bounds: definitions.Bounds{X1: 400, Y1: 960, X2: 600, Y2: 1040}},
+		},
+	}
+	device := devicetest.NewFakeDevice(screen)
+
+	agentConfig := &definitions.AgentConfig{MaxSteps: 10, Lang: "en", UIElements: true, SnapTaps: true, Settle: noSettle}
+	modelConfig := &definitions.ModelConfig{BaseURL: server.BaseURL(), ModelName: "autoglm-phone"}
+	agent := phoneagent.NewPhoneAgent(device, modelConfig, agentConfig)
+
+	if _, err := agent.Run(context.Background(), "Log in"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	messages := server.Requests()[0].Messages
+	text := messages[len(messages)-1].MultiContent[0].Text
+	if !strings.Contains(text, `[1] Button "Login" id=login clickable bounds=[400,480][600,520]`) {
+		t.Errorf("element list missing from the prompt:\n%s", text)
+	}
+	taps := device.CallsTo("Tap")
+	if len(taps) != 1 || taps[0].Args[0] != 500 || taps[0].Args[1] != 1000 {
+		t.Errorf("expected tap snapped to (500, 1000), got %+v", taps)
+	}
+}
+
+func TestUIElementsHideRedactedContent(t *testing.T) {
+	screen := devicetest.SolidScreen(1000, 2000, color.White, "Bank")
+	screen.UI = &definitions.UINode{
+		Bounds: definitions.Bounds{X2: 1000, Y2: 2000},
+		Children: []*definitions.UINode{
+			{Class: "android.widget.TextView", Text: "Balance 1234.56", Bounds: definitions.Bounds{X1: 100, Y1: 200, X2: 900, Y2: 300}},
+			{Class: "android.widget.EditText", Text: "hunter2", Password: true, Bounds: definitions.Bounds{X1: 100, Y1: 800, X2: 900, Y2: 900}},
+			{Class: "android.widget.Button", Text: "Login", Clickable: true, Enabled: true, Bounds: definitions.Bounds{X1: 400, Y1: 960, X2: 600, Y2: 1040}},
+		},
+	}
+	prompt := func(screen devicetest.Screen, opts ...phoneagent.Option) string {
+		t.Helper()
+		server := llmtest.NewServer(llmtest.Finish("done"))
+		defer server.Close()
+		agentConfig := &definitions.AgentConfig{MaxSteps: 10, Lang: "en", UIElements: true, Settle: noSettle}
+		modelConfig := &definitions.ModelConfig{BaseURL: server.BaseURL(), ModelName: "autoglm-phone"}
+		agent := phoneagent.NewPhoneAgent(devicetest.NewFakeDevice(screen), modelConfig, agentConfig, opts...)
+		if _, err := agent.Run(context.Background(), "Log in"); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		messages := server.Requests()[0].Messages
+		return messages[len(messages)-1].MultiContent[0].Text
+	}
+
+	// The balance lies in a redacted region, the password field is never listed
+	text := prompt(screen, phoneagent.WithRedactor(redact.Regions{Regions: []redact.Region{{X1: 0, Y1: 0, X2: 1000, Y2: 200}}}))
+	if !strings.Contains(text, `Button "Login"`) || strings.Contains(text, "Balance") || strings.Contains(text, "hunter2") {
+		t.Errorf("unexpected element list:\n%s", text)
+	}
+
+	// Nothing is listed for a screen whose screenshot is withheld
+	screen.Sensitive = true
+	if text := prompt(screen); strings.Contains(text, "UI Elements") {
+		t.Errorf("element list sent for a sensitive screen:\n%s", text)
+	}
+}
+
+func TestExecuteActionTapElement(t *testing.T) {
+	screen := devicetest.SolidScreen(1080, 2400, color.White, "Settings")
+	screen.UI = &definitions.UINode{
+		Bounds: definitions.Bounds{X2: 1080, Y2: 2400},
+		Children: []*definitions.UINode{
+			{Text: "Wi-Fi", ResourceID: "android:id/title", Bounds: definitions.Bounds{X1: 0, Y1: 300, X2: 1080, Y2: 400}},
+			{Text: "Wi-Fi preferences", ResourceID: "android:id/title", Bounds: definitions.Bounds{X1: 0, Y1: 500, X2: 1080, Y2: 600}},
+			{ContentDesc: "Search settings", Clickable: true, Bounds: definitions.Bounds{X1: 960, Y1: 100, X2: 1060, Y2: 200}},
+		},
+	}
+
+	tests := []struct {
+		name   string
+		action helper.Action
+		x, y   int // expected tap, 0 when the action must fail
+	}{
+		{"exact text", helper.Action{"text": "wi-fi"}, 540, 350},
+		{"substring text", helper.Action{"text": "preferences"}, 540, 550},
+		{"content desc", helper.Action{"content_desc": "Search settings"}, 1010, 150},
+		{"resource id with index", helper.Action{"resource_id": "title", "index": float64(1)}, 540, 550},
+		{"no match", helper.Action{"text": "Bluetooth"}, 0, 0},
+		{"index out of range", helper.Action{"resource_id": "android:id/title", "index": float64(2)}, 0, 0},
+		{"no selector", helper.Action{}, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			device := devicetest.NewFakeDevice(screen)
+			agent := newTestAgent(device)
+			action := helper.Action{"_metadata": "do", "action": "Tap_Element"}
+			for k, v := range tt.action {
+				action[k] = v
+			}
+
+			result, err := agent.ExecuteAction(context.Background(), action, 1080, 2400)
+			if err != nil {
+				t.Fatalf("ExecuteAction failed: %v", err)
+			}
+			taps := device.CallsTo("Tap")
+			if tt.x == 0 {
+				if result.Success || result.Message == "" || len(taps) != 0 {
+					t.Errorf("expected a failure with a message and no tap, got %+v, %d taps", result, len(taps))
+				}
+				return
+			}
+			if !result.Success || len(taps) != 1 || taps[0].Args[0] != tt.x || taps[0].Args[1] != tt.y {
+				t.Errorf("expected tap at (%d, %d), got %+v, %+v", tt.x, tt.y, result, taps)
+			}
+		})
+	}
+}
+
+func TestRunRuleBasedModel(t *testing.T) {
+	// Keep pressing back until the model sees the home screen
+	server := llmtest.NewServerFunc(func(req *openai.ChatCompletionRequest) llmtest.Response {
+		last := req.Messages[len(req.Messages)-1]
+		for _, part := range last.MultiContent {
+			if strings.Contains(part.Text, "System Home") {
+				return llmtest.Finish("Back home")
+			}
+		}
+		return llmtest.ToolCall("Go back.", "press_back", nil)
+	})
+	defer server.Close()
+
+	device := devicetest.NewStateMachineDevice("deep", map[string]devicetest.Screen{
+		"deep":    devicetest.SolidScreen(10, 10, color.Black, "Settings"),
+		"shallow": devicetest.SolidScreen(10, 10, color.Gray{Y: 128}, "Settings"),
+		"home":    devicetest.SolidScreen(10, 10, color.White, "System Home"),
+	}, func(state string, call devicetest.Call) string {
+		if call.Method != "Back" {
+			return state
+		}
+		if state == "deep" {
+			return "shallow"
+		}
+		return "home"
+	})
+	agent := newTestAgent(device)
+	agent.ModelConfig.BaseURL = server.BaseURL()
+	agent.ModelClient = llm.NewModelClient(agent.ModelConfig)
+
+	result, err := agent.Run(context.Background(), "Go to the home screen")
+	if err != nil || result != "Back home" {
+		t.Fatalf("unexpected result %q, %v", result, err)
+	}
+	if n := len(device.CallsTo("Back")); n != 2 {
+		t.Errorf("expected 2 back presses, got %d", n)
+	}
+}
+
+func TestSessionResume(t *testing.T) {
+	server := llmtest.NewServer(
+		llmtest.ToolCall("Note the price.", "record_note", map[string]any{"message": "price: 42"}),
+		llmtest.Finish("The price is 42"),
+	)
+	defer server.Close()
+	device := devicetest.NewFakeDevice(devicetest.SolidScreen(10, 10, color.White, "Taobao"))
+	path := filepath.Join(t.TempDir(), "session.json")
+
+	newAgent := func() *phoneagent.PhoneAgent {
+		agentConfig := &definitions.AgentConfig{MaxSteps: 10, Lang: "en", DeviceID: devicetest.DefaultDeviceID, SessionPath: path, Settle: noSettle}
+		modelConfig := &definitions.ModelConfig{BaseURL: server.BaseURL(), ModelName: "autoglm-phone"}
+		return phoneagent.NewPhoneAgent(device, modelConfig, agentConfig)
+	}
+
+	// First process: one step, then "crash"
+	first := newAgent()
+	if _, err := first.Step(context.Background(), "Find the price"); err != nil {
+		t.Fatalf("first step failed: %v", err)
+	}
+
+	session, err := phoneagent.LoadSession(path)
+	if err != nil {
+		t.Fatalf("LoadSession failed: %v", err)
+	}
+	if session.Task != "Find the price" || session.StepCount != 1 || len(session.Notes) != 1 {
+		t.Fatalf("unexpected session: %+v", session)
+	}
+
+	// Second process: resume from the saved session
+	second := newAgent()
+	if err := second.RestoreSession(session); err != nil {
+		t.Fatalf("RestoreSession failed: %v", err)
+	}
+	result, err := second.Resume(context.Background())
+	if err != nil || result != "The price is 42" {
+		t.Fatalf("unexpected resume result %q, %v", result, err)
+	}
+	if second.StepCount != 2 {
+		t.Errorf("expected to resume at step 2, got %d", second.StepCount)
+	}
+
+	// The finished session is not run again, its result is returned
+	session, err = phoneagent.LoadSession(path)
+	if err != nil || !session.Finished || session.Result != "The price is 42" {
+		t.Fatalf("expected the session to be saved as finished, got %+v, %v", session, err)
+	}
+	calls := len(server.Requests())
+	third := newAgent()
+	if err := third.RestoreSession(session); err != nil {
+		t.Fatalf("RestoreSession failed: %v", err)
+	}
+	if result, err := third.Resume(context.Background()); err != nil || result != "The price is 42" {
+		t.Errorf("unexpected result of a finished session %q, %v", result, err)
+	}
+	if len(server.Requests()) != calls || third.StepCount != 2 {
+		t.Errorf("finished session was resumed: %d model calls, step %d", len(server.Requests())-calls, third.StepCount)
+	}
+
+	// The resumed request carries the whole history, but only the fresh screenshot
+	requests := server.Requests()[:calls]
+	resumed := requests[len(requests)-1].Messages
+	if resumed[0].Role != openai.ChatMessageRoleSystem || len(resumed) != 5 {
+		t.Errorf("unexpected resumed history: %d messages", len(resumed))
+	}
+
+	other := phoneagent.NewPhoneAgent(device, &definitions.ModelConfig{ModelName: "other-model"}, &definitions.AgentConfig{Lang: "en", Settle: noSettle})
+	if err := other.RestoreSession(session); !errors.Is(err, phoneagent.ErrSessionMismatch) {
+		t.Errorf("expected ErrSessionMismatch, got %v", err)
+	}
+
+	// A session saved on another device is not restored
+	otherDevice := newAgent()
+	otherDevice.AgentConfig.DeviceID = "other-phone"
+	if err := otherDevice.RestoreSession(session); !errors.Is(err, phoneagent.ErrSessionDeviceMismatch) {
+		t.Errorf("expected ErrSessionDeviceMismatch, got %v", err)
+	}
+}
+
+func TestRunNotesAndCallAPI(t *testing.T) {
+	server := llmtest.NewServer(
+		llmtest.ToolCall("Note the first price.", "record_note", map[string]any{"message": "Shop A: 42"}),
+		llmtest.ToolCall("Note the second price.", "record_note", map[string]any{"message": "Shop B: 39"}),
+		llmtest.ToolCall("Compare them.", "call_api", map[string]any{"instruction": "Which shop is cheaper?"}),
+		llmtest.Response{Content: "Shop B is cheaper (39)."},
+		llmtest.Finish("done"),
+	)
+	defer server.Close()
+	device := devicetest.NewFakeDevice(devicetest.SolidScreen(10, 10, color.White, "Taobao"))
+	agentConfig := &definitions.AgentConfig{MaxSteps: 10, Lang: "en", CallAPIAsResult: true, Settle: noSettle}
+	modelConfig := &definitions.ModelConfig{BaseURL: server.BaseURL(), ModelName: "autoglm-phone"}
+	agent := phoneagent.NewPhoneAgent(device, modelConfig, agentConfig)
+
+	result, err := agent.Run(context.Background(), "Compare the prices")
+	if err != nil || result != "Shop B is cheaper (39)." {
+		t.Fatalf("unexpected result %q, %v", result, err)
+	}
+
+	requests := server.Requests()
+	if len(requests) != 5 {
+		t.Fatalf("expected 5 model requests, got %d", len(requests))
+	}
+	// The call_api request has no tools, sees the notes and the current screenshot
+	summary := requests[3]
+	if len(summary.Tools) != 0 {
+		t.Errorf("call_api request must not offer tools")
+	}
+	user := summary.Messages[len(summary.Messages)-1]
+	var text string
+	hasImage := false
+	for _, part := range user.MultiContent {
+		text += part.Text
+		hasImage = hasImage || part.Type == openai.ChatMessagePartTypeImageURL
+	}
+	if !strings.Contains(text, "1. Shop A: 42") || !strings.Contains(text, "2. Shop B: 39") || !hasImage {
+		t.Errorf("unexpected call_api message: %q (image=%v)", text, hasImage)
+	}
+	// Later prompts carry the notebook, on the latest turn only
+	last := requests[4].Messages
+	if !strings.Contains(last[len(last)-1].MultiContent[0].Text, "Shop B: 39") {
+		t.Errorf("notes missing from the next prompt")
+	}
+	for i, msg := range last[:len(last)-1] {
+		if msg.Role != openai.ChatMessageRoleUser {
+			continue
+		}
+		text := msg.MultiContent[0].Text
+		if strings.Contains(text, "** Notes **") {
+			t.Errorf("notes repeated in an earlier turn: %q", text)
+		}
+		// The first turn starts with the task instead
+		if i > 1 && !strings.Contains(text, "** Screen Info **") {
+			t.Errorf("screen info stripped with the notes: %q", text)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package phoneagent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/spance/autoglm-go/phoneagent/definitions"
+	"github.com/spance/autoglm-go/utils"
+)
+
+// ErrScreenshotFailed is matched by errors.Is when no usable screenshot could be captured.
+var ErrScreenshotFailed = errors.New("screenshot capture failed")
+
+// ScreenshotError reports a capture that still failed after all retries.
+type ScreenshotError struct {
+	Attempts int
+	// Fallback is set when the device kept returning fallback placeholders.
+	Fallback bool
+	// Err is the last device error, or the fallback reason.
+	Err error
+}
+
+func (e *ScreenshotError) Error() string {
+	return fmt.Sprintf("screenshot capture failed after %d attempts: %v", e.Attempts, e.Err)
+}
+
+func (e *ScreenshotError) Is(target error) bool {
+	return target == ErrScreenshotFailed
+}
+
+func (e *ScreenshotError) Unwrap() error {
+	return e.Err
+}
+
+// captureScreenshot gets a screenshot (or the one taken when the screen settled
+// after the last action), retrying with exponential backoff when the
+// device fails or returns a fallback placeholder. Sensitive placeholders are the
+// real state of a protected screen and are returned as is.
+func (r *PhoneAgent) captureScreenshot(ctx context.Context) (*definitions.Screenshot, error) {
+	// The screenshot that ended the wait after the last action is still current
+	if screenshot := r.settled; screenshot != nil {
+		r.settled = nil
+		return screenshot, nil
+	}
+
+	retries := r.AgentConfig.ScreenshotRetries
+	if retries == 0 {
+		retries = definitions.DefaultScreenshotRetries
+	}
+	retries = max(retries, 0)
+	delay := r.AgentConfig.ScreenshotRetryDelay
+	if delay <= 0 {
+		delay = definitions.DefaultScreenshotRetryDelay
+	}
+
+	var lastErr error
+	fallback := false
+	for attempt := 0; ; attempt++ {
+		screenshot, err := r.Device.GetScreenshot(ctx, r.AgentConfig.DeviceID)
+		switch {
+		case err != nil:
+			lastErr, fallback = err, false
+		case screenshot.Fallback && !screenshot.IsSensitive:
+			reason := screenshot.FallbackReason
+			if reason == "" {
+				reason = "device returned a fallback screenshot"
+			}
+			lastErr, fallback = errors.New(reason), true
+		default:
+			return screenshot, nil
+		}
+
+		if attempt >= retries {
+			return nil, &ScreenshotError{Attempts: attempt + 1, Fallback: fallback, Err: lastErr}
+		}
+		r.logger.Warn().Int("step", r.StepCount).Int("attempt", attempt+1).Err(lastErr).
+			Dur("retry_in", delay).Msg("Screenshot failed, retrying")
+
+		if err := utils.Sleep(ctx, delay); err != nil {
+			return nil, err
+		}
+		delay *= 2
+	}
+}
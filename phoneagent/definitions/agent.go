@@ -11,14 +11,126 @@ import (
 
 // AgentConfig 代理配置
 type AgentConfig struct {
-	MaxSteps       int                    // 最大执行步数
-	DeviceID       string                 // 设备 ID
-	Lang           string                 // 语言设置: "en" 或 "cn"
-	WdaUrl         string                 // WebDriverAgent URL (仅 iOS)
-	PromptPath     string                 // 自定义系统提示文件路径（可选）
+	MaxSteps        int           // 最大执行步数
+	DeviceID        string        // 设备 ID
+	Lang            string        // 语言设置: "en" 或 "cn"
+	WdaUrl          string        // WebDriverAgent URL (仅 iOS)
+	PromptPath      string        // 自定义系统提示文件路径（可选）
+	HandlerTimeout  time.Duration // 人工确认/接管/交互的超时时间，0 表示不限制
+	TaskTimeout     time.Duration // 单个任务（Run/Resume）的最长运行时间，超时后中止任务，0 表示不限制
+	TrajectoryDir   string        // 轨迹记录目录（可选），设置后每次运行会写入截图、消息和动作
+	SessionPath     string        // 会话文件路径（可选），设置后每步结束自动保存会话以便中断后恢复
+	CallAPIAsResult bool          // 任务结束时使用最近一次 call_api 的结果作为任务结果
+
+	ScreenshotRetries    int           // 截图失败（报错或返回占位图）时的重试次数，0 表示 DefaultScreenshotRetries，负数表示不重试
+	ScreenshotRetryDelay time.Duration // 首次重试前的等待时间，之后每次翻倍，0 表示 DefaultScreenshotRetryDelay
+
+	HistoryStrategy  HistoryStrategy // 历史消息管理策略，默认保留全部
+	HistoryTurns     int             // 压缩后保留的最近轮数，默认 5
+	HistoryMaxTokens int             // 估算 token 数超过该值时压缩历史；0 表示轮数超过 HistoryTurns 即压缩（summary 策略为达到其两倍时）
+
+	Image           ImageOptions    // 截图发送给模型前的处理（缩放、格式、质量、灰度）
+	SensitivePolicy SensitivePolicy // 遇到敏感屏幕（IsSensitive）时的处理方式，默认不发送截图
+
+	UIElements bool // 读取无障碍视图树，在屏幕信息中附加可交互元素列表（设备需实现 GetUIHierarchy）
+	SnapTaps   bool // 点击坐标吸附到最近的可点击元素中心（需要视图树）
+
+	// Settle 动作执行后等待界面稳定的策略，键为动作名（Tap、Launch、Type 等），"" 对应其余动作；
+	// 未配置的动作使用 DefaultSettlePolicies
+	Settle map[string]SettlePolicy
+
 	promptTemplate *fasttemplate.Template // 缓存的提示模板
 }
 
+// HistoryStrategy 历史消息管理策略
+type HistoryStrategy string
+
+const (
+	HistoryFull    HistoryStrategy = ""        // 保留全部历史
+	HistoryWindow  HistoryStrategy = "window"  // 仅保留系统提示和最近若干轮
+	HistorySummary HistoryStrategy = "summary" // 将较早的轮次通过模型总结为"目前进展"
+
+	DefaultHistoryTurns = 5
+)
+
+const (
+	DefaultScreenshotRetries    = 3
+	DefaultScreenshotRetryDelay = 500 * time.Millisecond
+)
+
+// MaxUIElements 屏幕信息中最多列出的界面元素数
+const MaxUIElements = 60
+
+// SensitivePolicy 敏感屏幕（如 FLAG_SECURE 支付页）的处理方式
+type SensitivePolicy string
+
+const (
+	SensitiveSkipImage SensitivePolicy = ""         // 只发送文字说明，不把截图发给模型
+	SensitiveTakeover  SensitivePolicy = "takeover" // 请求人工接管，完成后重新截图
+	SensitiveSend      SensitivePolicy = "send"     // 忽略标记，照常发送截图
+)
+
+// ImageFormat 发送给模型的截图编码格式
+type ImageFormat string
+
+const (
+	ImageOriginal ImageFormat = ""     // 保持设备返回的格式
+	ImagePNG      ImageFormat = "png"  // 无损 PNG
+	ImageJPEG     ImageFormat = "jpeg" // 有损 JPEG，体积最小
+
+	DefaultImageQuality = 85
+)
+
+// ImageOptions 截图处理选项，零值表示原样发送
+type ImageOptions struct {
+	MaxLongEdge int         // 长边最大像素数，超过则等比缩小；0 表示不缩放
+	Format      ImageFormat // 编码格式
+	Quality     int         // JPEG 质量 1-100，0 表示 DefaultImageQuality
+	Grayscale   bool        // 转为灰度图
+}
+
+// IsZero 判断是否未配置任何处理
+func (o ImageOptions) IsZero() bool {
+	return o == ImageOptions{}
+}
+
+// SettlePolicy 动作执行后等待界面稳定的策略：先等待 Min，之后每隔 Interval 截图一次，
+// 相邻两张截图的差异不超过 Threshold 即视为稳定，最多等待 Max。
+// Max 不大于 Min 时只固定等待 Min，零值表示不等待。
+type SettlePolicy struct {
+	Min       time.Duration // 最短等待时间
+	Max       time.Duration // 最长等待时间
+	Interval  time.Duration // 截图间隔，0 表示 DefaultSettleInterval
+	Threshold float64       // 视为稳定的最大差异（0-1），0 表示 DefaultSettleThreshold
+}
+
+const (
+	DefaultSettleInterval  = 200 * time.Millisecond
+	DefaultSettleThreshold = 0.01
+)
+
+// DefaultSettlePolicies 内置的等待策略，"" 对应其余动作
+var DefaultSettlePolicies = map[string]SettlePolicy{
+	"":       {Min: 200 * time.Millisecond, Max: 3 * time.Second},
+	"Launch": {Min: time.Second, Max: 8 * time.Second},
+	"Swipe":  {Min: 300 * time.Millisecond, Max: 4 * time.Second},
+}
+
+// GetSettlePolicy 返回动作的等待策略：依次查找 Settle 中的动作、Settle 中的 ""、
+// DefaultSettlePolicies 中的动作和 DefaultSettlePolicies 中的 ""
+func (c *AgentConfig) GetSettlePolicy(action string) SettlePolicy {
+	if policy, ok := c.Settle[action]; ok {
+		return policy
+	}
+	if policy, ok := c.Settle[""]; ok {
+		return policy
+	}
+	if policy, ok := DefaultSettlePolicies[action]; ok {
+		return policy
+	}
+	return DefaultSettlePolicies[""]
+}
+
 var (
 	// weekdayNamesCN 中文星期名称，索引对应 time.Weekday (0=Sunday, 1=Monday, ...)
 	weekdayNamesCN = []string{"星期日", "星期一", "星期二", "星期三", "星期四", "星期五", "星期六"}
@@ -20,7 +20,15 @@ type DeviceInfo struct {
 type Screenshot struct {
 	BinaryData  []byte `json:"binary_data,omitempty"`
 	Base64Data  string `json:"base64_data"`
+	MimeType    string `json:"mime_type,omitempty"` // 图片格式，为空表示 image/png
 	Width       int    `json:"width"`
 	Height      int    `json:"height"`
 	IsSensitive bool   `json:"is_sensitive"`
+
+	// Redacted 已打码的区域（设备像素），其中的界面元素不会发送给模型
+	Redacted []Bounds `json:"redacted,omitempty"`
+
+	// Fallback 表示截图失败，图片只是占位的黑屏，FallbackReason 记录失败原因
+	Fallback       bool   `json:"fallback,omitempty"`
+	FallbackReason string `json:"fallback_reason,omitempty"`
 }
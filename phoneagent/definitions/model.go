@@ -10,4 +10,8 @@ type ModelConfig struct {
 	Temperature      float32
 	TopP             float32
 	FrequencyPenalty float32
+
+	// Stream enables streaming responses, which yields real TTFT / thinking-end metrics
+	// and incremental thinking text.
+	Stream bool
 }
@@ -43,6 +43,15 @@ func stringParam(description string) ParamProperty {
 	}
 }
 
+// Integer parameter (reusable)
+func integerParam(description string, defaultValue int) ParamProperty {
+	return ParamProperty{
+		Type:        "integer",
+		Description: description,
+		Default:     defaultValue,
+	}
+}
+
 // Number parameter (reusable)
 func numberParam(description string, defaultValue float64) ParamProperty {
 	return ParamProperty{
@@ -56,6 +65,7 @@ func numberParam(description string, defaultValue float64) ParamProperty {
 func GetPhoneAgentTools() []openai.Tool {
 	return []openai.Tool{
 		createTapTool(),
+		createTapElementTool(),
 		createTypeTextTool(),
 		createSwipeTool(),
 		createLongPressTool(),
@@ -90,6 +100,26 @@ func createTapTool() openai.Tool {
 	}
 }
 
+func createTapElementTool() openai.Tool {
+	return openai.Tool{
+		Type: openai.ToolTypeFunction,
+		Function: &openai.FunctionDefinition{
+			Name:        "tap_element",
+			Description: "Tap a UI element identified by its text, content description or resource ID instead of coordinates. More reliable than tap on dense lists. Give at least one of text, content_desc or resource_id; when several elements match, index selects one in screen order.",
+			Parameters: FunctionParams{
+				Type: "object",
+				Properties: map[string]ParamProperty{
+					"text":         stringParam("Text displayed by the element"),
+					"content_desc": stringParam("Accessibility description of the element"),
+					"resource_id":  stringParam("Resource ID of the element, e.g. com.example:id/login or login"),
+					"index":        integerParam("Zero-based index among the matching elements", 0),
+					"message":      stringParam("Optional message for sensitive operations (payments, privacy, etc.)"),
+				},
+			},
+		},
+	}
+}
+
 func createTypeTextTool() openai.Tool {
 	return openai.Tool{
 		Type: openai.ToolTypeFunction,
@@ -0,0 +1,191 @@
+package definitions
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Bounds is a screen rectangle in device pixels; X2 and Y2 are exclusive.
+type Bounds struct {
+	X1 int `json:"x1"`
+	Y1 int `json:"y1"`
+	X2 int `json:"x2"`
+	Y2 int `json:"y2"`
+}
+
+func (b Bounds) String() string {
+	return fmt.Sprintf("[%d,%d][%d,%d]", b.X1, b.Y1, b.X2, b.Y2)
+}
+
+// Center returns the center point of the rectangle.
+func (b Bounds) Center() (int, int) {
+	return (b.X1 + b.X2) / 2, (b.Y1 + b.Y2) / 2
+}
+
+// Empty reports whether the rectangle has no area.
+func (b Bounds) Empty() bool {
+	return b.X2 <= b.X1 || b.Y2 <= b.Y1
+}
+
+// Area returns the rectangle area in pixels.
+func (b Bounds) Area() int {
+	if b.Empty() {
+		return 0
+	}
+	return (b.X2 - b.X1) * (b.Y2 - b.Y1)
+}
+
+// Overlaps reports whether the two rectangles share some area.
+func (b Bounds) Overlaps(o Bounds) bool {
+	return !b.Empty() && !o.Empty() && b.X1 < o.X2 && o.X1 < b.X2 && b.Y1 < o.Y2 && o.Y1 < b.Y2
+}
+
+// Contains reports whether the point is inside the rectangle.
+func (b Bounds) Contains(x, y int) bool {
+	return x >= b.X1 && x < b.X2 && y >= b.Y1 && y < b.Y2
+}
+
+// Distance returns the distance from the point to the rectangle, 0 when inside.
+func (b Bounds) Distance(x, y int) float64 {
+	dx := max(b.X1-x, 0, x-(b.X2-1))
+	dy := max(b.Y1-y, 0, y-(b.Y2-1))
+	return math.Hypot(float64(dx), float64(dy))
+}
+
+// UINode is a node of the accessibility view tree (e.g. a uiautomator dump).
+type UINode struct {
+	Class       string    `json:"class,omitempty"`        // 控件类名，如 android.widget.Button
+	Text        string    `json:"text,omitempty"`         // 显示文本
+	ContentDesc string    `json:"content_desc,omitempty"` // 无障碍描述 (content-desc)
+	ResourceID  string    `json:"resource_id,omitempty"`  // 资源 ID，如 com.example:id/login
+	Package     string    `json:"package,omitempty"`      // 所属应用包名
+	Bounds      Bounds    `json:"bounds"`                 // 屏幕区域（设备像素）
+	Clickable   bool      `json:"clickable,omitempty"`    // 可点击
+	Enabled     bool      `json:"enabled,omitempty"`      // 可用
+	Scrollable  bool      `json:"scrollable,omitempty"`   // 可滚动
+	Password    bool      `json:"password,omitempty"`     // 密码输入框，文本不应外传
+	Children    []*UINode `json:"children,omitempty"`
+}
+
+// Walk calls fn for the node and its descendants in depth-first order.
+// Returning false from fn skips the children of that node.
+func (n *UINode) Walk(fn func(node *UINode) bool) {
+	if n == nil || !fn(n) {
+		return
+	}
+	for _, child := range n.Children {
+		child.Walk(fn)
+	}
+}
+
+// Label returns the text identifying the node to a reader: its text, or its
+// content description. Password fields have no label.
+func (n *UINode) Label() string {
+	switch {
+	case n.Password:
+		return ""
+	case n.Text != "":
+		return n.Text
+	default:
+		return n.ContentDesc
+	}
+}
+
+// Elements returns the visible nodes worth referencing, in document order:
+// clickable nodes and nodes with a label.
+func (n *UINode) Elements() []*UINode {
+	var elements []*UINode
+	n.Walk(func(node *UINode) bool {
+		if !node.Bounds.Empty() && (node.Clickable || node.Label() != "") {
+			elements = append(elements, node)
+		}
+		return true
+	})
+	return elements
+}
+
+// ClickableAt returns the clickable node to tap for the point (x, y): the smallest
+// enabled clickable node containing it or, failing that, the closest one within
+// maxDistance pixels. It returns nil when there is none.
+func (n *UINode) ClickableAt(x, y int, maxDistance float64) *UINode {
+	var best *UINode
+	bestDistance := maxDistance
+	n.Walk(func(node *UINode) bool {
+		if !node.Clickable || !node.Enabled || node.Bounds.Empty() {
+			return true
+		}
+		d := node.Bounds.Distance(x, y)
+		switch {
+		case d > bestDistance:
+		case best == nil || d < bestDistance:
+			best, bestDistance = node, d
+		case node.Bounds.Area() < best.Bounds.Area():
+			// Same distance (usually both contain the point): prefer the innermost
+			best = node
+		}
+		return true
+	})
+	return best
+}
+
+// UISelector identifies elements by their attributes; empty fields match anything.
+type UISelector struct {
+	Text        string `json:"text,omitempty"`         // 显示文本
+	ContentDesc string `json:"content_desc,omitempty"` // 无障碍描述
+	ResourceID  string `json:"resource_id,omitempty"`  // 完整资源 ID 或 ":id/" 之后的部分
+}
+
+func (s UISelector) String() string {
+	var parts []string
+	if s.Text != "" {
+		parts = append(parts, fmt.Sprintf("text=%q", s.Text))
+	}
+	if s.ContentDesc != "" {
+		parts = append(parts, fmt.Sprintf("content_desc=%q", s.ContentDesc))
+	}
+	if s.ResourceID != "" {
+		parts = append(parts, fmt.Sprintf("resource_id=%q", s.ResourceID))
+	}
+	return strings.Join(parts, " ")
+}
+
+// IsZero reports whether the selector has no criteria.
+func (s UISelector) IsZero() bool {
+	return s == UISelector{}
+}
+
+// Find returns the visible nodes matching the selector, in document order. Text
+// and content description match exactly (ignoring case and surrounding spaces);
+// only when nothing matches exactly are they matched as substrings.
+func (n *UINode) Find(selector UISelector) []*UINode {
+	if selector.IsZero() {
+		return nil
+	}
+	if nodes := n.find(selector, strings.EqualFold); len(nodes) > 0 {
+		return nodes
+	}
+	return n.find(selector, func(value, query string) bool {
+		return strings.Contains(strings.ToLower(value), strings.ToLower(query))
+	})
+}
+
+func (n *UINode) find(selector UISelector, match func(value, query string) bool) []*UINode {
+	text := strings.TrimSpace(selector.Text)
+	desc := strings.TrimSpace(selector.ContentDesc)
+	id := strings.TrimSpace(selector.ResourceID)
+
+	var nodes []*UINode
+	n.Walk(func(node *UINode) bool {
+		switch {
+		case node.Bounds.Empty():
+		case text != "" && (node.Password || !match(strings.TrimSpace(node.Text), text)):
+		case desc != "" && !match(strings.TrimSpace(node.ContentDesc), desc):
+		case id != "" && node.ResourceID != id && node.ResourceID[strings.Index(node.ResourceID, "/")+1:] != id:
+		default:
+			nodes = append(nodes, node)
+		}
+		return true
+	})
+	return nodes
+}
@@ -0,0 +1,424 @@
+// Package devicetest provides a scriptable in-memory implementation of phoneagent.Device
+// for tests that must run without adb or a real phone.
+package devicetest
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"sync"
+
+	"github.com/spance/autoglm-go/phoneagent/definitions"
+)
+
+const DefaultDeviceID = "fake-device"
+
+// Screen is one screen the fake device can display.
+type Screen struct {
+	PNG       []byte // encoded screenshot
+	Width     int
+	Height    int
+	App       string // value returned by GetCurrentApp
+	Sensitive bool   // screenshot reported as sensitive (FLAG_SECURE)
+	Fallback  bool   // screenshot reported as a fallback placeholder (capture failed)
+
+	UI *definitions.UINode // view tree returned by GetUIHierarchy, nil when unavailable
+}
+
+// SolidScreen builds a Screen filled with a single color.
+func SolidScreen(width, height int, c color.Color, app string) Screen {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	return ImageScreen(img, app)
+}
+
+// ImageScreen builds a Screen from an image.
+func ImageScreen(img image.Image, app string) Screen {
+	var buf bytes.Buffer
+	_ = png.Encode(&buf, img)
+	return Screen{PNG: buf.Bytes(), Width: img.Bounds().Dx(), Height: img.Bounds().Dy(), App: app}
+}
+
+// Call is a recorded device operation.
+type Call struct {
+	Method   string
+	Args     []any
+	DeviceID string
+}
+
+// TransitionFunc computes the next state of a state-machine FakeDevice after an operation.
+type TransitionFunc func(state string, call Call) string
+
+// FakeDevice implements phoneagent.Device in memory. It works in one of two modes:
+//
+//   - sequence mode (NewFakeDevice): each GetScreenshot serves the next screen, the last one repeats;
+//   - state-machine mode (NewStateMachineDevice): the current screen only changes when an
+//     operation moves the device to another state through the transition function.
+//
+// Every operation is recorded and errors can be injected per method name (e.g. "Tap").
+type FakeDevice struct {
+	mu sync.Mutex
+
+	screens []Screen
+	next    int
+
+	states     map[string]Screen
+	state      string
+	transition TransitionFunc
+
+	calls     []Call
+	errs      map[string]error
+	onceErrs  map[string][]error
+	ime       string
+	devices   []definitions.DeviceInfo
+	connected map[string]bool
+}
+
+// NewFakeDevice creates a FakeDevice serving screens in sequence.
+func NewFakeDevice(screens ...Screen) *FakeDevice {
+	return &FakeDevice{
+		screens:   screens,
+		errs:      map[string]error{},
+		onceErrs:  map[string][]error{},
+		ime:       "com.android.inputmethod.latin/.LatinIME",
+		connected: map[string]bool{DefaultDeviceID: true},
+	}
+}
+
+// NewStateMachineDevice creates a FakeDevice whose screen is driven by a state machine.
+func NewStateMachineDevice(initial string, states map[string]Screen, transition TransitionFunc) *FakeDevice {
+	d := NewFakeDevice()
+	d.states = states
+	d.state = initial
+	d.transition = transition
+	return d
+}
+
+// SetDevices replaces the devices returned by ListDevices.
+func (d *FakeDevice) SetDevices(devices ...definitions.DeviceInfo) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.devices = devices
+	d.connected = map[string]bool{}
+	for _, dev := range devices {
+		d.connected[dev.DeviceID] = dev.Status == "device"
+	}
+}
+
+// SetError makes every call to method fail with err until cleared with a nil err.
+func (d *FakeDevice) SetError(method string, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err == nil {
+		delete(d.errs, method)
+		return
+	}
+	d.errs[method] = err
+}
+
+// FailNext makes the next call to method fail with err. Calls can be queued.
+func (d *FakeDevice) FailNext(method string, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.onceErrs[method] = append(d.onceErrs[method], err)
+}
+
+// Calls returns all recorded operations in order.
+func (d *FakeDevice) Calls() []Call {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]Call(nil), d.calls...)
+}
+
+// CallsTo returns the recorded operations of one method.
+func (d *FakeDevice) CallsTo(method string) []Call {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var calls []Call
+	for _, c := range d.calls {
+		if c.Method == method {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+// State returns the current state of a state-machine device.
+func (d *FakeDevice) State() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.state
+}
+
+// SetState moves a state-machine device to state.
+func (d *FakeDevice) SetState(state string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.state = state
+}
+
+// record stores a call and returns the injected error for it, if any.
+// Operations that may change the screen are fed to the transition function.
+func (d *FakeDevice) record(method, deviceID string, mutates bool, args ...any) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	call := Call{Method: method, Args: args, DeviceID: deviceID}
+	d.calls = append(d.calls, call)
+
+	if queue := d.onceErrs[method]; len(queue) > 0 {
+		d.onceErrs[method] = queue[1:]
+		return queue[0]
+	}
+	if err, ok := d.errs[method]; ok {
+		return err
+	}
+	if mutates && d.transition != nil {
+		d.state = d.transition(d.state, call)
+	}
+	return nil
+}
+
+// current returns the screen being displayed, advancing the sequence if advance is set.
+func (d *FakeDevice) current(advance bool) (Screen, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.states != nil {
+		screen, ok := d.states[d.state]
+		if !ok {
+			return Screen{}, fmt.Errorf("fake device has no screen for state %q", d.state)
+		}
+		return screen, nil
+	}
+	if len(d.screens) == 0 {
+		return Screen{}, fmt.Errorf("fake device has no screens")
+	}
+	idx := min(d.next, len(d.screens)-1)
+	if advance {
+		d.next++
+	}
+	return d.screens[idx], nil
+}
+
+func (d *FakeDevice) GetScreenshot(ctx context.Context, deviceID string) (*definitions.Screenshot, error) {
+	if err := d.record("GetScreenshot", deviceID, false); err != nil {
+		return nil, err
+	}
+	screen, err := d.current(true)
+	if err != nil {
+		return nil, err
+	}
+	return &definitions.Screenshot{
+		Base64Data:  base64.StdEncoding.EncodeToString(screen.PNG),
+		Width:       screen.Width,
+		Height:      screen.Height,
+		IsSensitive: screen.Sensitive,
+		Fallback:    screen.Fallback,
+	}, nil
+}
+
+func (d *FakeDevice) GetCurrentApp(ctx context.Context, deviceID string) (string, error) {
+	if err := d.record("GetCurrentApp", deviceID, false); err != nil {
+		return "", err
+	}
+	screen, err := d.displayed()
+	return screen.App, err
+}
+
+// GetUIHierarchy returns the Screen.UI of the displayed screen.
+func (d *FakeDevice) GetUIHierarchy(ctx context.Context, deviceID string) (*definitions.UINode, error) {
+	if err := d.record("GetUIHierarchy", deviceID, false); err != nil {
+		return nil, err
+	}
+	screen, err := d.displayed()
+	if err != nil {
+		return nil, err
+	}
+	if screen.UI == nil {
+		return nil, fmt.Errorf("fake device screen has no UI hierarchy")
+	}
+	return screen.UI, nil
+}
+
+// displayed returns the screen the last GetScreenshot showed, without advancing.
+func (d *FakeDevice) displayed() (Screen, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.states != nil {
+		screen, ok := d.states[d.state]
+		if !ok {
+			return Screen{}, fmt.Errorf("fake device has no screen for state %q", d.state)
+		}
+		return screen, nil
+	}
+	if len(d.screens) == 0 {
+		return Screen{}, fmt.Errorf("fake device has no screens")
+	}
+	// In sequence mode the app belongs to the screen served by the last GetScreenshot
+	idx := min(max(d.next-1, 0), len(d.screens)-1)
+	return d.screens[idx], nil
+}
+
+func (d *FakeDevice) Tap(ctx context.Context, x, y int, deviceID string) error {
+	return d.record("Tap", deviceID, true, x, y)
+}
+
+func (d *FakeDevice) DoubleTap(ctx context.Context, x, y int, deviceID string) error {
+	return d.record("DoubleTap", deviceID, true, x, y)
+}
+
+func (d *FakeDevice) LongPress(ctx context.Context, x, y int, deviceID string) error {
+	return d.record("LongPress", deviceID, true, x, y)
+}
+
+func (d *FakeDevice) Swipe(ctx context.Context, startX, startY, endX, endY int, deviceID string) error {
+	return d.record("Swipe", deviceID, true, startX, startY, endX, endY)
+}
+
+func (d *FakeDevice) Back(ctx context.Context, deviceID string) error {
+	return d.record("Back", deviceID, true)
+}
+
+func (d *FakeDevice) Home(ctx context.Context, deviceID string) error {
+	return d.record("Home", deviceID, true)
+}
+
+func (d *FakeDevice) LaunchApp(ctx context.Context, appName, deviceID string) (bool, error) {
+	if err := d.record("LaunchApp", deviceID, true, appName); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (d *FakeDevice) TypeText(ctx context.Context, text, deviceID string) error {
+	return d.record("TypeText", deviceID, true, text)
+}
+
+func (d *FakeDevice) ClearText(ctx context.Context, deviceID string) error {
+	return d.record("ClearText", deviceID, true)
+}
+
+func (d *FakeDevice) DetectAndSetADBKeyboard(ctx context.Context, deviceID string) (string, error) {
+	if err := d.record("DetectAndSetADBKeyboard", deviceID, false); err != nil {
+		return "", err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	original := d.ime
+	d.ime = "com.android.adbkeyboard/.AdbIME"
+	return original, nil
+}
+
+func (d *FakeDevice) RestoreKeyboard(ctx context.Context, ime, deviceID string) error {
+	if err := d.record("RestoreKeyboard", deviceID, false, ime); err != nil {
+		return err
+	}
+	if ime == "" {
+		return fmt.Errorf("IME cannot be empty")
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.ime = ime
+	return nil
+}
+
+// IME returns the input method currently selected on the fake device.
+func (d *FakeDevice) IME() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.ime
+}
+
+func (d *FakeDevice) Connect(ctx context.Context, address string) (string, error) {
+	if err := d.record("Connect", "", false, address); err != nil {
+		return fmt.Sprintf("Connect error: %v", err), err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.connected[address] {
+		return fmt.Sprintf("Already connected to %s", address), nil
+	}
+	d.connected[address] = true
+	d.devices = append(d.devices, definitions.DeviceInfo{DeviceID: address, Status: "device", ConnectionType: definitions.Remote})
+	return fmt.Sprintf("Connected to %s", address), nil
+}
+
+func (d *FakeDevice) Disconnect(ctx context.Context, address string) (string, error) {
+	if err := d.record("Disconnect", "", false, address); err != nil {
+		return fmt.Sprintf("Disconnect error: %v", err), err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var kept []definitions.DeviceInfo
+	for _, dev := range d.devices {
+		if dev.ConnectionType == definitions.Remote && (address == "" || dev.DeviceID == address) {
+			delete(d.connected, dev.DeviceID)
+			continue
+		}
+		kept = append(kept, dev)
+	}
+	d.devices = kept
+	return fmt.Sprintf("disconnected %s", address), nil
+}
+
+func (d *FakeDevice) ListDevices(ctx context.Context) ([]definitions.DeviceInfo, error) {
+	if err := d.record("ListDevices", "", false); err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.devices == nil {
+		return []definitions.DeviceInfo{{DeviceID: DefaultDeviceID, Status: "device", ConnectionType: definitions.USB, Model: "Fake"}}, nil
+	}
+	return append([]definitions.DeviceInfo(nil), d.devices...), nil
+}
+
+func (d *FakeDevice) GetDeviceInfo(ctx context.Context, deviceID string) (*definitions.DeviceInfo, error) {
+	devices, err := d.ListDevices(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, dev := range devices {
+		if dev.DeviceID == deviceID {
+			return &dev, nil
+		}
+	}
+	return nil, fmt.Errorf("device %s not found", deviceID)
+}
+
+func (d *FakeDevice) IsConnected(ctx context.Context, deviceID string) bool {
+	if err := d.record("IsConnected", deviceID, false); err != nil {
+		return false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.connected[deviceID]
+}
+
+func (d *FakeDevice) EnableTCPIP(ctx context.Context, port int, deviceID string) error {
+	return d.record("EnableTCPIP", deviceID, false, port)
+}
+
+func (d *FakeDevice) GetDeviceIP(ctx context.Context, deviceID string) (string, error) {
+	if err := d.record("GetDeviceIP", deviceID, false); err != nil {
+		return "", err
+	}
+	return "192.168.1.100", nil
+}
+
+func (d *FakeDevice) RestartServer(ctx context.Context) (string, error) {
+	if err := d.record("RestartServer", "", false); err != nil {
+		return "", err
+	}
+	return "server restarted", nil
+}
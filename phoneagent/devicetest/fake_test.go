@@ -0,0 +1,91 @@
+package devicetest
+
+import (
+	"context"
+	"errors"
+	"image/color"
+	"testing"
+)
+
+func TestFakeDeviceSequence(t *testing.T) {
+	ctx := context.Background()
+	d := NewFakeDevice(
+		SolidScreen(10, 20, color.White, "Home"),
+		SolidScreen(10, 20, color.Black, "Settings"),
+	)
+
+	for i, want := range []string{"Home", "Settings", "Settings"} {
+		shot, err := d.GetScreenshot(ctx, DefaultDeviceID)
+		if err != nil {
+			t.Fatalf("GetScreenshot %d failed: %v", i, err)
+		}
+		if shot.Width != 10 || shot.Height != 20 || shot.Base64Data == "" {
+			t.Errorf("unexpected screenshot %d: %dx%d", i, shot.Width, shot.Height)
+		}
+		if app, _ := d.GetCurrentApp(ctx, DefaultDeviceID); app != want {
+			t.Errorf("screen %d: expected app %q, got %q", i, want, app)
+		}
+	}
+
+	_ = d.Tap(ctx, 1, 2, DefaultDeviceID)
+	taps := d.CallsTo("Tap")
+	if len(taps) != 1 || taps[0].Args[0] != 1 || taps[0].Args[1] != 2 || taps[0].DeviceID != DefaultDeviceID {
+		t.Errorf("unexpected recorded taps: %+v", taps)
+	}
+}
+
+func TestFakeDeviceStateMachine(t *testing.T) {
+	ctx := context.Background()
+	d := NewStateMachineDevice("home", map[string]Screen{
+		"home":     SolidScreen(4, 4, color.White, "System Home"),
+		"settings": SolidScreen(4, 4, color.Black, "Settings"),
+	}, func(state string, call Call) string {
+		switch call.Method {
+		case "LaunchApp":
+			return "settings"
+		case "Home", "Back":
+			return "home"
+		}
+		return state
+	})
+
+	if app, _ := d.GetCurrentApp(ctx, ""); app != "System Home" {
+		t.Errorf("expected System Home, got %q", app)
+	}
+	_, _ = d.LaunchApp(ctx, "Settings", "")
+	if app, _ := d.GetCurrentApp(ctx, ""); app != "Settings" {
+		t.Errorf("expected Settings after launch, got %q", app)
+	}
+	_ = d.Back(ctx, "")
+	if d.State() != "home" {
+		t.Errorf("expected home after back, got %q", d.State())
+	}
+}
+
+func TestFakeDeviceErrors(t *testing.T) {
+	ctx := context.Background()
+	d := NewFakeDevice(SolidScreen(4, 4, color.White, "Home"))
+	boom := errors.New("boom")
+
+	d.FailNext("GetScreenshot", boom)
+	if _, err := d.GetScreenshot(ctx, ""); !errors.Is(err, boom) {
+		t.Errorf("expected injected error, got %v", err)
+	}
+	if _, err := d.GetScreenshot(ctx, ""); err != nil {
+		t.Errorf("one-shot error should be consumed, got %v", err)
+	}
+
+	d.SetError("Swipe", boom)
+	for i := 0; i < 2; i++ {
+		if err := d.Swipe(ctx, 0, 0, 1, 1, ""); !errors.Is(err, boom) {
+			t.Errorf("expected persistent error, got %v", err)
+		}
+	}
+	d.SetError("Swipe", nil)
+	if err := d.Swipe(ctx, 0, 0, 1, 1, ""); err != nil {
+		t.Errorf("expected error to be cleared, got %v", err)
+	}
+	if n := len(d.CallsTo("Swipe")); n != 3 {
+		t.Errorf("expected 3 recorded swipes, got %d", n)
+	}
+}
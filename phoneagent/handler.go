@@ -0,0 +1,147 @@
+package phoneagent
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ConfirmationHandler decides whether a sensitive operation (payment, privacy, etc.) may proceed.
+type ConfirmationHandler interface {
+	Confirm(ctx context.Context, message string) (bool, error)
+}
+
+// TakeoverHandler hands control of the device to a human (login, captcha, ...) and
+// returns once the manual operation has been completed.
+type TakeoverHandler interface {
+	Takeover(ctx context.Context, message string) error
+}
+
+// InteractHandler asks a human to choose between several candidate options and
+// returns the answer, which is passed back to the model.
+type InteractHandler interface {
+	Interact(ctx context.Context, message string) (string, error)
+}
+
+// ConfirmationFunc adapts an ordinary function to ConfirmationHandler.
+type ConfirmationFunc func(ctx context.Context, message string) (bool, error)
+
+func (f ConfirmationFunc) Confirm(ctx context.Context, message string) (bool, error) {
+	return f(ctx, message)
+}
+
+// TakeoverFunc adapts an ordinary function to TakeoverHandler.
+type TakeoverFunc func(ctx context.Context, message string) error
+
+func (f TakeoverFunc) Takeover(ctx context.Context, message string) error {
+	return f(ctx, message)
+}
+
+// InteractFunc adapts an ordinary function to InteractHandler.
+type InteractFunc func(ctx context.Context, message string) (string, error)
+
+func (f InteractFunc) Interact(ctx context.Context, message string) (string, error) {
+	return f(ctx, message)
+}
+
+// StdinHandler is the default terminal implementation of ConfirmationHandler,
+// TakeoverHandler and InteractHandler. It prints prompts to Out and reads answers from In.
+//
+// A single goroutine reads In and hands each line to the prompt waiting for it, so a
+// prompt abandoned because its context expired does not consume the next line.
+// Prompts are shown one at a time, so agents on several devices can share a handler.
+// Programs reading the same terminal should go through ReadLine.
+type StdinHandler struct {
+	In  io.Reader
+	Out io.Writer
+
+	once    sync.Once
+	turn    chan struct{} // held by the prompt waiting for an answer
+	lines   chan string   // lines read from In, closed once reading fails
+	readErr error         // set before lines is closed
+}
+
+// NewStdinHandler creates a StdinHandler bound to os.Stdin and os.Stdout. Only one
+// handler should read os.Stdin, see DefaultStdinHandler.
+func NewStdinHandler() *StdinHandler {
+	return &StdinHandler{In: os.Stdin, Out: os.Stdout}
+}
+
+// DefaultStdinHandler returns the process-wide StdinHandler used by NewPhoneAgent.
+// Programs prompting on the terminal themselves should read through its ReadLine.
+var DefaultStdinHandler = sync.OnceValue(NewStdinHandler)
+
+func (h *StdinHandler) Confirm(ctx context.Context, message string) (bool, error) {
+	answer, err := h.ReadLine(ctx, fmt.Sprintf("Sensitive operation: %s\nConfirm? (Y/N): ", message))
+	if err != nil {
+		return false, err
+	}
+	return strings.ToUpper(answer) == "Y", nil
+}
+
+func (h *StdinHandler) Takeover(ctx context.Context, message string) error {
+	_, err := h.ReadLine(ctx, fmt.Sprintf("%s\nPress Enter after completing manual operation...", message))
+	return err
+}
+
+func (h *StdinHandler) Interact(ctx context.Context, message string) (string, error) {
+	return h.ReadLine(ctx, fmt.Sprintf("%s\nYour choice: ", message))
+}
+
+// ReadLine writes prompt to Out and waits for one line from In, without its
+// surrounding spaces, or for ctx to be done. A prompt waits for the previous one to
+// be answered before it is shown.
+func (h *StdinHandler) ReadLine(ctx context.Context, prompt string) (string, error) {
+	h.once.Do(h.startReading)
+	select {
+	case h.turn <- struct{}{}:
+		defer func() { <-h.turn }()
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+
+	out := h.Out
+	if out == nil {
+		out = os.Stdout
+	}
+	_, _ = fmt.Fprint(out, prompt)
+
+	select {
+	case line, ok := <-h.lines:
+		if !ok {
+			return "", h.readErr
+		}
+		return line, nil
+	case <-ctx.Done():
+		_, _ = fmt.Fprintln(out)
+		return "", ctx.Err()
+	}
+}
+
+// startReading starts the goroutine reading In line by line.
+func (h *StdinHandler) startReading() {
+	in := h.In
+	if in == nil {
+		in = os.Stdin
+	}
+	h.turn = make(chan struct{}, 1)
+	h.lines = make(chan string)
+	go func() {
+		reader := bufio.NewReader(in)
+		for {
+			line, err := reader.ReadString('\n')
+			if len(line) > 0 || err == nil {
+				h.lines <- strings.TrimSpace(line)
+			}
+			if err != nil {
+				h.readErr = err
+				close(h.lines)
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,145 @@
+package phoneagent_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spance/autoglm-go/phoneagent"
+	"github.com/spance/autoglm-go/phoneagent/devicetest"
+)
+
+func TestHandlerFuncs(t *testing.T) {
+	ctx := context.Background()
+	var confirm phoneagent.ConfirmationHandler = phoneagent.ConfirmationFunc(func(ctx context.Context, message string) (bool, error) {
+		return message == "pay", nil
+	})
+	if ok, err := confirm.Confirm(ctx, "pay"); !ok || err != nil {
+		t.Errorf("expected confirmation, got %v, %v", ok, err)
+	}
+
+	takeoverErr := errors.New("no one there")
+	var takeover phoneagent.TakeoverHandler = phoneagent.TakeoverFunc(func(ctx context.Context, message string) error {
+		return takeoverErr
+	})
+	if err := takeover.Takeover(ctx, "login"); err != takeoverErr {
+		t.Errorf("expected the function error, got %v", err)
+	}
+
+	var interact phoneagent.InteractHandler = phoneagent.InteractFunc(func(ctx context.Context, message string) (string, error) {
+		return "choice for " + message, nil
+	})
+	if answer, err := interact.Interact(ctx, "contact"); answer != "choice for contact" || err != nil {
+		t.Errorf("unexpected answer %q, %v", answer, err)
+	}
+}
+
+func TestStdinHandler(t *testing.T) {
+	ctx := context.Background()
+	var out bytes.Buffer
+	h := &phoneagent.StdinHandler{In: strings.NewReader("y\n\n  Alice  \nn"), Out: &out}
+
+	if ok, err := h.Confirm(ctx, "pay 10"); !ok || err != nil {
+		t.Errorf("expected confirmation, got %v, %v", ok, err)
+	}
+	if err := h.Takeover(ctx, "login"); err != nil {
+		t.Errorf("unexpected takeover error %v", err)
+	}
+	if answer, err := h.Interact(ctx, "which contact?"); answer != "Alice" || err != nil {
+		t.Errorf("unexpected answer %q, %v", answer, err)
+	}
+	// The last line has no newline
+	if ok, err := h.Confirm(ctx, "pay 20"); ok || err != nil {
+		t.Errorf("expected rejection, got %v, %v", ok, err)
+	}
+	if _, err := h.Confirm(ctx, "pay 30"); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+	if !strings.Contains(out.String(), "Sensitive operation: pay 10\nConfirm? (Y/N): ") || !strings.Contains(out.String(), "which contact?\nYour choice: ") {
+		t.Errorf("unexpected prompts %q", out.String())
+	}
+}
+
+func TestStdinHandlerAbandonedPrompt(t *testing.T) {
+	in, w := io.Pipe()
+	defer w.Close()
+	h := &phoneagent.StdinHandler{In: in, Out: io.Discard}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := h.Confirm(ctx, "pay"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the prompt to time out, got %v", err)
+	}
+
+	// The next line goes to the next prompt, not to the abandoned one
+	go func() { _, _ = io.WriteString(w, "open settings\n") }()
+	line, err := h.ReadLine(context.Background(), "Enter your task: ")
+	if line != "open settings" || err != nil {
+		t.Errorf("expected the task line, got %q, %v", line, err)
+	}
+}
+
+func TestHandlerOptions(t *testing.T) {
+	device := devicetest.NewFakeDevice()
+	agent := newTestAgent(device)
+	stdin := phoneagent.DefaultStdinHandler()
+	if agent.ConfirmationHandler != stdin || agent.TakeoverHandler != stdin || agent.InteractHandler != stdin {
+		t.Errorf("expected the shared stdin handler by default, got %T %T %T", agent.ConfirmationHandler, agent.TakeoverHandler, agent.InteractHandler)
+	}
+
+	confirm := phoneagent.ConfirmationFunc(func(ctx context.Context, message string) (bool, error) { return true, nil })
+	takeover := phoneagent.TakeoverFunc(func(ctx context.Context, message string) error { return nil })
+	interact := phoneagent.InteractFunc(func(ctx context.Context, message string) (string, error) { return "", nil })
+	agent = newTestAgent(device, phoneagent.WithConfirmationHandler(confirm),
+		phoneagent.WithTakeoverHandler(takeover), phoneagent.WithInteractHandler(interact))
+	if _, ok := agent.ConfirmationHandler.(phoneagent.ConfirmationFunc); !ok {
+		t.Errorf("expected the confirmation option to apply, got %T", agent.ConfirmationHandler)
+	}
+	if _, ok := agent.TakeoverHandler.(phoneagent.TakeoverFunc); !ok {
+		t.Errorf("expected the takeover option to apply, got %T", agent.TakeoverHandler)
+	}
+	if _, ok := agent.InteractHandler.(phoneagent.InteractFunc); !ok {
+		t.Errorf("expected the interact option to apply, got %T", agent.InteractHandler)
+	}
+}
+
+func TestStdinHandlerConcurrentPrompts(t *testing.T) {
+	in, w := io.Pipe()
+	defer w.Close()
+	prompts := promptWriter(make(chan string))
+	h := &phoneagent.StdinHandler{In: in, Out: prompts}
+
+	answers := make(chan string, 2)
+	for _, device := range []string{"phone-1", "phone-2"} {
+		go func() {
+			answer, _ := h.Interact(context.Background(), device)
+			answers <- device + "=" + answer
+		}()
+	}
+
+	// Each answer goes to the prompt shown before it, the other prompt waits
+	for range 2 {
+		device, _, _ := strings.Cut(<-prompts, "\n")
+		select {
+		case prompt := <-prompts:
+			t.Fatalf("second prompt %q shown before the first was answered", prompt)
+		case <-time.After(20 * time.Millisecond):
+		}
+		_, _ = io.WriteString(w, "answer for "+device+"\n")
+		if answer := <-answers; answer != device+"=answer for "+device {
+			t.Errorf("answer went to the wrong prompt: %s", answer)
+		}
+	}
+}
+
+// promptWriter passes each prompt written to it to the test.
+type promptWriter chan string
+
+func (w promptWriter) Write(p []byte) (int, error) {
+	w <- string(p)
+	return len(p), nil
+}
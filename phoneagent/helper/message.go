@@ -3,8 +3,11 @@ package helper
 import (
 	"bytes"
 	"encoding/base64"
+	"fmt"
+	"strings"
 
 	"github.com/rs/zerolog/log"
+	"github.com/samber/lo"
 	"github.com/sashabaranov/go-openai"
 	"github.com/spance/autoglm-go/constants"
 	"github.com/spance/autoglm-go/phoneagent/definitions"
@@ -36,8 +39,17 @@ func CreateUserMessage(text string, screenshot *definitions.Screenshot) openai.C
 		},
 	}
 
+	// 敏感屏幕等情况下不附带截图
+	if screenshot == nil {
+		return msg
+	}
+
+	mimeType := screenshot.MimeType
+	if mimeType == "" {
+		mimeType = "image/png"
+	}
 	var imageURL bytes.Buffer
-	imageURL.WriteString("data:image/png;base64,")
+	imageURL.WriteString("data:" + mimeType + ";base64,")
 
 	// 优先使用二进制数据，其次使用 Base64Data
 	if len(screenshot.BinaryData) > 0 {
@@ -102,6 +114,53 @@ func BuildScreenInfo(currentApp string, screenshot *definitions.Screenshot) stri
 	return utils.JsonString(info)
 }
 
+// BuildToolResult 生成动作的工具消息内容：有校验结果时为包含 message 和校验字段的 JSON，
+// 例如: {"screen_changed":false,"app_changed":false,"hash_distance":0}
+func BuildToolResult(result ActionResult) string {
+	if result.Verification == nil {
+		return result.Message
+	}
+	return utils.JsonString(struct {
+		Message string `json:"message,omitempty"`
+		*ActionVerification
+	}{result.Message, result.Verification})
+}
+
+// BuildElementList 生成界面元素的紧凑列表，坐标换算为模型使用的 0-1000 相对坐标，
+// 例如: [3] Button "登录" id=login clickable bounds=[120,340][880,400]
+// 密码输入框以及与 redacted 区域（设备像素）重叠的元素不会列出
+func BuildElementList(root *definitions.UINode, width, height int, redacted []definitions.Bounds) string {
+	if root == nil || width <= 0 || height <= 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	elements := lo.Filter(root.Elements(), func(node *definitions.UINode, _ int) bool {
+		return !node.Password && !lo.SomeBy(redacted, node.Bounds.Overlaps)
+	})
+	for i, node := range elements {
+		if i == definitions.MaxUIElements {
+			fmt.Fprintf(&sb, "... (%d more)\n", len(elements)-i)
+			break
+		}
+		class := node.Class[strings.LastIndex(node.Class, ".")+1:]
+		fmt.Fprintf(&sb, "[%d] %s", i, class)
+		if label := node.Label(); label != "" {
+			fmt.Fprintf(&sb, " %q", label)
+		}
+		if node.ResourceID != "" {
+			sb.WriteString(" id=" + node.ResourceID[strings.Index(node.ResourceID, "/")+1:])
+		}
+		if node.Clickable {
+			sb.WriteString(" clickable")
+		}
+		b := node.Bounds
+		fmt.Fprintf(&sb, " bounds=[%d,%d][%d,%d]\n",
+			b.X1*1000/width, b.Y1*1000/height, b.X2*1000/width, b.Y2*1000/height)
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
 func GetMessage(key string, lang string) string {
 	if lang == "en" {
 		return constants.MESSAGES_EN_MAP[key]
@@ -109,6 +168,32 @@ func GetMessage(key string, lang string) string {
 	return constants.MESSAGES_ZH_MAP[key]
 }
 
+// NotesHeader 标记用户消息中的笔记段落
+const NotesHeader = "** Notes **"
+
+// RemoveNotesFromMessage 删除用户消息中的笔记段落（直到下一个 "** ... **" 段落），
+// 笔记只需随最新一轮发送
+func RemoveNotesFromMessage(message *openai.ChatCompletionMessage) {
+	if message == nil {
+		return
+	}
+	message.Content = removeNotes(message.Content)
+	for i := range message.MultiContent {
+		message.MultiContent[i].Text = removeNotes(message.MultiContent[i].Text)
+	}
+}
+
+func removeNotes(text string) string {
+	before, after, found := strings.Cut(text, "\n\n"+NotesHeader+"\n\n")
+	if !found {
+		return text
+	}
+	if i := strings.Index(after, "\n\n** "); i >= 0 {
+		return before + after[i:]
+	}
+	return before
+}
+
 func RemoveImagesFromMessage(message *openai.ChatCompletionMessage) {
 	if message == nil || message.MultiContent == nil {
 		return
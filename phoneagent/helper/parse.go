@@ -11,10 +11,19 @@ import (
 type Action map[string]any
 
 type ActionResult struct {
-	Success              bool
-	ShouldFinish         bool
-	Message              string
-	RequiresConfirmation bool
+	Success              bool                `json:"success"`
+	ShouldFinish         bool                `json:"should_finish"`
+	Message              string              `json:"message,omitempty"`
+	RequiresConfirmation bool                `json:"requires_confirmation,omitempty"`
+	Verification         *ActionVerification `json:"verification,omitempty"`
+}
+
+// ActionVerification 比较动作前后的截图和当前应用，判断动作是否生效
+type ActionVerification struct {
+	ScreenChanged bool   `json:"screen_changed"`    // 截图发生了变化（感知哈希或局部亮度）
+	AppChanged    bool   `json:"app_changed"`       // 前台应用发生了变化
+	NewApp        string `json:"new_app,omitempty"` // 变化后的前台应用
+	HashDistance  int    `json:"hash_distance"`     // 前后截图感知哈希的汉明距离（0-64）
 }
 
 // ParseFunctionCall converts OpenAI function call to Action format
@@ -53,29 +62,36 @@ func ParseFunctionCall(toolCall openai.ToolCall) (Action, error) {
 
 	// Copy all arguments
 	for k, v := range args {
-		// Convert array coordinates from float64 to int
-		if k == "element" || k == "start" || k == "end" {
-			if arr, ok := v.([]interface{}); ok {
-				intArr := make([]int, len(arr))
-				for i, val := range arr {
-					if fval, ok := val.(float64); ok {
-						intArr[i] = int(fval)
-					}
-				}
-				action[k] = intArr
-				continue
-			}
-		}
 		action[k] = v
 	}
+	NormalizeCoordinates(action)
 
 	return action, nil
 }
 
+// NormalizeCoordinates converts JSON-decoded coordinate arrays ([]interface{} of float64)
+// into []int, the form expected by the action handlers.
+func NormalizeCoordinates(action Action) {
+	for _, k := range []string{"element", "start", "end"} {
+		arr, ok := action[k].([]interface{})
+		if !ok {
+			continue
+		}
+		intArr := make([]int, len(arr))
+		for i, val := range arr {
+			if fval, ok := val.(float64); ok {
+				intArr[i] = int(fval)
+			}
+		}
+		action[k] = intArr
+	}
+}
+
 // mapFunctionToAction maps function call names to internal action names
 func mapFunctionToAction(funcName string) (string, error) {
 	mapping := map[string]string{
 		"tap":         "Tap",
+		"tap_element": "Tap_Element",
 		"type_text":   "Type",
 		"swipe":       "Swipe",
 		"long_press":  "Long Press",
@@ -0,0 +1,211 @@
+package phoneagent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/spance/autoglm-go/constants"
+	"github.com/spance/autoglm-go/phoneagent/definitions"
+	"github.com/spance/autoglm-go/phoneagent/helper"
+)
+
+const (
+	// imageTokenEstimate is a rough cost of one screenshot for token estimation.
+	imageTokenEstimate = 1000
+	// progressHeader marks the message replacing compacted turns.
+	progressHeader = "** Progress so far **"
+)
+
+// EstimateTokens gives a cheap upper-bound estimate of the tokens used by messages:
+// about 4 ASCII characters per token, one token per other rune and a fixed cost per image.
+func EstimateTokens(messages []openai.ChatCompletionMessage) int {
+	total := 0
+	for _, msg := range messages {
+		total += 4 // role and message framing
+		total += estimateTextTokens(msg.Content)
+		for _, part := range msg.MultiContent {
+			if part.Type == openai.ChatMessagePartTypeImageURL {
+				total += imageTokenEstimate
+			} else {
+				total += estimateTextTokens(part.Text)
+			}
+		}
+		for _, call := range msg.ToolCalls {
+			total += estimateTextTokens(call.Function.Name) + estimateTextTokens(call.Function.Arguments)
+		}
+	}
+	return total
+}
+
+func estimateTextTokens(s string) int {
+	ascii, other := 0, 0
+	for _, r := range s {
+		if r < utf8.RuneSelf {
+			ascii++
+		} else {
+			other++
+		}
+	}
+	return (ascii+3)/4 + other
+}
+
+// turn is a half-open range [start, end) of r.State beginning with a user message.
+type turn struct {
+	start, end int
+}
+
+// historyTurns splits the state after the system prompt and any progress message into turns.
+func (r *PhoneAgent) historyTurns() (head int, turns []turn) {
+	head = 0
+	if head < len(r.State) && r.State[head].Role == openai.ChatMessageRoleSystem {
+		head++
+	}
+	if head < len(r.State) && isProgressMessage(&r.State[head]) {
+		head++
+	}
+	for i := head; i < len(r.State); i++ {
+		if r.State[i].Role == openai.ChatMessageRoleUser {
+			if len(turns) > 0 {
+				turns[len(turns)-1].end = i
+			}
+			turns = append(turns, turn{start: i, end: len(r.State)})
+		}
+	}
+	return head, turns
+}
+
+// compactHistory applies AgentConfig.HistoryStrategy before a new user message is appended.
+func (r *PhoneAgent) compactHistory(ctx context.Context) {
+	strategy := r.AgentConfig.HistoryStrategy
+	if strategy == definitions.HistoryFull {
+		return
+	}
+	keep := r.AgentConfig.HistoryTurns
+	if keep <= 0 {
+		keep = definitions.DefaultHistoryTurns
+	}
+
+	head, turns := r.historyTurns()
+	if len(turns) <= keep {
+		return
+	}
+	if limit := r.AgentConfig.HistoryMaxTokens; limit > 0 {
+		if EstimateTokens(r.State) <= limit {
+			return
+		}
+	} else if strategy == definitions.HistorySummary && len(turns) < 2*keep {
+		// Each summary costs a model call, summarize keep turns at a time
+		return
+	}
+
+	cut := turns[len(turns)-keep].start
+	dropped := r.State[head:cut]
+	var previous string
+	if head > 0 && isProgressMessage(&r.State[head-1]) {
+		previous = progressBody(&r.State[head-1])
+	}
+
+	var progress string
+	if strategy == definitions.HistorySummary {
+		summary, err := r.summarizeHistory(ctx, previous, dropped)
+		if err != nil {
+			r.logger.Warn().Int("step", r.StepCount).Err(err).Msg("failed to summarize history, dropping older turns")
+		} else {
+			progress = summary
+		}
+	}
+	if progress == "" {
+		// Replace the count left by the previous compaction with the running total
+		rest, omitted := splitOmitted(previous)
+		progress = rest
+		if progress != "" {
+			progress += "\n"
+		}
+		progress += fmt.Sprintf(omittedFormat, omitted+len(turns)-keep)
+	}
+
+	var compacted []openai.ChatCompletionMessage
+	if len(r.State) > 0 && r.State[0].Role == openai.ChatMessageRoleSystem {
+		compacted = append(compacted, r.State[0])
+	}
+	compacted = append(compacted, r.progressMessage(progress))
+	compacted = append(compacted, r.State[cut:]...)
+
+	r.logger.Debug().Int("step", r.StepCount).Int("before", len(r.State)).Int("after", len(compacted)).
+		Int("tokens", EstimateTokens(compacted)).Msg("compacted history")
+	if r.trajectory != nil {
+		// Keep pointing at the first message of the current step
+		r.trajectory.stateStart = max(r.trajectory.stateStart-(len(r.State)-len(compacted)), 0)
+	}
+	r.State = compacted
+}
+
+// summarizeHistory asks the model to condense the dropped turns (and the previous summary).
+func (r *PhoneAgent) summarizeHistory(ctx context.Context, previous string, dropped []openai.ChatCompletionMessage) (string, error) {
+	var sb strings.Builder
+	if previous != "" {
+		sb.WriteString(progressHeader)
+		sb.WriteString("\n")
+		sb.WriteString(previous)
+		sb.WriteString("\n\n")
+	}
+	for _, msg := range dropped {
+		helper.RemoveImagesFromMessage(&msg)
+		sb.WriteString(fmt.Sprintf("[%s] ", msg.Role))
+		sb.WriteString(msg.Content)
+		for _, part := range msg.MultiContent {
+			sb.WriteString(part.Text)
+		}
+		for _, call := range msg.ToolCalls {
+			sb.WriteString(fmt.Sprintf(" -> %s(%s)", call.Function.Name, call.Function.Arguments))
+		}
+		sb.WriteString("\n")
+	}
+
+	prompt := constants.SummaryPrompt_ZH
+	if r.AgentConfig.Lang == "en" {
+		prompt = constants.SummaryPrompt_EN
+	}
+	return r.ModelClient.Complete(ctx, []openai.ChatCompletionMessage{
+		helper.CreateSystemMessage(prompt),
+		{Role: openai.ChatMessageRoleUser, Content: sb.String()},
+	})
+}
+
+// progressMessage builds the user message that stands in for compacted turns.
+// It repeats the task so it survives the compaction of the first turn.
+func (r *PhoneAgent) progressMessage(progress string) openai.ChatCompletionMessage {
+	return openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleUser,
+		Content: fmt.Sprintf("Task: %s\n\n%s\n%s", r.Task, progressHeader, progress),
+	}
+}
+
+func isProgressMessage(msg *openai.ChatCompletionMessage) bool {
+	return msg.Role == openai.ChatMessageRoleUser && strings.Contains(msg.Content, progressHeader)
+}
+
+// omittedFormat is the last line of a progress message when turns were dropped
+// without a summary.
+const omittedFormat = "(%d earlier turns omitted)"
+
+// splitOmitted splits the omitted turns count off the end of a progress body.
+func splitOmitted(progress string) (string, int) {
+	rest, last := "", progress
+	if i := strings.LastIndex(progress, "\n"); i >= 0 {
+		rest, last = progress[:i], progress[i+1:]
+	}
+	var omitted int
+	if _, err := fmt.Sscanf(last, omittedFormat, &omitted); err != nil {
+		return progress, 0
+	}
+	return rest, omitted
+}
+
+func progressBody(msg *openai.ChatCompletionMessage) string {
+	_, body, _ := strings.Cut(msg.Content, progressHeader+"\n")
+	return body
+}
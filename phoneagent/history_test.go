@@ -0,0 +1,109 @@
+package phoneagent
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/spance/autoglm-go/phoneagent/definitions"
+	"github.com/spance/autoglm-go/phoneagent/helper"
+	"github.com/spance/autoglm-go/phoneagent/llm/llmtest"
+)
+
+func historyAgent(baseURL string, strategy definitions.HistoryStrategy, turns int) *PhoneAgent {
+	agent := NewPhoneAgent(nil,
+		&definitions.ModelConfig{BaseURL: baseURL, ModelName: "test"},
+		&definitions.AgentConfig{Lang: "en", HistoryStrategy: strategy, HistoryTurns: turns})
+	agent.Task = "Find the cheapest flight"
+	agent.State = append(agent.State, helper.CreateSystemMessage("system"))
+	for i := 0; i < 6; i++ {
+		agent.State = append(agent.State,
+			openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: "screen"},
+			openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: "thinking",
+				ToolCalls: []openai.ToolCall{{ID: "call", Function: openai.FunctionCall{Name: "press_back", Arguments: "{}"}}}},
+			openai.ChatCompletionMessage{Role: openai.ChatMessageRoleTool, ToolCallID: "call"},
+		)
+	}
+	return agent
+}
+
+func TestCompactHistoryWindow(t *testing.T) {
+	agent := historyAgent("", definitions.HistoryWindow, 2)
+	agent.compactHistory(context.Background())
+
+	// system + progress + 2 turns of 3 messages
+	if len(agent.State) != 8 {
+		t.Fatalf("expected 8 messages, got %d", len(agent.State))
+	}
+	if agent.State[0].Role != openai.ChatMessageRoleSystem || !isProgressMessage(&agent.State[1]) {
+		t.Fatalf("unexpected head: %+v", agent.State[:2])
+	}
+	if !strings.Contains(agent.State[1].Content, agent.Task) || !strings.Contains(agent.State[1].Content, "4 earlier turns omitted") {
+		t.Errorf("unexpected progress message: %q", agent.State[1].Content)
+	}
+	if agent.State[2].Role != openai.ChatMessageRoleUser {
+		t.Errorf("kept history must start with a user message, got %s", agent.State[2].Role)
+	}
+
+	// Later compactions update the count instead of adding lines
+	for i := 0; i < 3; i++ {
+		agent.State = append(agent.State, agent.State[2:5]...)
+		agent.compactHistory(context.Background())
+	}
+	if body := progressBody(&agent.State[1]); body != "(7 earlier turns omitted)" {
+		t.Errorf("unexpected progress after several compactions: %q", body)
+	}
+}
+
+func TestCompactHistorySummary(t *testing.T) {
+	server := llmtest.NewServer(llmtest.Response{Content: "Searched flights to Beijing."})
+	defer server.Close()
+
+	agent := historyAgent(server.BaseURL(), definitions.HistorySummary, 2)
+	agent.compactHistory(context.Background())
+
+	if len(agent.State) != 8 || !strings.HasSuffix(agent.State[1].Content, "Searched flights to Beijing.") {
+		t.Fatalf("unexpected compacted history: %d messages, progress %q", len(agent.State), agent.State[1].Content)
+	}
+	req := server.Requests()[0]
+	if len(req.Tools) != 0 {
+		t.Errorf("summary request must not offer tools")
+	}
+}
+
+func TestCompactHistorySummaryBatches(t *testing.T) {
+	server := llmtest.NewServer(llmtest.Response{Content: "Searched flights to Beijing."})
+	defer server.Close()
+
+	// 6 turns are summarized only from 2*4 turns on
+	agent := historyAgent(server.BaseURL(), definitions.HistorySummary, 4)
+	agent.compactHistory(context.Background())
+	if len(agent.State) != 19 || len(server.Requests()) != 0 {
+		t.Fatalf("expected no summary before 8 turns, got %d messages, %d requests", len(agent.State), len(server.Requests()))
+	}
+
+	agent.AgentConfig.HistoryTurns = 3
+	agent.compactHistory(context.Background())
+	if len(agent.State) != 11 || len(server.Requests()) != 1 {
+		t.Fatalf("expected 3 turns summarized, got %d messages, %d requests", len(agent.State), len(server.Requests()))
+	}
+
+	// The next summary waits for 3 more turns
+	for i := 0; i < 2; i++ {
+		agent.State = append(agent.State, agent.State[2:5]...)
+		agent.compactHistory(context.Background())
+	}
+	if len(agent.State) != 17 || len(server.Requests()) != 1 {
+		t.Errorf("expected no summary before 6 turns, got %d messages, %d requests", len(agent.State), len(server.Requests()))
+	}
+}
+
+func TestCompactHistoryTokenThreshold(t *testing.T) {
+	agent := historyAgent("", definitions.HistoryWindow, 2)
+	agent.AgentConfig.HistoryMaxTokens = 100000
+	agent.compactHistory(context.Background())
+	if len(agent.State) != 19 {
+		t.Errorf("history under the token limit must not be compacted, got %d messages", len(agent.State))
+	}
+}
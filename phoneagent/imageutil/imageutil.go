@@ -0,0 +1,132 @@
+// Package imageutil contains the small amount of image processing the agent needs:
+// decoding screenshots, cheaply comparing two screens and hashing them.
+package imageutil
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"math/bits"
+
+	"github.com/spance/autoglm-go/phoneagent/definitions"
+)
+
+// compareSize is the edge length of the thumbnails used to compare screens.
+const compareSize = 32
+
+// Bytes returns the encoded image bytes of a screenshot.
+func Bytes(screenshot *definitions.Screenshot) ([]byte, error) {
+	if screenshot == nil {
+		return nil, fmt.Errorf("screenshot is nil")
+	}
+	if len(screenshot.BinaryData) > 0 {
+		return screenshot.BinaryData, nil
+	}
+	if screenshot.Base64Data == "" {
+		return nil, fmt.Errorf("screenshot is empty")
+	}
+	return base64.StdEncoding.DecodeString(screenshot.Base64Data)
+}
+
+// Decode decodes the image contained in a screenshot.
+func Decode(screenshot *definitions.Screenshot) (image.Image, error) {
+	data, err := Bytes(screenshot)
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode screenshot: %w", err)
+	}
+	return img, nil
+}
+
+// Difference returns how different two images look, from 0 (identical) to 1
+// (completely different), computed on small grayscale thumbnails so that it is
+// cheap and insensitive to compression noise.
+func Difference(a, b image.Image) float64 {
+	ta := thumbnail(a, compareSize, compareSize)
+	tb := thumbnail(b, compareSize, compareSize)
+
+	var total float64
+	for i := range ta {
+		d := float64(ta[i]) - float64(tb[i])
+		if d < 0 {
+			d = -d
+		}
+		total += d
+	}
+	return total / float64(len(ta)*255)
+}
+
+// MaxDifference returns the largest brightness change of a single cell of the
+// thumbnails Difference compares, from 0 to 1. Unlike Difference it is not averaged
+// over the whole screen, so a small change such as a toggled switch shows up.
+func MaxDifference(a, b image.Image) float64 {
+	ta := thumbnail(a, compareSize, compareSize)
+	tb := thumbnail(b, compareSize, compareSize)
+
+	var largest int
+	for i := range ta {
+		d := int(ta[i]) - int(tb[i])
+		largest = max(largest, d, -d)
+	}
+	return float64(largest) / 255
+}
+
+// Hash returns a 64-bit perceptual hash (difference hash) of img: each bit tells
+// whether a cell of a 9x8 grayscale thumbnail is brighter than its right
+// neighbour. Similar looking images have hashes a few bits apart, whatever their
+// size or encoding.
+func Hash(img image.Image) uint64 {
+	t := thumbnail(img, 9, 8)
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if t[y*9+x] > t[y*9+x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// HashDistance returns the number of bits that differ between two hashes, from 0
+// (same looking images) to 64.
+func HashDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// thumbnail downsamples img to w*h grayscale values by averaging each cell.
+func thumbnail(img image.Image, w, h int) []uint8 {
+	bounds := img.Bounds()
+	out := make([]uint8, w*h)
+	for ty := 0; ty < h; ty++ {
+		y0 := bounds.Min.Y + ty*bounds.Dy()/h
+		y1 := max(bounds.Min.Y+(ty+1)*bounds.Dy()/h, y0+1)
+		for tx := 0; tx < w; tx++ {
+			x0 := bounds.Min.X + tx*bounds.Dx()/w
+			x1 := max(bounds.Min.X+(tx+1)*bounds.Dx()/w, x0+1)
+
+			// Sample at most 4x4 pixels per cell, plenty for a thumbnail
+			stepX := max((x1-x0)/4, 1)
+			stepY := max((y1-y0)/4, 1)
+			var sum, n uint64
+			for y := y0; y < y1 && y < bounds.Max.Y; y += stepY {
+				for x := x0; x < x1 && x < bounds.Max.X; x += stepX {
+					r, g, b, _ := img.At(x, y).RGBA()
+					sum += (299*uint64(r) + 587*uint64(g) + 114*uint64(b)) / 1000 >> 8
+					n++
+				}
+			}
+			if n > 0 {
+				out[ty*w+tx] = uint8(sum / n)
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,60 @@
+package imageutil
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// stripes returns a w*h image of vertical stripes, dark first when inverted is false.
+func stripes(w, h, width int, inverted bool) image.Image {
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dark := (x/width)%2 == 0
+			if dark != inverted {
+				img.SetGray(x, y, color.Gray{Y: 30})
+			} else {
+				img.SetGray(x, y, color.Gray{Y: 220})
+			}
+		}
+	}
+	return img
+}
+
+func TestMaxDifference(t *testing.T) {
+	white := image.NewGray(image.Rect(0, 0, 1080, 2400))
+	for i := range white.Pix {
+		white.Pix[i] = 255
+	}
+	// A 60x36 switch turning dark in the middle of the screen
+	toggled := image.NewGray(white.Bounds())
+	copy(toggled.Pix, white.Pix)
+	for y := 1200; y < 1236; y++ {
+		for x := 500; x < 560; x++ {
+			toggled.SetGray(x, y, color.Gray{Y: 60})
+		}
+	}
+
+	if d := MaxDifference(white, white); d != 0 {
+		t.Errorf("expected no difference for the same screen, got %v", d)
+	}
+	if d := Difference(white, toggled); d > 0.01 {
+		t.Errorf("expected the average difference to hide the switch, got %v", d)
+	}
+	if d := MaxDifference(white, toggled); d < 0.2 {
+		t.Errorf("expected the switch to show up, got %v", d)
+	}
+}
+
+func TestHash(t *testing.T) {
+	screen := stripes(180, 320, 20, false)
+
+	// The same screen at another resolution hashes the same
+	if d := HashDistance(Hash(screen), Hash(stripes(90, 160, 10, false))); d != 0 {
+		t.Errorf("expected the same hash for a downscaled screen, got distance %d", d)
+	}
+	if d := HashDistance(Hash(screen), Hash(stripes(180, 320, 20, true))); d < 32 {
+		t.Errorf("expected distant hashes for inverted stripes, got distance %d", d)
+	}
+}
@@ -0,0 +1,127 @@
+package imageutil
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+
+	"github.com/spance/autoglm-go/phoneagent/definitions"
+)
+
+// Prepare applies opts to a screenshot before it is sent to the model: downscale
+// to MaxLongEdge, convert to grayscale and re-encode. The returned screenshot keeps
+// the device Width and Height, so coordinates are still converted against the real
+// screen; only the embedded image changes. The input is returned as is when opts
+// is zero or nothing needs to change.
+func Prepare(screenshot *definitions.Screenshot, opts definitions.ImageOptions) (*definitions.Screenshot, error) {
+	if screenshot == nil || opts.IsZero() {
+		return screenshot, nil
+	}
+
+	img, err := Decode(screenshot)
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if edge := max(width, height); opts.MaxLongEdge > 0 && edge > opts.MaxLongEdge {
+		width = max(width*opts.MaxLongEdge/edge, 1)
+		height = max(height*opts.MaxLongEdge/edge, 1)
+	}
+	resized := width != bounds.Dx() || height != bounds.Dy()
+	if !resized && !opts.Grayscale && opts.Format == definitions.ImageOriginal {
+		return screenshot, nil
+	}
+
+	var out image.Image = img
+	if resized {
+		out = Resize(img, width, height)
+	}
+	if opts.Grayscale {
+		out = toGray(out)
+	}
+
+	format := opts.Format
+	switch format {
+	case definitions.ImageOriginal:
+		format = definitions.ImagePNG
+		if screenshot.MimeType == "image/jpeg" {
+			format = definitions.ImageJPEG
+		}
+	}
+
+	var buf bytes.Buffer
+	mimeType := "image/png"
+	switch format {
+	case definitions.ImagePNG:
+		err = (&png.Encoder{CompressionLevel: png.BestSpeed}).Encode(&buf, out)
+	case definitions.ImageJPEG:
+		quality := opts.Quality
+		if quality <= 0 {
+			quality = definitions.DefaultImageQuality
+		}
+		mimeType = "image/jpeg"
+		err = jpeg.Encode(&buf, out, &jpeg.Options{Quality: min(quality, 100)})
+	default:
+		return nil, fmt.Errorf("unsupported image format: %s", opts.Format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode screenshot: %w", err)
+	}
+
+	return &definitions.Screenshot{
+		BinaryData:  buf.Bytes(),
+		MimeType:    mimeType,
+		Width:       screenshot.Width,
+		Height:      screenshot.Height,
+		IsSensitive: screenshot.IsSensitive,
+	}, nil
+}
+
+// Resize scales img to w*h by averaging the source pixels covered by each target
+// pixel (a box filter), which is fast and alias-free for downscaling.
+func Resize(img image.Image, w, h int) *image.RGBA {
+	src, ok := img.(*image.RGBA)
+	if !ok {
+		src = image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+		draw.Draw(src, src.Bounds(), img, img.Bounds().Min, draw.Src)
+	}
+	sb := src.Bounds()
+	sw, sh := sb.Dx(), sb.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+
+	for y := 0; y < h; y++ {
+		y0 := y * sh / h
+		y1 := max((y+1)*sh/h, y0+1)
+		for x := 0; x < w; x++ {
+			x0 := x * sw / w
+			x1 := max((x+1)*sw/w, x0+1)
+
+			var r, g, b, a, n uint32
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[(sy)*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					r += uint32(p[0])
+					g += uint32(p[1])
+					b += uint32(p[2])
+					a += uint32(p[3])
+					n++
+				}
+			}
+			d := dst.Pix[y*dst.Stride+x*4:]
+			d[0], d[1], d[2], d[3] = uint8(r/n), uint8(g/n), uint8(b/n), uint8(a/n)
+		}
+	}
+	return dst
+}
+
+func toGray(img image.Image) *image.Gray {
+	gray := image.NewGray(img.Bounds())
+	draw.Draw(gray, gray.Bounds(), img, img.Bounds().Min, draw.Src)
+	return gray
+}
@@ -0,0 +1,76 @@
+package imageutil
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/spance/autoglm-go/phoneagent/definitions"
+)
+
+func pngScreenshot(t *testing.T, w, h int) *definitions.Screenshot {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 200, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return &definitions.Screenshot{BinaryData: buf.Bytes(), Width: w, Height: h}
+}
+
+func TestPrepare(t *testing.T) {
+	original := pngScreenshot(t, 144, 320)
+
+	if same, _ := Prepare(original, definitions.ImageOptions{}); same != original {
+		t.Error("zero options must return the screenshot unchanged")
+	}
+	if same, _ := Prepare(original, definitions.ImageOptions{MaxLongEdge: 400}); same != original {
+		t.Error("a screenshot within MaxLongEdge must be returned unchanged")
+	}
+
+	out, err := Prepare(original, definitions.ImageOptions{MaxLongEdge: 160, Format: definitions.ImageJPEG, Grayscale: true})
+	if err != nil {
+		t.Fatalf("Prepare failed: %v", err)
+	}
+	if out.MimeType != "image/jpeg" {
+		t.Errorf("unexpected mime type %q", out.MimeType)
+	}
+	// The device size is kept for coordinate conversion
+	if out.Width != 144 || out.Height != 320 {
+		t.Errorf("device size changed to %dx%d", out.Width, out.Height)
+	}
+
+	img, err := Decode(out)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != 72 || b.Dy() != 160 {
+		t.Errorf("expected a 72x160 image, got %dx%d", b.Dx(), b.Dy())
+	}
+	if _, ok := img.(*image.Gray); !ok {
+		t.Errorf("expected a grayscale image, got %T", img)
+	}
+
+	if _, err := Prepare(original, definitions.ImageOptions{Format: "webp"}); err == nil {
+		t.Error("expected an error for an unsupported format")
+	}
+}
+
+func TestResizeAverages(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	img.Set(0, 0, color.RGBA{R: 255, A: 255})
+	img.Set(1, 0, color.RGBA{A: 255})
+	img.Set(0, 1, color.RGBA{R: 255, A: 255})
+	img.Set(1, 1, color.RGBA{A: 255})
+
+	out := Resize(img, 1, 1)
+	if c := out.RGBAAt(0, 0); c.R != 127 || c.A != 255 {
+		t.Errorf("expected the average color, got %+v", c)
+	}
+}
@@ -39,3 +39,9 @@ type Device interface {
 	DeviceOperator
 	DeviceManager
 }
+
+// UIHierarchyProvider is implemented by devices that can read the accessibility
+// view tree. It is optional: AgentConfig.UIElements and SnapTaps need it.
+type UIHierarchyProvider interface {
+	GetUIHierarchy(ctx context.Context, deviceID string) (*definitions.UINode, error)
+}
@@ -2,7 +2,9 @@ package llm
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"strings"
 	"time"
 
@@ -42,26 +44,19 @@ type ModelResponse struct {
 	TotalTime         float64
 }
 
-func (c *ModelClient) Request(ctx context.Context, messages []openai.ChatCompletionMessage) (*ModelResponse, error) {
-	startTime := time.Now()
+// ThinkingCallback receives incremental thinking text while a streaming response is being read.
+type ThinkingCallback func(delta string)
 
-	var (
-		timeToFirstToken  *float64
-		timeToThinkingEnd *float64
-	)
-
-	req := openai.ChatCompletionRequest{
-		Model:               c.config.ModelName,
-		Messages:            messages,
-		MaxCompletionTokens: c.config.MaxTokens,
-		Temperature:         c.config.Temperature,
-		TopP:                c.config.TopP,
-		FrequencyPenalty:    c.config.FrequencyPenalty,
-		Tools:               definitions.GetPhoneAgentTools(),
-		ToolChoice:          "auto",
-		Stream:              false,
+// Request sends the conversation to the model. It streams the response when
+// ModelConfig.Stream is enabled, which is required for real latency metrics.
+func (c *ModelClient) Request(ctx context.Context, messages []openai.ChatCompletionMessage) (*ModelResponse, error) {
+	if c.config.Stream {
+		return c.RequestStream(ctx, messages, nil)
 	}
 
+	startTime := time.Now()
+
+	req := c.buildRequest(messages)
 	resp, err := c.client.CreateChatCompletion(ctx, req)
 	if err != nil {
 		log.Error().Err(err).Msg("CreateChatCompletion error")
@@ -75,16 +70,8 @@ func (c *ModelClient) Request(ctx context.Context, messages []openai.ChatComplet
 	choice := resp.Choices[0]
 	totalTime := time.Since(startTime).Seconds()
 
-	// Record timing
-	t := totalTime
-	timeToFirstToken = &t
-	timeToThinkingEnd = &t
-
 	// Extract thinking from content
 	thinking := strings.TrimSpace(choice.Message.Content)
-	// if thinking != "" {
-	// 	log.Info().Msg(thinking)
-	// }
 
 	// Extract tool calls
 	var toolCalls []openai.ToolCall
@@ -97,6 +84,105 @@ func (c *ModelClient) Request(ctx context.Context, messages []openai.ChatComplet
 		action = fmt.Sprintf("%s(%s)", firstCall.Function.Name, firstCall.Function.Arguments)
 	}
 
+	// Without streaming only the total time is observable
+	printMetrics(c.config.Lang, nil, nil, totalTime)
+
+	return &ModelResponse{
+		Thinking:   thinking,
+		Action:     action,
+		ToolCalls:  toolCalls,
+		RawContent: choice.Message.Content,
+		TotalTime:  totalTime,
+	}, nil
+}
+
+// RequestStream sends the conversation with streaming enabled, forwarding thinking
+// deltas to onThinking (may be nil) and accumulating tool-call deltas.
+// TimeToFirstToken is measured at the first non-empty delta, TimeToThinkingEnd at
+// the first tool-call delta (or at the end of the stream if no tool is called).
+func (c *ModelClient) RequestStream(ctx context.Context, messages []openai.ChatCompletionMessage, onThinking ThinkingCallback) (*ModelResponse, error) {
+	startTime := time.Now()
+
+	var (
+		timeToFirstToken  *float64
+		timeToThinkingEnd *float64
+	)
+
+	req := c.buildRequest(messages)
+	req.Stream = true
+
+	stream, err := c.client.CreateChatCompletionStream(ctx, req)
+	if err != nil {
+		log.Error().Err(err).Msg("CreateChatCompletionStream error")
+		return nil, err
+	}
+	defer stream.Close()
+
+	var (
+		content   strings.Builder
+		reasoning strings.Builder
+		calls     toolCallAccumulator
+	)
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("chat completion stream error")
+			return nil, err
+		}
+		if len(chunk.Choices) == 0 {
+			continue
+		}
+		delta := chunk.Choices[0].Delta
+
+		if timeToFirstToken == nil && (delta.Content != "" || delta.ReasoningContent != "" || len(delta.ToolCalls) > 0) {
+			t := time.Since(startTime).Seconds()
+			timeToFirstToken = &t
+		}
+		if delta.ReasoningContent != "" {
+			reasoning.WriteString(delta.ReasoningContent)
+			if onThinking != nil && timeToThinkingEnd == nil {
+				onThinking(delta.ReasoningContent)
+			}
+		}
+		if delta.Content != "" {
+			content.WriteString(delta.Content)
+			if onThinking != nil && timeToThinkingEnd == nil {
+				onThinking(delta.Content)
+			}
+		}
+		if len(delta.ToolCalls) > 0 {
+			if timeToThinkingEnd == nil {
+				t := time.Since(startTime).Seconds()
+				timeToThinkingEnd = &t
+			}
+			calls.add(delta.ToolCalls)
+		}
+	}
+
+	totalTime := time.Since(startTime).Seconds()
+	if timeToFirstToken == nil {
+		return nil, fmt.Errorf("no response choices returned")
+	}
+	if timeToThinkingEnd == nil {
+		t := totalTime
+		timeToThinkingEnd = &t
+	}
+
+	thinking := strings.TrimSpace(content.String())
+	if thinking == "" {
+		thinking = strings.TrimSpace(reasoning.String())
+	}
+
+	toolCalls := calls.result()
+	var action string
+	if len(toolCalls) > 0 {
+		firstCall := toolCalls[0]
+		action = fmt.Sprintf("%s(%s)", firstCall.Function.Name, firstCall.Function.Arguments)
+	}
+
 	printMetrics(
 		c.config.Lang,
 		timeToFirstToken,
@@ -108,13 +194,85 @@ func (c *ModelClient) Request(ctx context.Context, messages []openai.ChatComplet
 		Thinking:          thinking,
 		Action:            action,
 		ToolCalls:         toolCalls,
-		RawContent:        choice.Message.Content,
+		RawContent:        content.String(),
 		TimeToFirstToken:  timeToFirstToken,
 		TimeToThinkingEnd: timeToThinkingEnd,
 		TotalTime:         totalTime,
 	}, nil
 }
 
+// Complete sends a plain chat request without tools and returns the text answer.
+// It is used for auxiliary calls such as history summarization.
+func (c *ModelClient) Complete(ctx context.Context, messages []openai.ChatCompletionMessage) (string, error) {
+	req := c.buildRequest(messages)
+	req.Tools = nil
+	req.ToolChoice = nil
+
+	resp, err := c.client.CreateChatCompletion(ctx, req)
+	if err != nil {
+		log.Error().Err(err).Msg("CreateChatCompletion error")
+		return "", err
+	}
+	if len(resp.Choices) == 0 {
+		return "", fmt.Errorf("no response choices returned")
+	}
+	return strings.TrimSpace(resp.Choices[0].Message.Content), nil
+}
+
+func (c *ModelClient) buildRequest(messages []openai.ChatCompletionMessage) openai.ChatCompletionRequest {
+	return openai.ChatCompletionRequest{
+		Model:               c.config.ModelName,
+		Messages:            messages,
+		MaxCompletionTokens: c.config.MaxTokens,
+		Temperature:         c.config.Temperature,
+		TopP:                c.config.TopP,
+		FrequencyPenalty:    c.config.FrequencyPenalty,
+		Tools:               definitions.GetPhoneAgentTools(),
+		ToolChoice:          "auto",
+		Stream:              false,
+	}
+}
+
+// toolCallAccumulator merges streamed tool-call fragments, keyed by their index.
+type toolCallAccumulator struct {
+	calls []openai.ToolCall
+}
+
+func (a *toolCallAccumulator) add(deltas []openai.ToolCall) {
+	for _, d := range deltas {
+		idx := len(a.calls) - 1
+		if d.Index != nil {
+			idx = *d.Index
+		} else if d.ID != "" || idx < 0 {
+			idx = len(a.calls)
+		}
+		for len(a.calls) <= idx {
+			a.calls = append(a.calls, openai.ToolCall{Type: openai.ToolTypeFunction})
+		}
+
+		call := &a.calls[idx]
+		if d.ID != "" {
+			call.ID = d.ID
+		}
+		if d.Type != "" {
+			call.Type = d.Type
+		}
+		call.Function.Name += d.Function.Name
+		call.Function.Arguments += d.Function.Arguments
+	}
+}
+
+func (a *toolCallAccumulator) result() []openai.ToolCall {
+	var calls []openai.ToolCall
+	for _, call := range a.calls {
+		if call.Function.Name == "" {
+			continue
+		}
+		calls = append(calls, call)
+	}
+	return calls
+}
+
 func printMetrics(lang string, firstToken *float64, thinkingEnd *float64, total float64) {
 	log.Info().Msg("")
 	log.Info().Msg(strings.Repeat("=", 50))
@@ -0,0 +1,59 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/spance/autoglm-go/phoneagent/definitions"
+)
+
+func TestRequestStream(t *testing.T) {
+	chunks := []string{
+		`{"choices":[{"index":0,"delta":{"role":"assistant","content":"Open the "}}]}`,
+		`{"choices":[{"index":0,"delta":{"content":"settings app."}}]}`,
+		`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"launch_app","arguments":"{\"app\":"}}]}}]}`,
+		`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"Settings\"}"}}]}}]}`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, c := range chunks {
+			fmt.Fprintf(w, "data: %s\n\n", c)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer server.Close()
+
+	client := NewModelClient(&definitions.ModelConfig{BaseURL: server.URL, ModelName: "test", Stream: true})
+
+	var deltas []string
+	resp, err := client.RequestStream(context.Background(), nil, func(delta string) {
+		deltas = append(deltas, delta)
+	})
+	if err != nil {
+		t.Fatalf("RequestStream failed: %v", err)
+	}
+
+	if resp.Thinking != "Open the settings app." {
+		t.Errorf("unexpected thinking: %q", resp.Thinking)
+	}
+	if strings.Join(deltas, "") != "Open the settings app." {
+		t.Errorf("unexpected thinking deltas: %q", deltas)
+	}
+	if len(resp.ToolCalls) != 1 {
+		t.Fatalf("expected 1 tool call, got %d", len(resp.ToolCalls))
+	}
+	call := resp.ToolCalls[0]
+	if call.ID != "call_1" || call.Function.Name != "launch_app" || call.Function.Arguments != `{"app":"Settings"}` {
+		t.Errorf("unexpected tool call: %+v", call)
+	}
+	if resp.TimeToFirstToken == nil || resp.TimeToThinkingEnd == nil {
+		t.Fatal("expected streaming metrics to be set")
+	}
+	if *resp.TimeToFirstToken > *resp.TimeToThinkingEnd || *resp.TimeToThinkingEnd > resp.TotalTime {
+		t.Errorf("metrics out of order: ttft=%f thinking_end=%f total=%f", *resp.TimeToFirstToken, *resp.TimeToThinkingEnd, resp.TotalTime)
+	}
+}
@@ -0,0 +1,216 @@
+// Package llmtest provides a local OpenAI-compatible /chat/completions server for
+// end-to-end agent tests without network access.
+package llmtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// Response is a canned model answer: optional thinking text plus an optional tool call.
+// A non-zero Status makes the server reply with an API error instead.
+type Response struct {
+	Content   string
+	ToolName  string
+	Arguments string // JSON-encoded tool arguments
+	Status    int
+	Error     string
+}
+
+// ToolCall builds a Response calling tool name with args after thinking.
+func ToolCall(thinking, name string, args map[string]any) Response {
+	if args == nil {
+		args = map[string]any{}
+	}
+	data, _ := json.Marshal(args)
+	return Response{Content: thinking, ToolName: name, Arguments: string(data)}
+}
+
+// Finish builds a Response calling finish_task with message.
+func Finish(message string) Response {
+	return ToolCall("The task is complete.", "finish_task", map[string]any{"message": message})
+}
+
+// Error builds a Response failing with an HTTP error.
+func Error(status int, message string) Response {
+	return Response{Status: status, Error: message}
+}
+
+// RuleFunc computes the response to a request, typically by inspecting its messages.
+type RuleFunc func(req *openai.ChatCompletionRequest) Response
+
+// Server is an httptest server speaking the /chat/completions protocol, both
+// streaming and non-streaming, with tool calls.
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	rule     RuleFunc
+	requests []openai.ChatCompletionRequest
+}
+
+// NewServer starts a server answering requests with script in order.
+// Once the script is exhausted every request fails with HTTP 500.
+func NewServer(script ...Response) *Server {
+	var (
+		mu   sync.Mutex
+		next int
+	)
+	return NewServerFunc(func(req *openai.ChatCompletionRequest) Response {
+		mu.Lock()
+		defer mu.Unlock()
+		if next >= len(script) {
+			return Error(http.StatusInternalServerError, "llmtest: script exhausted")
+		}
+		next++
+		return script[next-1]
+	})
+}
+
+// NewServerFunc starts a server answering requests with rule.
+func NewServerFunc(rule RuleFunc) *Server {
+	s := &Server{rule: rule}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/chat/completions", s.handleChatCompletions)
+	mux.HandleFunc("/v1/chat/completions", s.handleChatCompletions)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// BaseURL returns the value to use as ModelConfig.BaseURL.
+func (s *Server) BaseURL() string {
+	return s.URL
+}
+
+// Requests returns all requests received so far.
+func (s *Server) Requests() []openai.ChatCompletionRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]openai.ChatCompletionRequest(nil), s.requests...)
+}
+
+func (s *Server) handleChatCompletions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req openai.ChatCompletionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	s.mu.Unlock()
+
+	resp := s.rule(&req)
+	if resp.Status != 0 {
+		writeError(w, resp.Status, resp.Error)
+		return
+	}
+	if req.Stream {
+		writeStream(w, req.Model, resp)
+		return
+	}
+	writeCompletion(w, req.Model, resp)
+}
+
+func toolCalls(resp Response) []openai.ToolCall {
+	if resp.ToolName == "" {
+		return nil
+	}
+	return []openai.ToolCall{{
+		ID:   fmt.Sprintf("call_%d", time.Now().UnixNano()),
+		Type: openai.ToolTypeFunction,
+		Function: openai.FunctionCall{
+			Name:      resp.ToolName,
+			Arguments: resp.Arguments,
+		},
+	}}
+}
+
+func writeCompletion(w http.ResponseWriter, model string, resp Response) {
+	finishReason := openai.FinishReasonStop
+	if resp.ToolName != "" {
+		finishReason = openai.FinishReasonToolCalls
+	}
+	body := openai.ChatCompletionResponse{
+		ID:      "chatcmpl-llmtest",
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   model,
+		Choices: []openai.ChatCompletionChoice{{
+			Index: 0,
+			Message: openai.ChatCompletionMessage{
+				Role:      openai.ChatMessageRoleAssistant,
+				Content:   resp.Content,
+				ToolCalls: toolCalls(resp),
+			},
+			FinishReason: finishReason,
+		}},
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// writeStream sends the thinking word by word, then the tool call split in two
+// fragments, exercising delta accumulation on the client side.
+func writeStream(w http.ResponseWriter, model string, resp Response) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	flusher, _ := w.(http.Flusher)
+
+	send := func(delta openai.ChatCompletionStreamChoiceDelta, finishReason openai.FinishReason) {
+		chunk := openai.ChatCompletionStreamResponse{
+			ID:      "chatcmpl-llmtest",
+			Object:  "chat.completion.chunk",
+			Created: time.Now().Unix(),
+			Model:   model,
+			Choices: []openai.ChatCompletionStreamChoice{{Index: 0, Delta: delta, FinishReason: finishReason}},
+		}
+		data, _ := json.Marshal(chunk)
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	send(openai.ChatCompletionStreamChoiceDelta{Role: openai.ChatMessageRoleAssistant}, "")
+	for _, word := range strings.SplitAfter(resp.Content, " ") {
+		if word != "" {
+			send(openai.ChatCompletionStreamChoiceDelta{Content: word}, "")
+		}
+	}
+
+	if calls := toolCalls(resp); len(calls) > 0 {
+		index := 0
+		args := calls[0].Function.Arguments
+		half := len(args) / 2
+		first := calls[0]
+		first.Index = &index
+		first.Function.Arguments = args[:half]
+		send(openai.ChatCompletionStreamChoiceDelta{ToolCalls: []openai.ToolCall{first}}, "")
+		send(openai.ChatCompletionStreamChoiceDelta{ToolCalls: []openai.ToolCall{{
+			Index:    &index,
+			Function: openai.FunctionCall{Arguments: args[half:]},
+		}}}, openai.FinishReasonToolCalls)
+	} else {
+		send(openai.ChatCompletionStreamChoiceDelta{}, openai.FinishReasonStop)
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"error": map[string]any{"message": message, "type": "llmtest_error"},
+	})
+}
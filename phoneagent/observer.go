@@ -0,0 +1,94 @@
+package phoneagent
+
+import (
+	"context"
+
+	"github.com/spance/autoglm-go/phoneagent/definitions"
+	"github.com/spance/autoglm-go/phoneagent/helper"
+	"github.com/spance/autoglm-go/phoneagent/llm"
+)
+
+// StepObserver receives the phases of every ExecuteStep as they happen.
+// Callbacks run synchronously on the agent goroutine, so they should return quickly.
+type StepObserver interface {
+	// OnStepStart is called before the screenshot of a step is taken.
+	OnStepStart(ctx context.Context, step int, prompt string)
+	// OnScreenshot is called once the screenshot and foreground app are known.
+	OnScreenshot(ctx context.Context, step int, screenshot *definitions.Screenshot, currentApp string)
+	// OnModelResponse is called with the raw model response before the action is parsed.
+	OnModelResponse(ctx context.Context, step int, response *llm.ModelResponse)
+	// OnActionExecuted is called after the parsed action has been run on the device.
+	OnActionExecuted(ctx context.Context, step int, action helper.Action, result helper.ActionResult)
+	// OnFinish is called when the task ends, either by the model or by reaching MaxSteps.
+	OnFinish(ctx context.Context, step int, result *StepResult)
+	// OnError is called whenever a step fails.
+	OnError(ctx context.Context, step int, err error)
+}
+
+// ThinkingObserver is an optional extension of StepObserver. Observers implementing it
+// receive the model's thinking text incrementally when ModelConfig.Stream is enabled.
+type ThinkingObserver interface {
+	OnThinkingDelta(ctx context.Context, step int, delta string)
+}
+
+// NopObserver implements StepObserver with no-op methods.
+// Embed it to implement only the callbacks you care about.
+type NopObserver struct{}
+
+func (NopObserver) OnStepStart(ctx context.Context, step int, prompt string) {}
+
+func (NopObserver) OnScreenshot(ctx context.Context, step int, screenshot *definitions.Screenshot, currentApp string) {
+}
+
+func (NopObserver) OnModelResponse(ctx context.Context, step int, response *llm.ModelResponse) {}
+
+func (NopObserver) OnActionExecuted(ctx context.Context, step int, action helper.Action, result helper.ActionResult) {
+}
+
+func (NopObserver) OnFinish(ctx context.Context, step int, result *StepResult) {}
+
+func (NopObserver) OnError(ctx context.Context, step int, err error) {}
+
+// WithObserver registers a StepObserver on the agent.
+func WithObserver(o StepObserver) Option {
+	return func(r *PhoneAgent) {
+		r.AddObserver(o)
+	}
+}
+
+// AddObserver registers a StepObserver. Observers are notified in registration order.
+func (r *PhoneAgent) AddObserver(o StepObserver) {
+	if o == nil {
+		return
+	}
+	r.observers = append(r.observers, o)
+}
+
+// thinkingCallback returns a callback fanning thinking deltas out to ThinkingObservers,
+// or nil when no registered observer is interested.
+func (r *PhoneAgent) thinkingCallback(ctx context.Context) llm.ThinkingCallback {
+	var targets []ThinkingObserver
+	for _, o := range r.observers {
+		if t, ok := o.(ThinkingObserver); ok {
+			targets = append(targets, t)
+		}
+	}
+	if len(targets) == 0 {
+		return nil
+	}
+	step := r.StepCount
+	return func(delta string) {
+		for _, t := range targets {
+			t.OnThinkingDelta(ctx, step, delta)
+		}
+	}
+}
+
+func (r *PhoneAgent) notify(fn func(o StepObserver)) {
+	if r.trajectory != nil {
+		fn(r.trajectory)
+	}
+	for _, o := range r.observers {
+		fn(o)
+	}
+}
@@ -0,0 +1,276 @@
+package phoneagent
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spance/autoglm-go/phoneagent/definitions"
+)
+
+// Task is one task for a Runner. Zero fields use the Runner defaults.
+type Task struct {
+	ID       string `json:"id,omitempty" yaml:"id"` // "task-N" (1-based) when empty
+	Prompt   string `json:"task" yaml:"task"`
+	DeviceID string `json:"device,omitempty" yaml:"device"`       // run only on this device
+	MaxSteps int    `json:"max_steps,omitempty" yaml:"max_steps"` // overrides AgentConfig.MaxSteps
+	Lang     string `json:"lang,omitempty" yaml:"lang"`           // overrides AgentConfig.Lang
+	Expect   string `json:"expect,omitempty" yaml:"expect"`       // expected outcome, given to the model as a hint
+}
+
+// TaskResult is the outcome of one task on one device.
+type TaskResult struct {
+	TaskID   string        `json:"task_id"`
+	DeviceID string        `json:"device_id"`
+	Result   string        `json:"result"`
+	Finished bool          `json:"finished"` // the model finished the task before MaxSteps
+	Success  bool          `json:"success"`  // the finishing step succeeded, false e.g. when the user rejected a confirmation
+	Steps    int           `json:"steps"`
+	Error    string        `json:"error,omitempty"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration"`
+
+	Err error `json:"-"`
+}
+
+// Passed reports whether the task ran without error and was finished successfully
+// by the model.
+func (t *TaskResult) Passed() bool {
+	return t.Err == nil && t.Finished && t.Success
+}
+
+// RunReport aggregates the results of a Runner.
+type RunReport struct {
+	Results  []TaskResult  `json:"results"` // ordered by task, then by device
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration"`
+}
+
+// Passed returns the number of passed results.
+func (r *RunReport) Passed() int {
+	n := 0
+	for i := range r.Results {
+		if r.Results[i].Passed() {
+			n++
+		}
+	}
+	return n
+}
+
+// Failed returns the number of results that did not pass.
+func (r *RunReport) Failed() int {
+	return len(r.Results) - r.Passed()
+}
+
+// RunMode selects how a Runner spreads tasks over devices.
+type RunMode string
+
+const (
+	RunEachDevice RunMode = ""       // every device runs every task
+	RunShared     RunMode = "shared" // each task runs once, on the next free device
+)
+
+// Runner runs tasks on several devices concurrently; each device runs its tasks one
+// after the other. Every task gets a fresh PhoneAgent with a copy of AgentConfig
+// for its device, logging through a logger tagged with the device ID.
+type Runner struct {
+	Device      Device // drives every device; the device ID selects the target
+	ModelConfig *definitions.ModelConfig
+	AgentConfig *definitions.AgentConfig // template, copied for each device
+
+	// DeviceIDs are the devices to use. When empty, Run uses every device that
+	// Device.ListDevices reports as ready.
+	DeviceIDs []string
+	// Parallelism is the maximum number of devices running at once, 0 means all.
+	Parallelism int
+	Mode        RunMode
+	// Options returns extra options for the agent of a device (handlers, observers...).
+	Options func(deviceID string) []Option
+}
+
+// NewRunner creates a Runner using every connected device.
+func NewRunner(device Device, modelConfig *definitions.ModelConfig, agentConfig *definitions.AgentConfig) *Runner {
+	return &Runner{Device: device, ModelConfig: modelConfig, AgentConfig: agentConfig}
+}
+
+// Devices returns the devices Run will use.
+func (p *Runner) Devices(ctx context.Context) ([]string, error) {
+	if len(p.DeviceIDs) > 0 {
+		return p.DeviceIDs, nil
+	}
+	devices, err := p.Device.ListDevices(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list devices: %w", err)
+	}
+	var ids []string
+	for _, device := range devices {
+		if device.Status == "device" {
+			ids = append(ids, device.DeviceID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("no device is ready")
+	}
+	return ids, nil
+}
+
+// Run executes the tasks and returns the results of all of them. Task failures are
+// reported in the results; the error is only set when no device could be used.
+// Canceling ctx stops the running tasks and skips the remaining ones.
+func (p *Runner) Run(ctx context.Context, tasks []Task) (*RunReport, error) {
+	deviceIDs, err := p.Devices(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tasks = append([]Task(nil), tasks...)
+	for i := range tasks {
+		if tasks[i].ID == "" {
+			tasks[i].ID = fmt.Sprintf("task-%d", i+1)
+		}
+	}
+	report := &RunReport{Start: time.Now()}
+
+	// Every device has its own queue; in RunShared mode the tasks not pinned to a
+	// device go to a shared queue drained by whichever device is free. Results are
+	// stored by slot so their order does not depend on timing.
+	type job struct {
+		task, slot int
+	}
+	if p.Mode != RunEachDevice && p.Mode != RunShared {
+		return nil, fmt.Errorf("unknown run mode: %s", p.Mode)
+	}
+	queues := make(map[string]chan job, len(deviceIDs))
+	for _, deviceID := range deviceIDs {
+		queues[deviceID] = make(chan job, len(tasks))
+	}
+	shared := make(chan job, len(tasks))
+	enqueue := func(q chan job, task int, deviceID string) {
+		q <- job{task: task, slot: len(report.Results)}
+		report.Results = append(report.Results, TaskResult{TaskID: tasks[task].ID, DeviceID: deviceID})
+	}
+	for i, task := range tasks {
+		switch {
+		case task.DeviceID != "":
+			q, ok := queues[task.DeviceID]
+			if !ok {
+				err := fmt.Errorf("device %s is not available", task.DeviceID)
+				report.Results = append(report.Results, TaskResult{TaskID: task.ID, DeviceID: task.DeviceID, Error: err.Error(), Err: err})
+				continue
+			}
+			enqueue(q, i, task.DeviceID)
+		case p.Mode == RunShared:
+			enqueue(shared, i, "")
+		default:
+			for _, deviceID := range deviceIDs {
+				enqueue(queues[deviceID], i, deviceID)
+			}
+		}
+	}
+	close(shared)
+	for _, q := range queues {
+		close(q)
+	}
+
+	parallelism := p.Parallelism
+	if parallelism <= 0 || parallelism > len(deviceIDs) {
+		parallelism = len(deviceIDs)
+	}
+	sem := make(chan struct{}, parallelism)
+
+	var wg sync.WaitGroup
+	for _, deviceID := range deviceIDs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+			}
+
+			for _, jobs := range []chan job{queues[deviceID], shared} {
+				for j := range jobs {
+					report.Results[j.slot] = p.RunTask(ctx, deviceID, tasks[j.task])
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	report.Duration = time.Since(report.Start)
+	return report, nil
+}
+
+func (p *Runner) newAgent(deviceID string, task Task, extra []Option) *PhoneAgent {
+	agentConfig := *p.AgentConfig
+	agentConfig.DeviceID = deviceID
+	if task.MaxSteps > 0 {
+		agentConfig.MaxSteps = task.MaxSteps
+	}
+	if task.Lang != "" {
+		agentConfig.Lang = task.Lang
+	}
+	if agentConfig.SessionPath != "" {
+		// One session file per device
+		ext := filepath.Ext(agentConfig.SessionPath)
+		agentConfig.SessionPath = strings.TrimSuffix(agentConfig.SessionPath, ext) + "-" + safeFileName(deviceID) + ext
+	}
+
+	opts := []Option{WithLogger(log.Logger.With().Str("device", deviceID).Logger())}
+	if p.Options != nil {
+		opts = append(opts, p.Options(deviceID)...)
+	}
+	opts = append(opts, extra...)
+	return NewPhoneAgent(p.Device, p.ModelConfig, &agentConfig, opts...)
+}
+
+// RunTask runs a single task on a device with a fresh agent; opts are applied after
+// Runner.Options. The caller must not run two tasks on the same device at once.
+func (p *Runner) RunTask(ctx context.Context, deviceID string, task Task, opts ...Option) TaskResult {
+	result := TaskResult{TaskID: task.ID, DeviceID: deviceID, Start: time.Now()}
+	if err := ctx.Err(); err != nil {
+		result.Err, result.Error = err, err.Error()
+		return result
+	}
+
+	agent := p.newAgent(deviceID, task, opts)
+	prompt := task.Prompt
+	if task.Expect != "" {
+		if agent.AgentConfig.Lang == "en" {
+			prompt += "\n\nExpected outcome: " + task.Expect
+		} else {
+			prompt += "\n\n预期结果：" + task.Expect
+		}
+	}
+
+	agent.logger.Info().Str("task", task.ID).Msg("Starting task")
+	output, finished, err := agent.run(ctx, prompt)
+	agent.closeTrajectory(finished, output, err)
+
+	result.Result, result.Finished, result.Err = output, finished, err
+	result.Success = finished && agent.succeeded
+	result.Steps = agent.StepCount
+	result.Duration = time.Since(result.Start)
+	if err != nil {
+		result.Error = err.Error()
+		agent.logger.Error().Str("task", task.ID).Err(err).Msg("Task failed")
+	} else {
+		agent.logger.Info().Str("task", task.ID).Bool("finished", finished).Str("result", output).Msg("Task done")
+	}
+	return result
+}
+
+// safeFileName replaces the characters of a device ID (e.g. "192.168.1.2:5555")
+// that do not belong in a file name.
+func safeFileName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '.' || r == '-' || r == '_' || ('0' <= r && r <= '9') || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') {
+			return r
+		}
+		return '_'
+	}, s)
+}
@@ -0,0 +1,165 @@
+package phoneagent_test
+
+import (
+	"context"
+	"image/color"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/spance/autoglm-go/phoneagent"
+	"github.com/spance/autoglm-go/phoneagent/definitions"
+	"github.com/spance/autoglm-go/phoneagent/devicetest"
+	"github.com/spance/autoglm-go/phoneagent/llm/llmtest"
+)
+
+// echoServer finishes every task with its prompt and tracks concurrent requests.
+type echoServer struct {
+	*llmtest.Server
+	mu      sync.Mutex
+	running int
+	peak    int
+}
+
+func newEchoServer() *echoServer {
+	s := &echoServer{}
+	s.Server = llmtest.NewServerFunc(func(req *openai.ChatCompletionRequest) llmtest.Response {
+		s.mu.Lock()
+		s.running++
+		s.peak = max(s.peak, s.running)
+		s.mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		s.mu.Lock()
+		s.running--
+		s.mu.Unlock()
+
+		prompt, _, _ := strings.Cut(req.Messages[1].MultiContent[0].Text, "\n\n")
+		return llmtest.Finish(prompt)
+	})
+	return s
+}
+
+func newPoolDevice() *devicetest.FakeDevice {
+	device := devicetest.NewFakeDevice(devicetest.SolidScreen(10, 10, color.White, "Home"))
+	device.SetDevices(
+		definitions.DeviceInfo{DeviceID: "phone-1", Status: "device"},
+		definitions.DeviceInfo{DeviceID: "phone-2", Status: "device"},
+		definitions.DeviceInfo{DeviceID: "phone-3", Status: "offline"},
+	)
+	return device
+}
+
+func TestRunnerEachDevice(t *testing.T) {
+	server := newEchoServer()
+	defer server.Close()
+	device := newPoolDevice()
+
+	modelConfig := &definitions.ModelConfig{BaseURL: server.BaseURL(), ModelName: "autoglm-phone"}
+	runner := phoneagent.NewRunner(device, modelConfig, &definitions.AgentConfig{MaxSteps: 5, Lang: "en", Settle: noSettle})
+	report, err := runner.Run(context.Background(), []phoneagent.Task{{Prompt: "Open Settings"}, {ID: "search", Prompt: "Search"}})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	want := []struct{ task, device, result string }{
+		{"task-1", "phone-1", "Open Settings"},
+		{"task-1", "phone-2", "Open Settings"},
+		{"search", "phone-1", "Search"},
+		{"search", "phone-2", "Search"},
+	}
+	if len(report.Results) != len(want) {
+		t.Fatalf("expected %d results, got %+v", len(want), report.Results)
+	}
+	for i, w := range want {
+		got := report.Results[i]
+		if got.TaskID != w.task || got.DeviceID != w.device || got.Result != w.result || !got.Passed() {
+			t.Errorf("result %d: got %+v, want %+v", i, got, w)
+		}
+	}
+	if report.Passed() != 4 || report.Failed() != 0 {
+		t.Errorf("unexpected counts: %d passed, %d failed", report.Passed(), report.Failed())
+	}
+	if server.peak != 2 {
+		t.Errorf("expected both devices to run at once, peak was %d", server.peak)
+	}
+	// Every screenshot was taken on one of the ready devices
+	for _, call := range device.CallsTo("GetScreenshot") {
+		if call.DeviceID != "phone-1" && call.DeviceID != "phone-2" {
+			t.Errorf("unexpected device %q", call.DeviceID)
+		}
+	}
+}
+
+func TestRunnerSharedBounded(t *testing.T) {
+	server := newEchoServer()
+	defer server.Close()
+	device := newPoolDevice()
+
+	modelConfig := &definitions.ModelConfig{BaseURL: server.BaseURL(), ModelName: "autoglm-phone"}
+	runner := phoneagent.NewRunner(device, modelConfig, &definitions.AgentConfig{MaxSteps: 5, Lang: "en", Settle: noSettle})
+	runner.Mode = phoneagent.RunShared
+	runner.Parallelism = 1
+
+	tasks := []phoneagent.Task{{Prompt: "a"}, {Prompt: "b"}, {Prompt: "c"}}
+	report, err := runner.Run(context.Background(), tasks)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if len(report.Results) != 3 || report.Passed() != 3 {
+		t.Fatalf("expected 3 passed results, got %+v", report.Results)
+	}
+	for i, result := range report.Results {
+		if result.Result != tasks[i].Prompt {
+			t.Errorf("result %d is %q, want %q", i, result.Result, tasks[i].Prompt)
+		}
+	}
+	if server.peak != 1 {
+		t.Errorf("parallelism 1 exceeded, peak was %d", server.peak)
+	}
+}
+
+func TestRunnerNoDevice(t *testing.T) {
+	device := devicetest.NewFakeDevice()
+	device.SetDevices(definitions.DeviceInfo{DeviceID: "phone-1", Status: "unauthorized"})
+	runner := phoneagent.NewRunner(device, &definitions.ModelConfig{}, &definitions.AgentConfig{MaxSteps: 5, Settle: noSettle})
+	if _, err := runner.Run(context.Background(), []phoneagent.Task{{Prompt: "a"}}); err == nil {
+		t.Error("expected an error without ready devices")
+	}
+}
+
+func TestRunnerTaskOverrides(t *testing.T) {
+	server := newEchoServer()
+	defer server.Close()
+	device := newPoolDevice()
+
+	modelConfig := &definitions.ModelConfig{BaseURL: server.BaseURL(), ModelName: "autoglm-phone"}
+	runner := phoneagent.NewRunner(device, modelConfig, &definitions.AgentConfig{MaxSteps: 5, Lang: "cn", Settle: noSettle})
+	tasks := []phoneagent.Task{
+		{ID: "pinned", Prompt: "Open Settings", DeviceID: "phone-2", Lang: "en", Expect: "Settings is open"},
+		{ID: "missing", Prompt: "Open Settings", DeviceID: "phone-9"},
+	}
+	report, err := runner.Run(context.Background(), tasks)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if len(report.Results) != 2 {
+		t.Fatalf("expected 2 results, got %+v", report.Results)
+	}
+	if pinned := report.Results[0]; pinned.DeviceID != "phone-2" || !pinned.Passed() {
+		t.Errorf("unexpected pinned result %+v", pinned)
+	}
+	if missing := report.Results[1]; missing.Passed() || !strings.Contains(missing.Error, "phone-9") {
+		t.Errorf("expected the unavailable device to be reported, got %+v", missing)
+	}
+
+	// The task ran with its own language and got the expected outcome as a hint
+	req := server.Requests()[0]
+	if !strings.HasPrefix(req.Messages[0].Content, "The current date") {
+		t.Errorf("expected the English system prompt")
+	}
+	if !strings.Contains(req.Messages[1].MultiContent[0].Text, "Expected outcome: Settings is open") {
+		t.Errorf("expected outcome hint missing: %q", req.Messages[1].MultiContent[0].Text)
+	}
+}
@@ -0,0 +1,183 @@
+// Package redact masks parts of screenshots before they leave the machine.
+//
+// Regions masks fixed areas of the screen (a status bar, a balance widget, ...);
+// TextPatterns masks the text boxes found by a TextDetector whose text matches
+// one of its patterns. Both pixelate the areas with blocks coarse enough to make
+// the content unreadable, and satisfy phoneagent.Redactor.
+package redact
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"regexp"
+
+	"github.com/spance/autoglm-go/phoneagent/definitions"
+	"github.com/spance/autoglm-go/phoneagent/imageutil"
+)
+
+// DefaultBlockSize is the pixelation block edge, in device pixels.
+const DefaultBlockSize = 24
+
+// Region is a rectangle in the 0-1000 coordinate space the model uses, so the
+// same region fits any screen resolution.
+type Region struct {
+	X1, Y1, X2, Y2 int
+}
+
+// Rect converts the region to device pixels.
+func (r Region) Rect(width, height int) image.Rectangle {
+	return image.Rect(r.X1*width/1000, r.Y1*height/1000, r.X2*width/1000, r.Y2*height/1000)
+}
+
+// Regions pixelates fixed screen regions.
+type Regions struct {
+	Regions   []Region
+	BlockSize int // DefaultBlockSize when 0
+}
+
+func (r Regions) Redact(ctx context.Context, screenshot *definitions.Screenshot) (*definitions.Screenshot, error) {
+	if len(r.Regions) == 0 {
+		return screenshot, nil
+	}
+	rects := make([]image.Rectangle, 0, len(r.Regions))
+	for _, region := range r.Regions {
+		rects = append(rects, region.Rect(screenshot.Width, screenshot.Height))
+	}
+	return Pixelate(screenshot, rects, r.BlockSize)
+}
+
+// TextBox is a piece of text found on the screen, with its bounds in device pixels.
+type TextBox struct {
+	Text   string
+	Bounds image.Rectangle
+}
+
+// TextDetector finds the text on a screenshot, e.g. with OCR or the UI hierarchy.
+type TextDetector interface {
+	DetectText(ctx context.Context, screenshot *definitions.Screenshot) ([]TextBox, error)
+}
+
+// TextDetectorFunc adapts a function to TextDetector.
+type TextDetectorFunc func(ctx context.Context, screenshot *definitions.Screenshot) ([]TextBox, error)
+
+func (f TextDetectorFunc) DetectText(ctx context.Context, screenshot *definitions.Screenshot) ([]TextBox, error) {
+	return f(ctx, screenshot)
+}
+
+// DefaultPatterns match common personal data: mainland China mobile numbers,
+// resident ID numbers, bank card numbers and e-mail addresses.
+var DefaultPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(^|\D)1[3-9]\d{9}(\D|$)`),
+	regexp.MustCompile(`(^|\D)\d{17}[\dXx](\D|$)`),
+	regexp.MustCompile(`(^|\D)\d{4}[ -]?\d{4}[ -]?\d{4}[ -]?\d{4}(\d{0,3})(\D|$)`),
+	regexp.MustCompile(`[\w.+-]+@[\w-]+\.[\w.-]+`),
+}
+
+// TextPatterns pixelates the text boxes matching any of Patterns.
+type TextPatterns struct {
+	Detector  TextDetector
+	Patterns  []*regexp.Regexp // DefaultPatterns when empty
+	BlockSize int              // DefaultBlockSize when 0
+}
+
+func (t TextPatterns) Redact(ctx context.Context, screenshot *definitions.Screenshot) (*definitions.Screenshot, error) {
+	if t.Detector == nil {
+		return nil, fmt.Errorf("no text detector configured")
+	}
+	boxes, err := t.Detector.DetectText(ctx, screenshot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to detect text: %w", err)
+	}
+	patterns := t.Patterns
+	if len(patterns) == 0 {
+		patterns = DefaultPatterns
+	}
+
+	var rects []image.Rectangle
+	for _, box := range boxes {
+		for _, pattern := range patterns {
+			if pattern.MatchString(box.Text) {
+				rects = append(rects, box.Bounds)
+				break
+			}
+		}
+	}
+	if len(rects) == 0 {
+		return screenshot, nil
+	}
+	return Pixelate(screenshot, rects, t.BlockSize)
+}
+
+// Pixelate replaces each rectangle (in device pixels) of the screenshot with
+// blocks of its average color and returns the result as a new PNG screenshot,
+// with the rectangles added to Screenshot.Redacted.
+func Pixelate(screenshot *definitions.Screenshot, rects []image.Rectangle, blockSize int) (*definitions.Screenshot, error) {
+	if blockSize <= 0 {
+		blockSize = DefaultBlockSize
+	}
+	img, err := imageutil.Decode(screenshot)
+	if err != nil {
+		return nil, err
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+	draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min, draw.Src)
+
+	// Rectangles are in device pixels, the image may have another size
+	sx := float64(dst.Bounds().Dx()) / float64(max(screenshot.Width, 1))
+	sy := float64(dst.Bounds().Dy()) / float64(max(screenshot.Height, 1))
+	for _, rect := range rects {
+		scaled := image.Rect(
+			int(float64(rect.Min.X)*sx), int(float64(rect.Min.Y)*sy),
+			int(float64(rect.Max.X)*sx+0.5), int(float64(rect.Max.Y)*sy+0.5),
+		).Intersect(dst.Bounds())
+		pixelate(dst, scaled, max(int(float64(blockSize)*sx), 1))
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, dst); err != nil {
+		return nil, fmt.Errorf("failed to encode redacted screenshot: %w", err)
+	}
+	redacted := append([]definitions.Bounds(nil), screenshot.Redacted...)
+	for _, rect := range rects {
+		redacted = append(redacted, definitions.Bounds{X1: rect.Min.X, Y1: rect.Min.Y, X2: rect.Max.X, Y2: rect.Max.Y})
+	}
+	return &definitions.Screenshot{
+		BinaryData:  buf.Bytes(),
+		MimeType:    "image/png",
+		Width:       screenshot.Width,
+		Height:      screenshot.Height,
+		IsSensitive: screenshot.IsSensitive,
+		Redacted:    redacted,
+	}, nil
+}
+
+func pixelate(img *image.RGBA, rect image.Rectangle, block int) {
+	for by := rect.Min.Y; by < rect.Max.Y; by += block {
+		for bx := rect.Min.X; bx < rect.Max.X; bx += block {
+			cell := image.Rect(bx, by, min(bx+block, rect.Max.X), min(by+block, rect.Max.Y))
+
+			var r, g, b, a, n uint32
+			for y := cell.Min.Y; y < cell.Max.Y; y++ {
+				for x := cell.Min.X; x < cell.Max.X; x++ {
+					p := img.Pix[img.PixOffset(x, y):]
+					r, g, b, a = r+uint32(p[0]), g+uint32(p[1]), b+uint32(p[2]), a+uint32(p[3])
+					n++
+				}
+			}
+			if n == 0 {
+				continue
+			}
+			avg := [4]uint8{uint8(r / n), uint8(g / n), uint8(b / n), uint8(a / n)}
+			for y := cell.Min.Y; y < cell.Max.Y; y++ {
+				for x := cell.Min.X; x < cell.Max.X; x++ {
+					copy(img.Pix[img.PixOffset(x, y):], avg[:])
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package redact
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/spance/autoglm-go/phoneagent/definitions"
+	"github.com/spance/autoglm-go/phoneagent/imageutil"
+)
+
+// stripes builds a screenshot of alternating black and white columns, which any
+// pixelation turns gray.
+func stripes(t *testing.T, width, height int) *definitions.Screenshot {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if x%2 == 0 {
+				img.SetGray(x, y, color.Gray{Y: 255})
+			}
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	return &definitions.Screenshot{BinaryData: buf.Bytes(), Width: width, Height: height}
+}
+
+func pixel(t *testing.T, screenshot *definitions.Screenshot, x, y int) uint8 {
+	img, err := imageutil.Decode(screenshot)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	return color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y
+}
+
+func TestRegions(t *testing.T) {
+	screenshot := stripes(t, 100, 200)
+	redacted, err := Regions{Regions: []Region{{X1: 0, Y1: 0, X2: 1000, Y2: 500}}, BlockSize: 10}.
+		Redact(context.Background(), screenshot)
+	if err != nil {
+		t.Fatalf("Redact failed: %v", err)
+	}
+	if redacted.Width != 100 || redacted.Height != 200 {
+		t.Errorf("device size changed to %dx%d", redacted.Width, redacted.Height)
+	}
+	if want := (definitions.Bounds{X2: 100, Y2: 100}); len(redacted.Redacted) != 1 || redacted.Redacted[0] != want {
+		t.Errorf("expected redacted region %v, got %v", want, redacted.Redacted)
+	}
+	if p := pixel(t, redacted, 10, 50); p == 0 || p == 255 {
+		t.Errorf("region not pixelated, pixel is %d", p)
+	}
+	if pixel(t, redacted, 10, 150) != 255 || pixel(t, redacted, 11, 150) != 0 {
+		t.Error("pixels outside the region changed")
+	}
+}
+
+func TestTextPatterns(t *testing.T) {
+	screenshot := stripes(t, 100, 100)
+	detector := TextDetectorFunc(func(ctx context.Context, s *definitions.Screenshot) ([]TextBox, error) {
+		return []TextBox{
+			{Text: "Phone: 13812345678", Bounds: image.Rect(0, 0, 50, 20)},
+			{Text: "Order 42", Bounds: image.Rect(0, 50, 50, 70)},
+		}, nil
+	})
+
+	redacted, err := TextPatterns{Detector: detector, BlockSize: 4}.Redact(context.Background(), screenshot)
+	if err != nil {
+		t.Fatalf("Redact failed: %v", err)
+	}
+	if p := pixel(t, redacted, 10, 10); p == 0 || p == 255 {
+		t.Errorf("phone number not pixelated, pixel is %d", p)
+	}
+	if pixel(t, redacted, 10, 60) != 255 {
+		t.Error("text without personal data was pixelated")
+	}
+}
+
+func TestTextPatternsNoMatch(t *testing.T) {
+	screenshot := stripes(t, 10, 10)
+	detector := TextDetectorFunc(func(ctx context.Context, s *definitions.Screenshot) ([]TextBox, error) {
+		return []TextBox{{Text: "Settings", Bounds: image.Rect(0, 0, 10, 10)}}, nil
+	})
+	redacted, err := TextPatterns{Detector: detector}.Redact(context.Background(), screenshot)
+	if err != nil || redacted != screenshot {
+		t.Errorf("expected the screenshot unchanged, got %v", err)
+	}
+}
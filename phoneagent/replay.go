@@ -0,0 +1,138 @@
+package phoneagent
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spance/autoglm-go/phoneagent/definitions"
+	"github.com/spance/autoglm-go/phoneagent/helper"
+	"github.com/spance/autoglm-go/phoneagent/imageutil"
+	"github.com/spance/autoglm-go/phoneagent/trajectory"
+	"github.com/spance/autoglm-go/utils"
+)
+
+// DefaultDivergenceThreshold is the screen difference above which a replayed step is
+// considered to have diverged from the recording.
+const DefaultDivergenceThreshold = 0.1
+
+// Replayer re-executes the actions of a recorded trajectory against a device,
+// without calling the model.
+type Replayer struct {
+	// Compare enables comparing a fresh screenshot with the recorded one before each step.
+	// Redacted recorded screenshots are not compared, they never match the screen.
+	Compare bool
+	// Threshold is the maximum screen difference (0-1) tolerated when Compare is set.
+	Threshold float64
+	// StopOnDivergence stops the replay at the first diverging step.
+	StopOnDivergence bool
+
+	agent *PhoneAgent
+}
+
+// ReplayStep is the outcome of one replayed step.
+type ReplayStep struct {
+	Step       int
+	Action     helper.Action
+	Result     helper.ActionResult
+	Difference float64
+	Diverged   bool
+	Err        error
+}
+
+// ReplayResult summarizes a replay.
+type ReplayResult struct {
+	Steps    []ReplayStep
+	Finished bool // a recorded finish action was reached
+	Diverged bool
+}
+
+// NewReplayer creates a Replayer driving device. Handlers from opts (confirmation,
+// takeover, ...) are used for the replayed actions that need them.
+func NewReplayer(device Device, agentConfig *definitions.AgentConfig, opts ...Option) *Replayer {
+	return &Replayer{
+		Compare:          true,
+		Threshold:        DefaultDivergenceThreshold,
+		StopOnDivergence: true,
+		agent:            NewPhoneAgent(device, nil, agentConfig, opts...),
+	}
+}
+
+// Replay executes the recorded actions of run in order.
+func (p *Replayer) Replay(ctx context.Context, run *trajectory.Run) (*ReplayResult, error) {
+	agent := p.agent
+	result := &ReplayResult{}
+
+	for i := range run.Steps {
+		rec := &run.Steps[i]
+		if rec.Action == nil {
+			agent.logger.Debug().Int("step", rec.Step).Msg("skipping recorded step without action")
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		agent.StepCount = rec.Step
+
+		screenshot, err := agent.captureScreenshot(ctx)
+		if err != nil {
+			return result, fmt.Errorf("failed to get screenshot at step %d: %w", rec.Step, err)
+		}
+
+		step := ReplayStep{Step: rec.Step, Action: rec.Action}
+		if p.Compare {
+			step.Difference, err = p.compare(run, rec, screenshot)
+			if err != nil {
+				agent.logger.Warn().Int("step", rec.Step).Err(err).Msg("failed to compare screenshots")
+			} else if step.Difference > p.Threshold {
+				step.Diverged = true
+				result.Diverged = true
+				agent.logger.Warn().Int("step", rec.Step).Float64("difference", step.Difference).Msg("screen diverged from recording")
+				if p.StopOnDivergence {
+					result.Steps = append(result.Steps, step)
+					return result, nil
+				}
+			}
+		}
+
+		// Notes and call_api only involve the model, keep their recorded outcome
+		if name := utils.AnyToString(rec.Action["action"]); name == "Note" || name == "Call_API" {
+			if rec.Result != nil {
+				step.Result = *rec.Result
+			}
+			result.Steps = append(result.Steps, step)
+			continue
+		}
+
+		agent.logger.Info().Int("step", rec.Step).Str("action", utils.JsonString(rec.Action)).Msg("replaying action")
+		step.Result, step.Err = agent.ExecuteAction(ctx, rec.Action, screenshot.Width, screenshot.Height)
+		result.Steps = append(result.Steps, step)
+		if step.Err != nil {
+			return result, fmt.Errorf("failed to execute action at step %d: %w", rec.Step, step.Err)
+		}
+		if step.Result.ShouldFinish {
+			result.Finished = true
+			return result, nil
+		}
+	}
+	return result, nil
+}
+
+func (p *Replayer) compare(run *trajectory.Run, rec *trajectory.StepRecord, screenshot *definitions.Screenshot) (float64, error) {
+	if rec.Redacted {
+		p.agent.logger.Debug().Int("step", rec.Step).Msg("recorded screenshot was redacted, not compared")
+		return 0, nil
+	}
+	recorded, err := run.Screenshot(rec)
+	if err != nil || recorded == nil {
+		return 0, err
+	}
+	want, err := imageutil.Decode(recorded)
+	if err != nil {
+		return 0, err
+	}
+	got, err := imageutil.Decode(screenshot)
+	if err != nil {
+		return 0, err
+	}
+	return imageutil.Difference(want, got), nil
+}
@@ -0,0 +1,163 @@
+package phoneagent_test
+
+import (
+	"context"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"path/filepath"
+	"testing"
+
+	"github.com/spance/autoglm-go/phoneagent"
+	"github.com/spance/autoglm-go/phoneagent/definitions"
+	"github.com/spance/autoglm-go/phoneagent/devicetest"
+	"github.com/spance/autoglm-go/phoneagent/helper"
+	"github.com/spance/autoglm-go/phoneagent/llm/llmtest"
+	"github.com/spance/autoglm-go/phoneagent/redact"
+	"github.com/spance/autoglm-go/phoneagent/trajectory"
+)
+
+// recordRun records a run tapping the screen, taking a note, calling the API and
+// finishing, all on screen.
+func recordRun(t *testing.T, screen devicetest.Screen) *trajectory.Run {
+	t.Helper()
+	recorder, err := trajectory.NewRecorder(t.TempDir(), trajectory.Manifest{Task: "Check the balance"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	screenshot := &definitions.Screenshot{Base64Data: base64.StdEncoding.EncodeToString(screen.PNG), Width: screen.Width, Height: screen.Height}
+	steps := []struct {
+		action helper.Action
+		result helper.ActionResult
+	}{
+		{helper.Action{"_metadata": "do", "action": "Tap", "element": []any{500.0, 500.0}}, helper.ActionResult{Success: true}},
+		{helper.Action{"_metadata": "do", "action": "Note", "message": "Balance 12.30"}, helper.ActionResult{Success: true, Message: "Note 1 recorded"}},
+		{helper.Action{"_metadata": "do", "action": "Call_API", "instruction": "Sum the notes"}, helper.ActionResult{Success: true, Message: "12.30"}},
+		{helper.Action{"_metadata": "finish", "message": "Balance checked"}, helper.ActionResult{Success: true, ShouldFinish: true, Message: "Balance checked"}},
+	}
+	for i, step := range steps {
+		if err := recorder.RecordStep(&trajectory.StepRecord{Step: i + 1, Action: step.action, Result: &step.result}, screenshot); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := recorder.Close(true, "Balance checked", nil); err != nil {
+		t.Fatal(err)
+	}
+	run, err := trajectory.Load(recorder.Dir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return run
+}
+
+func newTestReplayer(device phoneagent.Device) *phoneagent.Replayer {
+	return phoneagent.NewReplayer(device, &definitions.AgentConfig{DeviceID: devicetest.DefaultDeviceID, Lang: "en", Settle: noSettle})
+}
+
+func TestReplay(t *testing.T) {
+	white := devicetest.SolidScreen(1000, 2000, color.White, "Bank")
+	run := recordRun(t, white)
+	device := devicetest.NewFakeDevice(white)
+
+	result, err := newTestReplayer(device).Replay(context.Background(), run)
+	if err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	if !result.Finished || result.Diverged || len(result.Steps) != 4 {
+		t.Fatalf("unexpected replay result %+v", result)
+	}
+	taps := device.CallsTo("Tap")
+	if len(taps) != 1 || taps[0].Args[0] != 500 || taps[0].Args[1] != 1000 {
+		t.Errorf("expected the recorded tap at (500, 1000), got %+v", taps)
+	}
+	// Notes and API calls only involve the model, their recorded results are kept
+	if result.Steps[1].Result.Message != "Note 1 recorded" || result.Steps[2].Result.Message != "12.30" {
+		t.Errorf("unexpected replayed results %+v, %+v", result.Steps[1].Result, result.Steps[2].Result)
+	}
+}
+
+func TestReplayDivergence(t *testing.T) {
+	run := recordRun(t, devicetest.SolidScreen(1000, 2000, color.White, "Bank"))
+	black := devicetest.SolidScreen(1000, 2000, color.Black, "Bank")
+
+	// The replay stops before acting on a screen that does not match the recording
+	device := devicetest.NewFakeDevice(black)
+	result, err := newTestReplayer(device).Replay(context.Background(), run)
+	if err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	if !result.Diverged || result.Finished || len(result.Steps) != 1 || !result.Steps[0].Diverged || result.Steps[0].Difference < 0.9 {
+		t.Fatalf("expected the replay to stop at the first step, got %+v", result)
+	}
+	if taps := device.CallsTo("Tap"); len(taps) != 0 {
+		t.Errorf("diverging step was executed: %+v", taps)
+	}
+
+	// Without StopOnDivergence every step runs and is reported
+	device = devicetest.NewFakeDevice(black)
+	replayer := newTestReplayer(device)
+	replayer.StopOnDivergence = false
+	result, err = replayer.Replay(context.Background(), run)
+	if err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	if !result.Diverged || !result.Finished || len(result.Steps) != 4 || len(device.CallsTo("Tap")) != 1 {
+		t.Errorf("expected the whole run to be replayed, got %+v", result)
+	}
+
+	// A higher Threshold tolerates the difference
+	replayer = newTestReplayer(devicetest.NewFakeDevice(black))
+	replayer.Threshold = 1
+	if result, err = replayer.Replay(context.Background(), run); err != nil || result.Diverged || !result.Finished {
+		t.Errorf("expected no divergence under the threshold, got %+v, %v", result, err)
+	}
+}
+
+func TestReplayRedactedRecording(t *testing.T) {
+	// A checkerboard whose squares the redaction blurs into gray
+	img := image.NewRGBA(image.Rect(0, 0, 1000, 1000))
+	for y := 0; y < 1000; y++ {
+		for x := 0; x < 1000; x++ {
+			if (x/50+y/50)%2 == 0 {
+				img.Set(x, y, color.Black)
+			} else {
+				img.Set(x, y, color.White)
+			}
+		}
+	}
+	screen := devicetest.ImageScreen(img, "Bank")
+	server := llmtest.NewServer(
+		llmtest.ToolCall("Tap the center.", "tap", map[string]any{"element": []int{500, 500}}),
+		llmtest.Finish("done"),
+	)
+	defer server.Close()
+	dir := t.TempDir()
+	agentConfig := &definitions.AgentConfig{MaxSteps: 10, Lang: "en", Settle: noSettle, TrajectoryDir: dir}
+	modelConfig := &definitions.ModelConfig{BaseURL: server.BaseURL(), ModelName: "autoglm-phone"}
+	agent := phoneagent.NewPhoneAgent(devicetest.NewFakeDevice(screen), modelConfig, agentConfig,
+		phoneagent.WithRedactor(redact.Regions{Regions: []redact.Region{{X2: 1000, Y2: 500}}, BlockSize: 200}))
+	if _, err := agent.Run(context.Background(), "Tap the center"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	runs, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(runs) != 1 {
+		t.Fatalf("expected one recorded run, got %v", runs)
+	}
+	run, err := trajectory.Load(runs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !run.Steps[0].Redacted {
+		t.Fatalf("expected the recorded step to be marked redacted: %+v", run.Steps[0])
+	}
+
+	// The unredacted screen matches, the redacted recording is not compared against it
+	device := devicetest.NewFakeDevice(screen)
+	result, err := newTestReplayer(device).Replay(context.Background(), run)
+	if err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	if result.Diverged || !result.Finished || len(device.CallsTo("Tap")) != 1 {
+		t.Errorf("unexpected replay of a redacted recording %+v", result)
+	}
+}
@@ -0,0 +1,66 @@
+package phoneagent
+
+import (
+	"context"
+
+	"github.com/spance/autoglm-go/phoneagent/definitions"
+)
+
+// sensitiveScreenNote replaces the screenshot in the prompt when it is not sent.
+const sensitiveScreenNote = "** Sensitive Screen **\n\nThe current screen is protected (e.g. a payment or password page), so no screenshot is available. Decide from the context, ask the user to take over, or wait."
+
+// Redactor masks parts of a screenshot before it is recorded or sent to the model.
+// See package redact for region and text pattern implementations.
+type Redactor interface {
+	Redact(ctx context.Context, screenshot *definitions.Screenshot) (*definitions.Screenshot, error)
+}
+
+// RedactorFunc adapts a function to Redactor.
+type RedactorFunc func(ctx context.Context, screenshot *definitions.Screenshot) (*definitions.Screenshot, error)
+
+func (f RedactorFunc) Redact(ctx context.Context, screenshot *definitions.Screenshot) (*definitions.Screenshot, error) {
+	return f(ctx, screenshot)
+}
+
+// WithRedactor adds a redaction stage; stages run in the order they are added.
+func WithRedactor(redactor Redactor) Option {
+	return func(r *PhoneAgent) {
+		r.redactors = append(r.redactors, redactor)
+	}
+}
+
+// handleSensitiveScreen applies AgentConfig.SensitivePolicy. With SensitiveTakeover it
+// hands the device to the user and captures the screen again afterwards.
+func (r *PhoneAgent) handleSensitiveScreen(ctx context.Context, screenshot *definitions.Screenshot) (*definitions.Screenshot, error) {
+	if !screenshot.IsSensitive || r.AgentConfig.SensitivePolicy != definitions.SensitiveTakeover {
+		return screenshot, nil
+	}
+	if r.TakeoverHandler == nil {
+		r.logger.Warn().Int("step", r.StepCount).Msg("Sensitive screen but no takeover handler configured")
+		return screenshot, nil
+	}
+
+	r.logger.Info().Int("step", r.StepCount).Msg("Sensitive screen, waiting for user takeover")
+	hctx, cancel := r.handlerContext(ctx)
+	err := r.TakeoverHandler.Takeover(hctx, "The screen is protected, please complete this step manually")
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+	return r.captureScreenshot(ctx)
+}
+
+// redact runs the redaction stages and reports whether the result may be sent to
+// the model. A failing stage must not leak the unredacted screen, so the image is
+// then withheld whatever the policy.
+func (r *PhoneAgent) redact(ctx context.Context, screenshot *definitions.Screenshot) (*definitions.Screenshot, bool) {
+	for _, redactor := range r.redactors {
+		redacted, err := redactor.Redact(ctx, screenshot)
+		if err != nil {
+			r.logger.Error().Int("step", r.StepCount).Err(err).Msg("Failed to redact screenshot, withholding it")
+			return screenshot, false
+		}
+		screenshot = redacted
+	}
+	return screenshot, !screenshot.IsSensitive || r.AgentConfig.SensitivePolicy == definitions.SensitiveSend
+}
@@ -0,0 +1,155 @@
+package phoneagent
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/spance/autoglm-go/phoneagent/helper"
+)
+
+const sessionVersion = 1
+
+// ErrSessionMismatch is returned by RestoreSession when the session was saved by an
+// agent with a different model or prompt configuration.
+var ErrSessionMismatch = errors.New("session was saved with a different agent configuration")
+
+// ErrSessionDeviceMismatch is returned by RestoreSession when the session was saved
+// on another device than the one the agent drives.
+var ErrSessionDeviceMismatch = errors.New("session was saved on a different device")
+
+// Session is the persisted state of an interrupted task. Once the task finishes, the
+// session is saved one last time with Finished and Result set.
+type Session struct {
+	Version     int                            `json:"version"`
+	Task        string                         `json:"task"`
+	StepCount   int                            `json:"step_count"`
+	Finished    bool                           `json:"finished,omitempty"`
+	Result      string                         `json:"result,omitempty"` // set once finished
+	DeviceID    string                         `json:"device_id,omitempty"`
+	Fingerprint string                         `json:"fingerprint"`
+	Notes       []string                       `json:"notes,omitempty"`
+	Messages    []openai.ChatCompletionMessage `json:"messages"` // images stripped
+	SavedAt     time.Time                      `json:"saved_at"`
+}
+
+// Session snapshots the agent state. Screenshots are stripped from the messages.
+func (r *PhoneAgent) Session() *Session {
+	messages := make([]openai.ChatCompletionMessage, 0, len(r.State))
+	for _, msg := range r.State {
+		helper.RemoveImagesFromMessage(&msg)
+		messages = append(messages, msg)
+	}
+	return &Session{
+		Version:     sessionVersion,
+		Task:        r.Task,
+		StepCount:   r.StepCount,
+		Finished:    r.finished,
+		Result:      r.result,
+		DeviceID:    r.AgentConfig.DeviceID,
+		Fingerprint: r.ConfigFingerprint(),
+		Notes:       append([]string(nil), r.Notes...),
+		Messages:    messages,
+		SavedAt:     time.Now(),
+	}
+}
+
+// SaveSession writes the current session to path atomically.
+func (r *PhoneAgent) SaveSession(path string) error {
+	data, err := json.MarshalIndent(r.Session(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal session: %w", err)
+	}
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("failed to create session dir: %w", err)
+		}
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write session: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// LoadSession reads a session saved by SaveSession.
+func LoadSession(path string) (*Session, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session: %w", err)
+	}
+	var s Session
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse session: %w", err)
+	}
+	if s.Version != sessionVersion {
+		return nil, fmt.Errorf("unsupported session version %d", s.Version)
+	}
+	return &s, nil
+}
+
+// RestoreSession replaces the agent state with s. It fails with ErrSessionMismatch if
+// s was saved with another configuration; clear s.Fingerprint to restore it anyway.
+// It fails with ErrSessionDeviceMismatch if s was saved on another device, unless
+// either device ID is empty.
+func (r *PhoneAgent) RestoreSession(s *Session) error {
+	if s.Fingerprint != "" && s.Fingerprint != r.ConfigFingerprint() {
+		return ErrSessionMismatch
+	}
+	if s.DeviceID != "" && r.AgentConfig.DeviceID != "" && s.DeviceID != r.AgentConfig.DeviceID {
+		return fmt.Errorf("%w: saved on %s, agent uses %s", ErrSessionDeviceMismatch, s.DeviceID, r.AgentConfig.DeviceID)
+	}
+	r.State = append([]openai.ChatCompletionMessage(nil), s.Messages...)
+	r.StepCount = s.StepCount
+	r.Task = s.Task
+	r.finished, r.result = s.Finished, s.Result
+	r.Notes = append([]string(nil), s.Notes...)
+	return nil
+}
+
+// Resume continues a restored session from the next step, starting with a fresh screenshot.
+// A session whose task already finished is not run again, its result is returned.
+func (r *PhoneAgent) Resume(ctx context.Context) (string, error) {
+	if len(r.State) == 0 {
+		return "", fmt.Errorf("no session to resume")
+	}
+	if r.finished {
+		r.logger.Info().Int("step", r.StepCount).Str("task", r.Task).Msg("session already finished, not resuming")
+		return r.result, nil
+	}
+	r.logger.Info().Int("step", r.StepCount).Str("task", r.Task).Msg("resuming session")
+	r.startTrajectory(r.Task)
+	ctx, cancel := r.taskContext(ctx)
+	defer cancel()
+	result, finished, err := r.continueRun(ctx)
+	r.closeTrajectory(finished, result, err)
+	return result, err
+}
+
+// ConfigFingerprint identifies the parts of the configuration a session depends on:
+// the model and the system prompt.
+func (r *PhoneAgent) ConfigFingerprint() string {
+	h := sha256.New()
+	if r.ModelConfig != nil {
+		fmt.Fprintf(h, "model=%s\nbase_url=%s\n", r.ModelConfig.ModelName, r.ModelConfig.BaseURL)
+	}
+	fmt.Fprintf(h, "lang=%s\nprompt=%s\n", r.AgentConfig.Lang, r.AgentConfig.PromptPath)
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// autoSaveSession saves the session after each step when AgentConfig.SessionPath is set.
+func (r *PhoneAgent) autoSaveSession() {
+	if r.AgentConfig.SessionPath == "" {
+		return
+	}
+	if err := r.SaveSession(r.AgentConfig.SessionPath); err != nil {
+		r.logger.Warn().Int("step", r.StepCount).Err(err).Msg("failed to save session")
+	}
+}
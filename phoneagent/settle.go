@@ -0,0 +1,91 @@
+package phoneagent
+
+import (
+	"context"
+	"image"
+	"time"
+
+	"github.com/spance/autoglm-go/phoneagent/definitions"
+	"github.com/spance/autoglm-go/phoneagent/imageutil"
+	"github.com/spance/autoglm-go/utils"
+)
+
+// settleActions are the actions that may change the screen; after them the agent
+// waits for the UI to settle before the next screenshot.
+var settleActions = map[string]bool{
+	"Launch":      true,
+	"Tap":         true,
+	"Tap_Element": true,
+	"Type":        true,
+	"Type_Name":   true,
+	"Swipe":       true,
+	"Back":        true,
+	"Home":        true,
+	"Double Tap":  true,
+	"Long Press":  true,
+}
+
+// settle waits after an action following its SettlePolicy: at least Min, then until
+// two successive screenshots look the same, at most Max. The last screenshot is kept
+// and used as the screenshot of the next step. Only a canceled ctx is an error; a
+// failed capture just ends the wait.
+func (r *PhoneAgent) settle(ctx context.Context, actionName string) error {
+	policy := r.AgentConfig.GetSettlePolicy(actionName)
+	start := time.Now()
+	if err := utils.Sleep(ctx, policy.Min); err != nil {
+		return err
+	}
+	if policy.Max <= policy.Min {
+		return nil
+	}
+	interval := policy.Interval
+	if interval <= 0 {
+		interval = definitions.DefaultSettleInterval
+	}
+	threshold := policy.Threshold
+	if threshold <= 0 {
+		threshold = definitions.DefaultSettleThreshold
+	}
+
+	previous, previousImage := r.settleCapture(ctx)
+	if previous == nil {
+		return ctx.Err()
+	}
+	for {
+		remaining := policy.Max - time.Since(start)
+		if remaining <= 0 {
+			r.logger.Debug().Int("step", r.StepCount).Str("action", actionName).Dur("max", policy.Max).Msg("Screen still changing, not waiting longer")
+			break
+		}
+		if err := utils.Sleep(ctx, min(interval, remaining)); err != nil {
+			return err
+		}
+		screenshot, img := r.settleCapture(ctx)
+		if screenshot == nil {
+			return ctx.Err()
+		}
+		difference := imageutil.Difference(previousImage, img)
+		previous, previousImage = screenshot, img
+		if difference <= threshold {
+			r.logger.Debug().Int("step", r.StepCount).Str("action", actionName).Dur("elapsed", time.Since(start)).Msg("Screen settled")
+			break
+		}
+	}
+	r.settled = previous
+	return nil
+}
+
+// settleCapture takes a screenshot for settle, nil when it cannot be compared.
+func (r *PhoneAgent) settleCapture(ctx context.Context) (*definitions.Screenshot, image.Image) {
+	screenshot, err := r.Device.GetScreenshot(ctx, r.AgentConfig.DeviceID)
+	if err != nil || screenshot.Fallback {
+		r.logger.Debug().Int("step", r.StepCount).Err(err).Msg("Screenshot failed while waiting for the screen to settle")
+		return nil, nil
+	}
+	img, err := imageutil.Decode(screenshot)
+	if err != nil {
+		r.logger.Debug().Int("step", r.StepCount).Err(err).Msg("Screenshot failed while waiting for the screen to settle")
+		return nil, nil
+	}
+	return screenshot, img
+}
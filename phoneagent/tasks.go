@@ -0,0 +1,126 @@
+package phoneagent
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// LoadTasks reads a task file: JSON Lines (.jsonl, one Task object per line) or
+// YAML (.yaml, .yml), a list of mappings with the keys of the Task JSON fields
+// (id, task, device, max_steps, lang, expect), optionally under a top-level "tasks"
+// key; a plain string item is just the task.
+func LoadTasks(path string) ([]Task, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read task file: %w", err)
+	}
+
+	var tasks []Task
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".jsonl", ".ndjson":
+		tasks, err = ParseTasksJSONL(data)
+	case ".yaml", ".yml":
+		tasks, err = ParseTasksYAML(data)
+	default:
+		return nil, fmt.Errorf("unsupported task file extension %q, use .jsonl or .yaml", ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(tasks) == 0 {
+		return nil, fmt.Errorf("%s: no tasks", path)
+	}
+	return tasks, nil
+}
+
+// ParseTasksJSONL parses one JSON Task per line; blank lines and lines starting
+// with # are skipped.
+func ParseTasksJSONL(data []byte) ([]Task, error) {
+	var tasks []Task
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var task Task
+		decoder := json.NewDecoder(strings.NewReader(line))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&task); err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		if err := task.Validate(); err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, scanner.Err()
+}
+
+// ParseTasksYAML parses a YAML list of tasks, optionally under a top-level "tasks" key.
+func ParseTasksYAML(data []byte) ([]Task, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	if len(root.Content) == 0 {
+		return nil, nil
+	}
+	list := root.Content[0]
+	if list.Kind == yaml.MappingNode && len(list.Content) == 2 && list.Content[0].Value == "tasks" {
+		list = list.Content[1]
+	}
+	if list.Kind != yaml.SequenceNode {
+		return nil, fmt.Errorf("line %d: expected a list of tasks", list.Line)
+	}
+
+	tasks := make([]Task, 0, len(list.Content))
+	for _, item := range list.Content {
+		var task Task
+		switch item.Kind {
+		case yaml.ScalarNode:
+			// A plain item is the task itself
+			task.Prompt = item.Value
+		case yaml.MappingNode:
+			// Decode the item on its own to reject unknown keys
+			out, err := yaml.Marshal(item)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", item.Line, err)
+			}
+			decoder := yaml.NewDecoder(bytes.NewReader(out))
+			decoder.KnownFields(true)
+			if err := decoder.Decode(&task); err != nil {
+				return nil, fmt.Errorf("line %d: %w", item.Line, err)
+			}
+		default:
+			return nil, fmt.Errorf("line %d: expected a task or a mapping", item.Line)
+		}
+		if err := task.Validate(); err != nil {
+			return nil, fmt.Errorf("line %d: %w", item.Line, err)
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, nil
+}
+
+// Validate checks that the task has a prompt and valid overrides.
+func (task Task) Validate() error {
+	if strings.TrimSpace(task.Prompt) == "" {
+		return fmt.Errorf("task is empty")
+	}
+	if task.Lang != "" && task.Lang != "cn" && task.Lang != "en" {
+		return fmt.Errorf("invalid lang %q, must be cn or en", task.Lang)
+	}
+	if task.MaxSteps < 0 {
+		return fmt.Errorf("invalid max_steps %d", task.MaxSteps)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package phoneagent_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spance/autoglm-go/phoneagent"
+)
+
+func TestParseTasksYAML(t *testing.T) {
+	data := `# nightly suite
+tasks:
+  - id: dark-mode
+    task: Open Settings and enable dark mode
+    device: emulator-5554
+    max_steps: 20   # enough for settings
+    lang: en
+    expect: "Dark mode is \"on\""
+  - Search for coffee nearby
+  - task: |
+      Open WeChat
+      and check messages
+    expect: 'No unread messages'
+  -   task: "Open Settings: Display"
+      max_steps: 8
+  - {task: Open the camera, lang: cn}
+`
+	tasks, err := phoneagent.ParseTasksYAML([]byte(data))
+	if err != nil {
+		t.Fatalf("ParseTasksYAML failed: %v", err)
+	}
+	want := []phoneagent.Task{
+		{ID: "dark-mode", Prompt: "Open Settings and enable dark mode", DeviceID: "emulator-5554", MaxSteps: 20, Lang: "en", Expect: `Dark mode is "on"`},
+		{Prompt: "Search for coffee nearby"},
+		{Prompt: "Open WeChat\nand check messages\n", Expect: "No unread messages"},
+		{Prompt: "Open Settings: Display", MaxSteps: 8},
+		{Prompt: "Open the camera", Lang: "cn"},
+	}
+	if !reflect.DeepEqual(tasks, want) {
+		t.Errorf("got %+v\nwant %+v", tasks, want)
+	}
+}
+
+func TestParseTasksYAMLErrors(t *testing.T) {
+	tests := map[string]string{
+		"unknown key":   "- task: a\n  retries: 3\n",
+		"missing task":  "- id: a\n",
+		"bad max_steps": "- task: a\n  max_steps: many\n",
+		"bad lang":      "- task: a\n  lang: fr\n",
+		"stray key":     "task: a\n",
+		"nested list":   "- [a, b]\n",
+		"invalid yaml":  "- task: [a\n",
+	}
+	for name, data := range tests {
+		if _, err := phoneagent.ParseTasksYAML([]byte(data)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestLoadTasksJSONL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tasks.jsonl")
+	data := `{"id": "a", "task": "Open Settings", "max_steps": 5}
+
+# comment
+{"task": "Search", "device": "phone-2", "expect": "results shown"}
+`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tasks, err := phoneagent.LoadTasks(path)
+	if err != nil {
+		t.Fatalf("LoadTasks failed: %v", err)
+	}
+	if len(tasks) != 2 || tasks[0].MaxSteps != 5 || tasks[1].DeviceID != "phone-2" || tasks[1].Expect != "results shown" {
+		t.Errorf("unexpected tasks %+v", tasks)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"task": "a", "unknown": 1}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := phoneagent.LoadTasks(path); err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Errorf("expected an error on line 1, got %v", err)
+	}
+}
@@ -0,0 +1,139 @@
+package phoneagent
+
+import (
+	"context"
+	"time"
+
+	"github.com/spance/autoglm-go/phoneagent/definitions"
+	"github.com/spance/autoglm-go/phoneagent/helper"
+	"github.com/spance/autoglm-go/phoneagent/llm"
+	"github.com/spance/autoglm-go/phoneagent/trajectory"
+)
+
+// trajectoryObserver collects the phases of the current step and hands them to a
+// trajectory.Recorder once the step is over.
+type trajectoryObserver struct {
+	NopObserver
+
+	agent    *PhoneAgent
+	recorder *trajectory.Recorder
+
+	pending    *trajectory.StepRecord
+	screenshot *definitions.Screenshot
+	stateStart int
+	phaseStart time.Time
+}
+
+func (t *trajectoryObserver) OnStepStart(ctx context.Context, step int, prompt string) {
+	t.flush()
+	t.pending = &trajectory.StepRecord{Step: step, Time: time.Now()}
+	t.stateStart = len(t.agent.State)
+	t.phaseStart = time.Now()
+}
+
+func (t *trajectoryObserver) OnScreenshot(ctx context.Context, step int, screenshot *definitions.Screenshot, currentApp string) {
+	if t.pending == nil {
+		return
+	}
+	t.pending.Timings.Screenshot = time.Since(t.phaseStart).Seconds()
+	t.pending.CurrentApp = currentApp
+	t.screenshot = screenshot
+}
+
+func (t *trajectoryObserver) OnModelResponse(ctx context.Context, step int, response *llm.ModelResponse) {
+	if t.pending == nil {
+		return
+	}
+	t.pending.Thinking = response.Thinking
+	t.pending.Timings.Model = response.TotalTime
+	t.pending.Timings.TimeToFirstToken = response.TimeToFirstToken
+	t.pending.Timings.TimeToThinkingEnd = response.TimeToThinkingEnd
+	t.phaseStart = time.Now()
+}
+
+func (t *trajectoryObserver) OnActionExecuted(ctx context.Context, step int, action helper.Action, result helper.ActionResult) {
+	if t.pending == nil {
+		return
+	}
+	t.pending.Timings.Action = time.Since(t.phaseStart).Seconds()
+	t.pending.Action = action
+	t.pending.Result = &result
+}
+
+func (t *trajectoryObserver) OnError(ctx context.Context, step int, err error) {
+	if t.pending == nil {
+		return
+	}
+	t.pending.Error = err.Error()
+}
+
+// flush writes the pending step, including the messages it added to the agent state.
+func (t *trajectoryObserver) flush() {
+	if t.pending == nil {
+		return
+	}
+	rec := t.pending
+	t.pending = nil
+
+	if t.stateStart < len(t.agent.State) {
+		for _, msg := range t.agent.State[t.stateStart:] {
+			helper.RemoveImagesFromMessage(&msg)
+			rec.Messages = append(rec.Messages, msg)
+		}
+	}
+	if err := t.recorder.RecordStep(rec, t.screenshot); err != nil {
+		t.agent.logger.Warn().Int("step", rec.Step).Err(err).Msg("failed to record trajectory step")
+	}
+	t.screenshot = nil
+}
+
+// startTrajectory opens a new run directory when AgentConfig.TrajectoryDir is set.
+func (r *PhoneAgent) startTrajectory(task string) {
+	r.closeTrajectory(false, "", nil)
+	if r.AgentConfig.TrajectoryDir == "" {
+		return
+	}
+
+	manifest := trajectory.Manifest{
+		Task:     task,
+		DeviceID: r.AgentConfig.DeviceID,
+		Lang:     r.AgentConfig.Lang,
+	}
+	if r.ModelConfig != nil {
+		manifest.Model = r.ModelConfig.ModelName
+	}
+	recorder, err := trajectory.NewRecorder(r.AgentConfig.TrajectoryDir, manifest)
+	if err != nil {
+		r.logger.Warn().Err(err).Msg("failed to start trajectory recording")
+		return
+	}
+	r.logger.Info().Str("dir", recorder.Dir()).Msg("recording trajectory")
+	r.trajectory = &trajectoryObserver{agent: r, recorder: recorder}
+}
+
+// endTrajectoryStep flushes the step that has just been executed.
+func (r *PhoneAgent) endTrajectoryStep() {
+	if r.trajectory != nil {
+		r.trajectory.flush()
+	}
+}
+
+// closeTrajectory finalizes the current recording, if any.
+func (r *PhoneAgent) closeTrajectory(finished bool, result string, err error) {
+	if r.trajectory == nil {
+		return
+	}
+	r.trajectory.flush()
+	if cerr := r.trajectory.recorder.Close(finished, result, err); cerr != nil {
+		r.logger.Warn().Err(cerr).Msg("failed to finalize trajectory")
+	}
+	r.trajectory = nil
+}
+
+// TrajectoryDir returns the directory of the run being recorded, or "" when not recording.
+func (r *PhoneAgent) TrajectoryDir() string {
+	if r.trajectory == nil {
+		return ""
+	}
+	return r.trajectory.recorder.Dir()
+}
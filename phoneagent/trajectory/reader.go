@@ -0,0 +1,73 @@
+package trajectory
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spance/autoglm-go/phoneagent/definitions"
+	"github.com/spance/autoglm-go/phoneagent/helper"
+)
+
+// Run is a recorded run loaded back from disk.
+type Run struct {
+	Dir      string
+	Manifest Manifest
+	Steps    []StepRecord
+}
+
+// Load reads the run directory written by a Recorder.
+func Load(dir string) (*Run, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	run := &Run{Dir: dir}
+	if err := json.Unmarshal(data, &run.Manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+
+	f, err := os.Open(filepath.Join(dir, StepsFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open steps file: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var rec StepRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("failed to parse %s line %d: %w", StepsFile, line, err)
+		}
+		if rec.Action != nil {
+			helper.NormalizeCoordinates(rec.Action)
+		}
+		run.Steps = append(run.Steps, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read steps file: %w", err)
+	}
+	return run, nil
+}
+
+// Screenshot loads the screenshot recorded for a step, or nil if none was saved.
+func (r *Run) Screenshot(rec *StepRecord) (*definitions.Screenshot, error) {
+	if rec.Screenshot == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(filepath.Join(r.Dir, rec.Screenshot))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read screenshot: %w", err)
+	}
+	return &definitions.Screenshot{
+		BinaryData: data,
+		Width:      rec.Width,
+		Height:     rec.Height,
+	}, nil
+}
@@ -0,0 +1,190 @@
+// Package trajectory persists agent runs to disk: one directory per run containing a
+// manifest, one screenshot per step and a JSONL log of messages, actions and timings.
+package trajectory
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sashabaranov/go-openai"
+	"github.com/spance/autoglm-go/phoneagent/definitions"
+	"github.com/spance/autoglm-go/phoneagent/helper"
+)
+
+const (
+	ManifestFile = "manifest.json"
+	StepsFile    = "steps.jsonl"
+)
+
+// Manifest describes a recorded run.
+type Manifest struct {
+	ID        string     `json:"id"`
+	Task      string     `json:"task"`
+	DeviceID  string     `json:"device_id,omitempty"`
+	Model     string     `json:"model,omitempty"`
+	Lang      string     `json:"lang,omitempty"`
+	StartedAt time.Time  `json:"started_at"`
+	EndedAt   *time.Time `json:"ended_at,omitempty"`
+	Steps     int        `json:"steps"`
+	Finished  bool       `json:"finished"`
+	Result    string     `json:"result,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// Timings holds the durations of a step's phases, in seconds.
+type Timings struct {
+	Screenshot        float64  `json:"screenshot"`
+	Model             float64  `json:"model"`
+	TimeToFirstToken  *float64 `json:"time_to_first_token,omitempty"`
+	TimeToThinkingEnd *float64 `json:"time_to_thinking_end,omitempty"`
+	Action            float64  `json:"action"`
+}
+
+// StepRecord is one line of steps.jsonl.
+type StepRecord struct {
+	Step       int                            `json:"step"`
+	Time       time.Time                      `json:"time"`
+	Screenshot string                         `json:"screenshot,omitempty"` // file name relative to the run directory
+	Width      int                            `json:"width,omitempty"`
+	Height     int                            `json:"height,omitempty"`
+	Redacted   bool                           `json:"redacted,omitempty"` // parts of the screenshot were pixelated
+	CurrentApp string                         `json:"current_app,omitempty"`
+	Messages   []openai.ChatCompletionMessage `json:"messages,omitempty"` // messages added during the step, images stripped
+	Thinking   string                         `json:"thinking,omitempty"`
+	Action     helper.Action                  `json:"action,omitempty"`
+	Result     *helper.ActionResult           `json:"result,omitempty"`
+	Timings    Timings                        `json:"timings"`
+	Error      string                         `json:"error,omitempty"`
+}
+
+// Recorder writes a run directory. It is safe for concurrent use.
+type Recorder struct {
+	mu       sync.Mutex
+	dir      string
+	manifest Manifest
+	steps    *os.File
+}
+
+// NewRecorder creates a new run directory under baseDir and writes the initial manifest.
+func NewRecorder(baseDir string, manifest Manifest) (*Recorder, error) {
+	if manifest.ID == "" {
+		manifest.ID = uuid.New().String()
+	}
+	if manifest.StartedAt.IsZero() {
+		manifest.StartedAt = time.Now()
+	}
+
+	dir := filepath.Join(baseDir, fmt.Sprintf("%s_%s", manifest.StartedAt.Format("20060102-150405"), manifest.ID[:8]))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create trajectory dir: %w", err)
+	}
+	steps, err := os.OpenFile(filepath.Join(dir, StepsFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create steps file: %w", err)
+	}
+
+	r := &Recorder{dir: dir, manifest: manifest, steps: steps}
+	if err := r.writeManifest(); err != nil {
+		_ = steps.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// Dir returns the run directory.
+func (r *Recorder) Dir() string {
+	return r.dir
+}
+
+// RecordStep saves the step screenshot (if any) and appends rec to steps.jsonl.
+// The manifest is rewritten after every step so an interrupted run stays readable.
+func (r *Recorder) RecordStep(rec *StepRecord, screenshot *definitions.Screenshot) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if screenshot != nil {
+		name := fmt.Sprintf("step_%03d.png", rec.Step)
+		written, err := writeScreenshot(filepath.Join(r.dir, name), screenshot)
+		if err != nil {
+			return err
+		}
+		if written {
+			rec.Screenshot = name
+		}
+		rec.Width = screenshot.Width
+		rec.Height = screenshot.Height
+		rec.Redacted = len(screenshot.Redacted) > 0
+	}
+
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("failed to marshal step record: %w", err)
+	}
+	if _, err := r.steps.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write step record: %w", err)
+	}
+
+	r.manifest.Steps = max(r.manifest.Steps, rec.Step)
+	return r.writeManifest()
+}
+
+// Close finalizes the manifest with the run outcome and closes the steps file.
+func (r *Recorder) Close(finished bool, result string, runErr error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.steps == nil {
+		return nil
+	}
+	now := time.Now()
+	r.manifest.EndedAt = &now
+	r.manifest.Finished = finished
+	r.manifest.Result = result
+	if runErr != nil {
+		r.manifest.Error = runErr.Error()
+	}
+
+	err := r.writeManifest()
+	if cerr := r.steps.Close(); err == nil {
+		err = cerr
+	}
+	r.steps = nil
+	return err
+}
+
+func (r *Recorder) writeManifest() error {
+	data, err := json.MarshalIndent(r.manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+	// Write to a temp file first so a crash never leaves a truncated manifest behind
+	tmp := filepath.Join(r.dir, ManifestFile+".tmp")
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return os.Rename(tmp, filepath.Join(r.dir, ManifestFile))
+}
+
+func writeScreenshot(path string, screenshot *definitions.Screenshot) (bool, error) {
+	data := screenshot.BinaryData
+	if len(data) == 0 && screenshot.Base64Data != "" {
+		decoded, err := base64.StdEncoding.DecodeString(screenshot.Base64Data)
+		if err != nil {
+			return false, fmt.Errorf("failed to decode screenshot: %w", err)
+		}
+		data = decoded
+	}
+	if len(data) == 0 {
+		return false, nil
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return false, fmt.Errorf("failed to write screenshot: %w", err)
+	}
+	return true, nil
+}
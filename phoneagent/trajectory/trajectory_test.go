@@ -0,0 +1,63 @@
+package trajectory
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/spance/autoglm-go/phoneagent/definitions"
+	"github.com/spance/autoglm-go/phoneagent/helper"
+)
+
+func TestRecorder(t *testing.T) {
+	recorder, err := NewRecorder(t.TempDir(), Manifest{Task: "open settings", DeviceID: "emulator-5554"})
+	if err != nil {
+		t.Fatalf("NewRecorder failed: %v", err)
+	}
+
+	png := []byte("\x89PNG fake")
+	err = recorder.RecordStep(&StepRecord{
+		Step:     1,
+		Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "open settings"}},
+		Action:   helper.Action{"_metadata": "do", "action": "Launch", "app": "Settings"},
+		Result:   &helper.ActionResult{Success: true},
+	}, &definitions.Screenshot{BinaryData: png, Width: 1080, Height: 2400})
+	if err != nil {
+		t.Fatalf("RecordStep failed: %v", err)
+	}
+	if err := recorder.Close(false, "", errors.New("boom")); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(recorder.Dir(), "step_001.png"))
+	if err != nil || string(data) != string(png) {
+		t.Errorf("unexpected screenshot file: %q, %v", data, err)
+	}
+
+	var manifest Manifest
+	data, _ = os.ReadFile(filepath.Join(recorder.Dir(), ManifestFile))
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		t.Fatalf("invalid manifest: %v", err)
+	}
+	if manifest.Steps != 1 || manifest.Task != "open settings" || manifest.Error != "boom" || manifest.EndedAt == nil {
+		t.Errorf("unexpected manifest: %+v", manifest)
+	}
+
+	f, _ := os.Open(filepath.Join(recorder.Dir(), StepsFile))
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		t.Fatal("steps file is empty")
+	}
+	var rec StepRecord
+	if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+		t.Fatalf("invalid step record: %v", err)
+	}
+	if rec.Screenshot != "step_001.png" || rec.Width != 1080 || rec.Action["app"] != "Settings" || !rec.Result.Success {
+		t.Errorf("unexpected step record: %+v", rec)
+	}
+}
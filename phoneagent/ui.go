@@ -0,0 +1,123 @@
+package phoneagent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/spance/autoglm-go/phoneagent/definitions"
+	"github.com/spance/autoglm-go/phoneagent/helper"
+	"github.com/spance/autoglm-go/utils"
+)
+
+// tapSnapDistance is how far a tap may be from a clickable element and still be
+// snapped to it, in 0-1000 units of the screen width.
+const tapSnapDistance = 30
+
+// loadUIHierarchy reads the view tree of the current screen when the configuration
+// needs it. Failures only lose the grounding, so they are logged and the step goes
+// on with the screenshot alone.
+func (r *PhoneAgent) loadUIHierarchy(ctx context.Context) {
+	r.lastUI = nil
+	if !r.AgentConfig.UIElements && !r.AgentConfig.SnapTaps {
+		return
+	}
+	if _, err := r.uiHierarchy(ctx); err != nil {
+		r.logger.Warn().Int("step", r.StepCount).Err(err).Msg("Failed to get UI hierarchy, continuing without it")
+	}
+}
+
+// uiHierarchy returns the view tree of the current step, reading it from the
+// device if it has not been loaded yet.
+func (r *PhoneAgent) uiHierarchy(ctx context.Context) (*definitions.UINode, error) {
+	if r.lastUI != nil {
+		return r.lastUI, nil
+	}
+	provider, ok := r.Device.(UIHierarchyProvider)
+	if !ok {
+		return nil, fmt.Errorf("device does not support reading the UI hierarchy")
+	}
+	root, err := provider.GetUIHierarchy(ctx, r.AgentConfig.DeviceID)
+	if err != nil {
+		return nil, err
+	}
+	r.lastUI = root
+	return root, nil
+}
+
+// snapTap moves a tap onto the clickable element it was aimed at: the center of
+// the innermost clickable element containing it, or of the closest one nearby.
+func (r *PhoneAgent) snapTap(x, y, screenWidth int) (int, int) {
+	if !r.AgentConfig.SnapTaps || r.lastUI == nil {
+		return x, y
+	}
+	node := r.lastUI.ClickableAt(x, y, float64(screenWidth*tapSnapDistance/1000))
+	if node == nil {
+		return x, y
+	}
+	sx, sy := node.Bounds.Center()
+	if sx != x || sy != y {
+		r.logger.Debug().Int("step", r.StepCount).Str("element", node.Label()).
+			Msgf("Snapped tap (%d, %d) to (%d, %d)", x, y, sx, sy)
+	}
+	return sx, sy
+}
+
+// handleTapElement taps the element the model identified by text, content
+// description or resource ID, resolved against the UI hierarchy.
+func (r *PhoneAgent) handleTapElement(ctx context.Context, action helper.Action, screenWidth, screenHeight int) (helper.ActionResult, error) {
+	selector := definitions.UISelector{
+		Text:        strings.TrimSpace(utils.AnyToString(action["text"])),
+		ContentDesc: strings.TrimSpace(utils.AnyToString(action["content_desc"])),
+		ResourceID:  strings.TrimSpace(utils.AnyToString(action["resource_id"])),
+	}
+	if selector.IsZero() {
+		return helper.ActionResult{
+			Success:      false,
+			ShouldFinish: false,
+			Message:      "No text, content_desc or resource_id specified",
+		}, nil
+	}
+
+	root, err := r.uiHierarchy(ctx)
+	if err != nil {
+		r.logger.Warn().Int("step", r.StepCount).Err(err).Msg("Failed to get UI hierarchy for tap_element")
+		return helper.ActionResult{
+			Success:      false,
+			ShouldFinish: false,
+			Message:      fmt.Sprintf("UI hierarchy is not available (%v), use tap with coordinates instead", err),
+		}, nil
+	}
+
+	matches := root.Find(selector)
+	index := utils.AnyToInt(action["index"])
+	if len(matches) == 0 {
+		return helper.ActionResult{
+			Success:      false,
+			ShouldFinish: false,
+			Message:      fmt.Sprintf("No element matches %s, use tap with coordinates instead", selector),
+		}, nil
+	}
+	if index < 0 || index >= len(matches) {
+		return helper.ActionResult{
+			Success:      false,
+			ShouldFinish: false,
+			Message:      fmt.Sprintf("Index %d is out of range, %d elements match %s", index, len(matches), selector),
+		}, nil
+	}
+
+	x, y := matches[index].Bounds.Center()
+	if msg, ok := action["message"]; ok {
+		if !r.confirm(ctx, utils.AnyToString(msg)) {
+			return helper.ActionResult{
+				Success:      false,
+				ShouldFinish: true,
+				Message:      "User cancelled sensitive operation",
+			}, nil
+		}
+	}
+	r.logger.Debug().Int("step", r.StepCount).Str("selector", selector.String()).Msgf("Tapping element at (%d, %d)", x, y)
+	_ = r.Device.Tap(ctx, x, y, r.AgentConfig.DeviceID)
+
+	return helper.ActionResult{Success: true, ShouldFinish: false}, nil
+}
@@ -0,0 +1,94 @@
+package phoneagent
+
+import (
+	"context"
+	"image"
+
+	"github.com/spance/autoglm-go/phoneagent/definitions"
+	"github.com/spance/autoglm-go/phoneagent/helper"
+	"github.com/spance/autoglm-go/phoneagent/imageutil"
+	"github.com/spance/autoglm-go/utils"
+)
+
+const (
+	// screenChangeDistance is the perceptual hash distance from which two screenshots
+	// are considered different; smaller distances are compression or clock noise.
+	screenChangeDistance = 2
+	// screenChangeCell is the brightness change (0-1) of a single thumbnail cell from
+	// which the screen is considered changed, for changes too small for the hash.
+	screenChangeCell = 0.1
+	// statusBarRatio is the top part of the screen left out of the cell comparison,
+	// the clock and icons of the status bar change by themselves.
+	statusBarRatio = 0.05
+)
+
+// verifyAction compares the screenshot and app from before an action with the
+// current ones, so that the model learns when a tap or swipe did nothing. The new
+// screenshot is reused for the next step. It returns nil when the action cannot
+// change the screen or the screenshots cannot be compared.
+func (r *PhoneAgent) verifyAction(ctx context.Context, action helper.Action, result helper.ActionResult,
+	before *definitions.Screenshot, beforeApp string) *helper.ActionVerification {
+	if !result.Success || result.ShouldFinish || utils.AnyToString(action["_metadata"]) != "do" ||
+		!settleActions[utils.AnyToString(action["action"])] {
+		return nil
+	}
+	if before == nil || before.IsSensitive || before.Fallback || ctx.Err() != nil {
+		return nil
+	}
+
+	// The screenshot that ended the settle wait is already the current screen
+	after := r.settled
+	if after == nil {
+		var err error
+		after, err = r.Device.GetScreenshot(ctx, r.AgentConfig.DeviceID)
+		if err != nil || after.Fallback {
+			r.logger.Debug().Int("step", r.StepCount).Err(err).Msg("Screenshot failed, action not verified")
+			return nil
+		}
+		r.settled = after
+	}
+	if after.IsSensitive {
+		return nil
+	}
+	beforeImage, err := imageutil.Decode(before)
+	if err != nil {
+		return nil
+	}
+	afterImage, err := imageutil.Decode(after)
+	if err != nil {
+		return nil
+	}
+
+	distance := imageutil.HashDistance(imageutil.Hash(beforeImage), imageutil.Hash(afterImage))
+	verification := &helper.ActionVerification{
+		ScreenChanged: distance >= screenChangeDistance ||
+			imageutil.MaxDifference(withoutStatusBar(beforeImage), withoutStatusBar(afterImage)) >= screenChangeCell,
+		HashDistance: distance,
+	}
+	if app, err := r.Device.GetCurrentApp(ctx, r.AgentConfig.DeviceID); err == nil && beforeApp != "" && app != beforeApp {
+		verification.AppChanged = true
+		verification.NewApp = app
+	}
+
+	event := r.logger.Debug()
+	if !verification.ScreenChanged && !verification.AppChanged {
+		event = r.logger.Info()
+	}
+	event.Int("step", r.StepCount).Str("action", utils.AnyToString(action["action"])).
+		Bool("screen_changed", verification.ScreenChanged).Bool("app_changed", verification.AppChanged).
+		Int("hash_distance", distance).Msg("Verified action")
+	return verification
+}
+
+// withoutStatusBar crops the status bar off img when the image type allows it.
+func withoutStatusBar(img image.Image) image.Image {
+	sub, ok := img.(interface {
+		SubImage(r image.Rectangle) image.Image
+	})
+	if !ok {
+		return img
+	}
+	bounds := img.Bounds()
+	bounds.Min.Y += int(float64(bounds.Dy()) * statusBarRatio)
+	return sub.SubImage(bounds)
+}
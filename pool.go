@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/samber/lo"
+	"github.com/spance/autoglm-go/phoneagent"
+	"github.com/spance/autoglm-go/phoneagent/definitions"
+)
+
+// deviceIDs parses --devices; "all" (or an empty list) means every ready device
+func deviceIDs(value string) []string {
+	var ids []string
+	for _, id := range strings.Split(value, ",") {
+		if id = strings.TrimSpace(id); id != "" && id != "all" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// pinTasks pins the tasks without a device to --device-id and returns them with the
+// devices they need. Without --device-id, the default device is the only ready one.
+func pinTasks(ctx context.Context, runner *phoneagent.Runner, tasks []phoneagent.Task) ([]phoneagent.Task, []string, error) {
+	var pinned []string
+	for _, task := range tasks {
+		if task.DeviceID != "" && task.DeviceID != config.DeviceID {
+			pinned = append(pinned, task.DeviceID)
+		}
+	}
+	if len(pinned) == 0 {
+		return tasks, []string{config.DeviceID}, nil
+	}
+
+	defaultID := config.DeviceID
+	if defaultID == "" {
+		ready, err := runner.Devices(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(ready) != 1 {
+			return nil, nil, fmt.Errorf("%d devices are ready, select the default one with --device-id or use --devices", len(ready))
+		}
+		defaultID = ready[0]
+	}
+	tasks = append([]phoneagent.Task(nil), tasks...)
+	for i := range tasks {
+		if tasks[i].DeviceID == "" {
+			tasks[i].DeviceID = defaultID
+		}
+	}
+	return tasks, lo.Uniq(append([]string{defaultID}, pinned...)), nil
+}
+
+// runOnDevices runs tasks on the devices selected by --devices, --parallelism at a time,
+// or one after the other on --device-id without --devices
+func runOnDevices(ctx context.Context, device phoneagent.Device, modelConfig *definitions.ModelConfig,
+	agentConfig *definitions.AgentConfig, opts []phoneagent.Option, tasks []phoneagent.Task) (*phoneagent.RunReport, error) {
+	runner := phoneagent.NewRunner(device, modelConfig, agentConfig)
+	runner.DeviceIDs = deviceIDs(config.Devices)
+	if config.Devices == "" {
+		// A task file without --devices runs its tasks one by one on the selected
+		// device, and the tasks pinned to other devices on those devices
+		var err error
+		if tasks, runner.DeviceIDs, err = pinTasks(ctx, runner, tasks); err != nil {
+			return nil, err
+		}
+	}
+	runner.Parallelism = config.Parallelism
+	if config.RunMode == string(phoneagent.RunShared) {
+		runner.Mode = phoneagent.RunShared
+	}
+	runner.Options = func(deviceID string) []phoneagent.Option { return opts }
+
+	ids, err := runner.Devices(ctx)
+	if err != nil {
+		return nil, err
+	}
+	runner.DeviceIDs = ids
+	log.Info().Strs("devices", ids).Int("tasks", len(tasks)).Int("parallelism", config.Parallelism).Msg("Running on devices")
+
+	report, err := runner.Run(ctx, tasks)
+	if err != nil {
+		return nil, err
+	}
+	for _, result := range report.Results {
+		event := log.Info()
+		status := "✅"
+		if !result.Passed() {
+			event, status = log.Error(), "❌"
+			if result.Err != nil {
+				event = event.Err(result.Err)
+			}
+		}
+		event.Str("device", result.DeviceID).Str("task", result.TaskID).Int("steps", result.Steps).
+			Dur("duration", result.Duration).Msgf("%s %s", status, result.Result)
+	}
+	log.Info().Int("passed", report.Passed()).Int("failed", report.Failed()).Dur("duration", report.Duration).Msg("Run finished")
+	return report, nil
+}
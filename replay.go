@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spance/autoglm-go/phoneagent"
+	"github.com/spance/autoglm-go/phoneagent/definitions"
+	"github.com/spance/autoglm-go/phoneagent/trajectory"
+	"github.com/spf13/cobra"
+)
+
+// ReplayConfig holds the options of the replay subcommand
+type ReplayConfig struct {
+	Dir               string  `json:"dir"`
+	Compare           bool    `json:"compare"`
+	Threshold         float64 `json:"threshold"`
+	ContinueOnDiverge bool    `json:"continue_on_diverge"`
+}
+
+var replayConfig = &ReplayConfig{}
+
+var replayCmd = &cobra.Command{
+	Use:   "replay <run-dir>",
+	Short: "Replay a recorded trajectory on a device without calling the model",
+	Example: `  # Record a run, then replay it
+  go run main.go --record-dir runs "Open Settings and enable dark mode"
+  go run main.go replay runs/20260101-120000_1a2b3c4d
+
+  # Replay without screenshot comparison
+  go run main.go replay --compare=false runs/20260101-120000_1a2b3c4d`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		config.Command = "replay"
+		replayConfig.Dir = args[0]
+	},
+}
+
+func init() {
+	replayCmd.Flags().BoolVar(&replayConfig.Compare, "compare", true,
+		"Compare each fresh screenshot with the recorded one")
+	replayCmd.Flags().Float64Var(&replayConfig.Threshold, "threshold", phoneagent.DefaultDivergenceThreshold,
+		"Maximum screen difference (0-1) before a step is considered diverged")
+	replayCmd.Flags().BoolVar(&replayConfig.ContinueOnDiverge, "continue-on-diverge", false,
+		"Keep replaying after a diverged step")
+
+	rootCmd.AddCommand(replayCmd)
+}
+
+// runReplay replays the recorded run in replayConfig.Dir on device
+func runReplay(ctx context.Context, device phoneagent.Device) error {
+	run, err := trajectory.Load(replayConfig.Dir)
+	if err != nil {
+		return err
+	}
+	log.Info().Str("task", run.Manifest.Task).Int("steps", len(run.Steps)).Msg("Replaying trajectory")
+
+	agentConfig := &definitions.AgentConfig{
+		MaxSteps: config.MaxSteps,
+		DeviceID: config.DeviceID,
+		Lang:     config.Lang,
+		WdaUrl:   config.WdaUrl,
+	}
+	replayer := phoneagent.NewReplayer(device, agentConfig)
+	replayer.Compare = replayConfig.Compare
+	replayer.Threshold = replayConfig.Threshold
+	replayer.StopOnDivergence = !replayConfig.ContinueOnDiverge
+
+	result, err := replayer.Replay(ctx, run)
+	if err != nil {
+		return err
+	}
+	for _, step := range result.Steps {
+		status := "✅"
+		if step.Diverged || !step.Result.Success {
+			status = "❌"
+		}
+		log.Info().Int("step", step.Step).Float64("difference", step.Difference).Msgf("%s %v", status, step.Action["action"])
+	}
+	if result.Diverged {
+		return fmt.Errorf("replay diverged from the recording")
+	}
+	log.Info().Bool("finished", result.Finished).Msg("🎉 Replay completed")
+	return nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spance/autoglm-go/phoneagent"
+)
+
+// JUnit XML report, as read by CI servers
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Time     float64          `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Errors    int             `xml:"errors,attr"`
+	Time      float64         `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	Cases     []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      float64       `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// reportFormat returns --report-format, or the format matching the --report extension
+func reportFormat() string {
+	if config.ReportFormat != "" {
+		return config.ReportFormat
+	}
+	if strings.EqualFold(filepath.Ext(config.Report), ".xml") {
+		return "junit"
+	}
+	return "json"
+}
+
+// writeReport writes the run report to --report
+func writeReport(report *phoneagent.RunReport) error {
+	var (
+		data []byte
+		err  error
+	)
+	switch reportFormat() {
+	case "junit":
+		data, err = junitReport(report)
+	default:
+		data, err = json.MarshalIndent(report, "", "  ")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to encode report: %w", err)
+	}
+	if err := os.WriteFile(config.Report, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return nil
+}
+
+// junitReport renders one test suite per device, one test case per task
+func junitReport(report *phoneagent.RunReport) ([]byte, error) {
+	suites := junitTestSuites{Time: report.Duration.Seconds()}
+	index := map[string]int{}
+	for _, result := range report.Results {
+		name := result.DeviceID
+		if name == "" {
+			name = "default"
+		}
+		i, ok := index[name]
+		if !ok {
+			i = len(suites.Suites)
+			index[name] = i
+			suites.Suites = append(suites.Suites, junitTestSuite{Name: name, Timestamp: report.Start.Format("2006-01-02T15:04:05")})
+		}
+		suite := &suites.Suites[i]
+
+		testCase := junitTestCase{
+			Name:      result.TaskID,
+			ClassName: name,
+			Time:      result.Duration.Seconds(),
+			SystemOut: result.Result,
+		}
+		switch {
+		case result.Err != nil:
+			testCase.Error = &junitMessage{Message: result.Error, Text: result.Error}
+			suite.Errors++
+		case !result.Finished:
+			message := fmt.Sprintf("task not finished after %d steps", result.Steps)
+			testCase.Failure = &junitMessage{Message: message, Text: result.Result}
+			suite.Failures++
+		case !result.Success:
+			testCase.Failure = &junitMessage{Message: "task failed: " + result.Result, Text: result.Result}
+			suite.Failures++
+		}
+		suite.Tests++
+		suite.Time += testCase.Time
+		suite.Cases = append(suite.Cases, testCase)
+
+		suites.Tests++
+	}
+	for _, suite := range suites.Suites {
+		suites.Failures += suite.Failures
+		suites.Errors += suite.Errors
+	}
+
+	data, err := xml.MarshalIndent(suites, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
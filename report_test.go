@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/spance/autoglm-go/phoneagent"
+)
+
+func TestJUnitReport(t *testing.T) {
+	report := &phoneagent.RunReport{
+		Start:    time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC),
+		Duration: 9 * time.Second,
+		Results: []phoneagent.TaskResult{
+			{TaskID: "open", DeviceID: "phone-1", Result: "Opened", Finished: true, Success: true, Duration: 2 * time.Second},
+			{TaskID: "pay", DeviceID: "phone-1", Result: "User cancelled sensitive operation", Finished: true, Duration: time.Second},
+			{TaskID: "open", DeviceID: "phone-2", Result: "Max steps reached", Steps: 5, Duration: 3 * time.Second},
+			{TaskID: "pay", DeviceID: "phone-2", Error: "device offline", Err: errors.New("device offline")},
+		},
+	}
+
+	data, err := junitReport(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var suites junitTestSuites
+	if err := xml.Unmarshal(data, &suites); err != nil {
+		t.Fatalf("invalid XML: %v\n%s", err, data)
+	}
+
+	if suites.Tests != 4 || suites.Failures != 2 || suites.Errors != 1 || suites.Time != 9 {
+		t.Errorf("unexpected totals: tests %d, failures %d, errors %d, time %v", suites.Tests, suites.Failures, suites.Errors, suites.Time)
+	}
+	if len(suites.Suites) != 2 || suites.Suites[0].Name != "phone-1" || suites.Suites[0].Timestamp != "2025-01-02T03:04:05" {
+		t.Fatalf("expected one suite per device, got %+v", suites.Suites)
+	}
+	phone1, phone2 := suites.Suites[0], suites.Suites[1]
+	if phone1.Tests != 2 || phone1.Time != 3 || phone1.Cases[0].Failure != nil || phone1.Cases[0].SystemOut != "Opened" {
+		t.Errorf("unexpected phone-1 suite %+v", phone1)
+	}
+	if failure := phone1.Cases[1].Failure; failure == nil || failure.Message != "task failed: User cancelled sensitive operation" {
+		t.Errorf("expected the rejected task to fail, got %+v", failure)
+	}
+	if failure := phone2.Cases[0].Failure; failure == nil || failure.Message != "task not finished after 5 steps" {
+		t.Errorf("expected the unfinished task to fail, got %+v", failure)
+	}
+	if e := phone2.Cases[1].Error; e == nil || e.Message != "device offline" {
+		t.Errorf("expected the task error, got %+v", e)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spance/autoglm-go/phoneagent"
+	"github.com/spance/autoglm-go/phoneagent/definitions"
+	"github.com/spance/autoglm-go/server"
+	"github.com/spf13/cobra"
+)
+
+// ServeConfig holds the options of the serve subcommand
+type ServeConfig struct {
+	Addr           string `json:"addr"`
+	MaxTasks       int    `json:"max_tasks"`
+	MaxScreenshots int    `json:"max_screenshots"`
+	Token          string `json:"-"`
+}
+
+var serveConfig = &ServeConfig{}
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve an HTTP/JSON API to submit and follow tasks remotely",
+	Example: `  # Serve on localhost:8080
+  go run main.go serve
+
+  # Accept other hosts, requiring a token
+  PHONE_AGENT_SERVE_TOKEN=secret go run main.go serve --addr :8080
+
+  # Submit a task, then follow it
+  curl -X POST localhost:8080/api/tasks -d '{"task": "Open Settings", "device": "emulator-5554"}'
+  curl localhost:8080/api/tasks/<id>/events`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		config.Command = "serve"
+	},
+}
+
+func init() {
+	serveCmd.Flags().StringVar(&serveConfig.Addr, "addr", getEnv("PHONE_AGENT_SERVE_ADDR", "127.0.0.1:8080"),
+		"Address the API listens on; use e.g. :8080 with --token to accept other hosts")
+	serveCmd.Flags().IntVar(&serveConfig.MaxTasks, "max-tasks", server.DefaultMaxTasks,
+		"Number of tasks kept in memory, the oldest finished ones are dropped first")
+	serveCmd.Flags().IntVar(&serveConfig.MaxScreenshots, "max-screenshots", server.DefaultMaxScreenshots,
+		"Number of full screenshots kept per task, the oldest steps lose theirs first")
+	serveCmd.Flags().StringVar(&serveConfig.Token, "token", getEnv("PHONE_AGENT_SERVE_TOKEN", ""),
+		"Bearer token required by the API (env PHONE_AGENT_SERVE_TOKEN)")
+
+	rootCmd.AddCommand(serveCmd)
+}
+
+// runServer serves the API until interrupted, then cancels the running tasks
+func runServer(ctx context.Context, device phoneagent.Device, modelConfig *definitions.ModelConfig,
+	agentConfig *definitions.AgentConfig, opts []phoneagent.Option) error {
+	ctx, stop := interruptible(ctx)
+	defer stop()
+
+	api := server.New(device, server.Config{
+		ModelConfig:    modelConfig,
+		AgentConfig:    agentConfig,
+		Options:        func(deviceID string) []phoneagent.Option { return opts },
+		MaxTasks:       serveConfig.MaxTasks,
+		MaxScreenshots: serveConfig.MaxScreenshots,
+		Token:          serveConfig.Token,
+	})
+	httpServer := &http.Server{Addr: serveConfig.Addr, Handler: api.Handler()}
+	if serveConfig.Token == "" && !isLoopback(serveConfig.Addr) {
+		log.Warn().Str("addr", serveConfig.Addr).Msg("API reachable from other hosts without --token, anyone on the network can drive the devices")
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		log.Info().Str("addr", serveConfig.Addr).Bool("token", serveConfig.Token != "").Msg("Serving API")
+		errCh <- httpServer.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		api.Close()
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Info().Msg("Shutting down")
+	api.Close()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// isLoopback reports whether addr only listens on the local host
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,429 @@
+// Package server exposes a PhoneAgent over an HTTP/JSON API so that other
+// services can submit tasks to devices, follow them and cancel them.
+//
+// Endpoints:
+//
+//	GET  /api/devices                              connected devices
+//	POST /api/tasks                                submit a task (phoneagent.Task JSON)
+//	GET  /api/tasks                                all known tasks
+//	GET  /api/tasks/{id}                           task status
+//	GET  /api/tasks/{id}/events                    live step phases as server-sent events
+//	GET  /api/tasks/{id}/steps                     step history
+//	GET  /api/tasks/{id}/steps/{step}/screenshot   screenshot of a step
+//	POST /api/tasks/{id}/cancel                    cancel a queued or running task
+//
+// The root page is a minimal viewer following tasks as they run. Tasks for the
+// same device run one after the other; tasks for different devices run concurrently.
+// When Config.Token is set, the API requires it as a bearer token ("Authorization:
+// Bearer <token>", or a "token" query parameter for event streams and images).
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"github.com/spance/autoglm-go/phoneagent"
+	"github.com/spance/autoglm-go/phoneagent/definitions"
+	"github.com/spance/autoglm-go/phoneagent/imageutil"
+)
+
+//go:embed viewer.html
+var viewerHTML []byte
+
+const (
+	// DefaultMaxTasks is the number of tasks kept in memory when Config.MaxTasks is 0.
+	DefaultMaxTasks = 100
+	// DefaultMaxScreenshots is the number of screenshots kept per task when
+	// Config.MaxScreenshots is 0.
+	DefaultMaxScreenshots = 20
+)
+
+// Config configures a Server.
+type Config struct {
+	ModelConfig *definitions.ModelConfig
+	AgentConfig *definitions.AgentConfig // template, AgentConfig.DeviceID is the default device
+
+	// Options returns extra options for the agent of a device. Nobody answers
+	// prompts on a server, so by default sensitive operations are rejected and
+	// takeover or interaction requests end the task; options may replace these handlers.
+	Options func(deviceID string) []phoneagent.Option
+	// MaxTasks is the number of tasks kept in memory; the oldest finished tasks
+	// are dropped beyond it. 0 means DefaultMaxTasks.
+	MaxTasks int
+	// MaxScreenshots is the number of full screenshots kept per task; the oldest
+	// steps lose theirs beyond it. 0 means DefaultMaxScreenshots.
+	MaxScreenshots int
+	// Token, when set, is the bearer token required by every API request.
+	Token string
+}
+
+// Server runs tasks submitted over HTTP.
+type Server struct {
+	device phoneagent.Device
+	config Config
+	runner *phoneagent.Runner
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu      sync.Mutex
+	tasks   map[string]*task
+	order   []string                 // task IDs by submission time
+	devices map[string]chan struct{} // per-device lock, held by the running task
+}
+
+// New creates a Server driving device.
+func New(device phoneagent.Device, config Config) *Server {
+	if config.MaxTasks <= 0 {
+		config.MaxTasks = DefaultMaxTasks
+	}
+	if config.MaxScreenshots <= 0 {
+		config.MaxScreenshots = DefaultMaxScreenshots
+	}
+	s := &Server{
+		device:  device,
+		config:  config,
+		tasks:   map[string]*task{},
+		devices: map[string]chan struct{}{},
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.runner = phoneagent.NewRunner(device, config.ModelConfig, config.AgentConfig)
+	s.runner.Options = func(deviceID string) []phoneagent.Option {
+		opts := []phoneagent.Option{
+			phoneagent.WithConfirmationHandler(phoneagent.ConfirmationFunc(rejectConfirmation)),
+			phoneagent.WithTakeoverHandler(phoneagent.TakeoverFunc(refuseTakeover)),
+			phoneagent.WithInteractHandler(phoneagent.InteractFunc(refuseInteract)),
+		}
+		if config.Options != nil {
+			opts = append(opts, config.Options(deviceID)...)
+		}
+		return opts
+	}
+	return s
+}
+
+func rejectConfirmation(ctx context.Context, message string) (bool, error) {
+	log.Warn().Str("message", message).Msg("Sensitive operation rejected, no operator")
+	return false, nil
+}
+
+func refuseTakeover(ctx context.Context, message string) error {
+	return fmt.Errorf("manual takeover is not available on the server: %s", message)
+}
+
+func refuseInteract(ctx context.Context, message string) (string, error) {
+	return "", fmt.Errorf("user interaction is not available on the server: %s", message)
+}
+
+// Close cancels the queued and running tasks and waits for them to end.
+func (s *Server) Close() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+// Handler returns the HTTP handler of the API.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", s.handleViewer)
+	mux.HandleFunc("GET /api/devices", s.handleDevices)
+	mux.HandleFunc("POST /api/tasks", s.handleSubmit)
+	mux.HandleFunc("GET /api/tasks", s.handleList)
+	mux.HandleFunc("GET /api/tasks/{id}", s.handleGet)
+	mux.HandleFunc("GET /api/tasks/{id}/events", s.handleEvents)
+	mux.HandleFunc("GET /api/tasks/{id}/steps", s.handleSteps)
+	mux.HandleFunc("GET /api/tasks/{id}/steps/{step}/screenshot", s.handleScreenshot)
+	mux.HandleFunc("POST /api/tasks/{id}/cancel", s.handleCancel)
+	if s.config.Token == "" {
+		return mux
+	}
+	return s.authenticate(mux)
+}
+
+// authenticate rejects API requests without Config.Token. The viewer page holds no
+// data and is served to everyone; it passes the token it was opened with to the API.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok {
+				token = r.URL.Query().Get("token")
+			}
+			if subtle.ConstantTimeCompare([]byte(token), []byte(s.config.Token)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeError(w, http.StatusUnauthorized, "missing or invalid token")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Submit queues a task and returns its status. The task ID is generated.
+func (s *Server) Submit(t phoneagent.Task) (TaskInfo, error) {
+	if t.DeviceID == "" && s.config.AgentConfig != nil {
+		t.DeviceID = s.config.AgentConfig.DeviceID
+	}
+	if err := t.Validate(); err != nil {
+		return TaskInfo{}, err
+	}
+	if err := s.ctx.Err(); err != nil {
+		return TaskInfo{}, errors.New("server is shutting down")
+	}
+	t.ID = uuid.NewString()
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	tk := newTask(t.ID, t, cancel, s.config.MaxScreenshots)
+
+	s.mu.Lock()
+	s.tasks[t.ID] = tk
+	s.order = append(s.order, t.ID)
+	s.evict()
+	lock, ok := s.devices[t.DeviceID]
+	if !ok {
+		lock = make(chan struct{}, 1)
+		s.devices[t.DeviceID] = lock
+	}
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer cancel()
+		s.run(ctx, tk, t, lock)
+	}()
+
+	info, _ := tk.snapshot()
+	log.Info().Str("task", t.ID).Str("device", t.DeviceID).Msg("Task submitted")
+	return info, nil
+}
+
+func (s *Server) run(ctx context.Context, tk *task, t phoneagent.Task, lock chan struct{}) {
+	select {
+	case lock <- struct{}{}:
+		defer func() { <-lock }()
+	case <-ctx.Done():
+		tk.finish(phoneagent.TaskResult{Err: ctx.Err(), Error: ctx.Err().Error()})
+		return
+	}
+	if !tk.start() {
+		return
+	}
+	tk.finish(s.runner.RunTask(ctx, t.DeviceID, t, phoneagent.WithObserver(tk)))
+}
+
+// evict drops the oldest finished tasks beyond MaxTasks; it must be called with s.mu held.
+func (s *Server) evict() {
+	excess := len(s.order) - s.config.MaxTasks
+	kept := s.order[:0]
+	for _, id := range s.order {
+		if excess > 0 {
+			if info, _ := s.tasks[id].snapshot(); info.Status.Done() {
+				delete(s.tasks, id)
+				excess--
+				continue
+			}
+		}
+		kept = append(kept, id)
+	}
+	s.order = kept
+}
+
+func (s *Server) task(id string) *task {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tasks[id]
+}
+
+func (s *Server) handleDevices(w http.ResponseWriter, r *http.Request) {
+	devices, err := s.device.ListDevices(r.Context())
+	if err != nil {
+		writeError(w, http.StatusBadGateway, fmt.Sprintf("failed to list devices: %v", err))
+		return
+	}
+	if devices == nil {
+		devices = []definitions.DeviceInfo{}
+	}
+	writeJSON(w, http.StatusOK, devices)
+}
+
+func (s *Server) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	var t phoneagent.Task
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&t); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid task: %v", err))
+		return
+	}
+	info, err := s.Submit(t)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	w.Header().Set("Location", "/api/tasks/"+info.ID)
+	writeJSON(w, http.StatusAccepted, info)
+}
+
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	infos := make([]TaskInfo, 0, len(s.order))
+	for _, id := range s.order {
+		info, _ := s.tasks[id].snapshot()
+		infos = append(infos, info)
+	}
+	s.mu.Unlock()
+
+	if status := r.URL.Query().Get("status"); status != "" {
+		filtered := infos[:0]
+		for _, info := range infos {
+			if string(info.Status) == status {
+				filtered = append(filtered, info)
+			}
+		}
+		infos = filtered
+	}
+	sort.SliceStable(infos, func(i, j int) bool { return infos[i].CreatedAt.After(infos[j].CreatedAt) })
+	writeJSON(w, http.StatusOK, infos)
+}
+
+func (s *Server) handleGet(w http.ResponseWriter, r *http.Request) {
+	tk := s.task(r.PathValue("id"))
+	if tk == nil {
+		writeError(w, http.StatusNotFound, "task not found")
+		return
+	}
+	info, _ := tk.snapshot()
+	writeJSON(w, http.StatusOK, info)
+}
+
+// handleEvents streams the events of the task as server-sent events until the task
+// ends. Every event is sent from the start, or after the event given by the
+// Last-Event-ID header (set by browsers when reconnecting) or the "after" parameter.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	tk := s.task(r.PathValue("id"))
+	if tk == nil {
+		writeError(w, http.StatusNotFound, "task not found")
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+	after := r.Header.Get("Last-Event-ID")
+	if after == "" {
+		after = r.URL.Query().Get("after")
+	}
+	last, _ := strconv.Atoi(after)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		events, changed, done := tk.eventsAfter(last)
+		for _, event := range events {
+			data, _ := json.Marshal(event)
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data); err != nil {
+				return
+			}
+			last = event.ID
+		}
+		flusher.Flush()
+		if done {
+			return
+		}
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (s *Server) handleSteps(w http.ResponseWriter, r *http.Request) {
+	tk := s.task(r.PathValue("id"))
+	if tk == nil {
+		writeError(w, http.StatusNotFound, "task not found")
+		return
+	}
+	steps := tk.history()
+	if steps == nil {
+		steps = []StepInfo{}
+	}
+	writeJSON(w, http.StatusOK, steps)
+}
+
+func (s *Server) handleScreenshot(w http.ResponseWriter, r *http.Request) {
+	tk := s.task(r.PathValue("id"))
+	if tk == nil {
+		writeError(w, http.StatusNotFound, "task not found")
+		return
+	}
+	step, err := strconv.Atoi(r.PathValue("step"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid step")
+		return
+	}
+	screenshot := tk.screenshot(step)
+	if screenshot == nil {
+		writeError(w, http.StatusNotFound, "screenshot not found")
+		return
+	}
+	data, err := imageutil.Bytes(screenshot)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("failed to decode screenshot: %v", err))
+		return
+	}
+	mimeType := screenshot.MimeType
+	if mimeType == "" {
+		mimeType = "image/png"
+	}
+	w.Header().Set("Content-Type", mimeType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	_, _ = w.Write(data)
+}
+
+func (s *Server) handleCancel(w http.ResponseWriter, r *http.Request) {
+	tk := s.task(r.PathValue("id"))
+	if tk == nil {
+		writeError(w, http.StatusNotFound, "task not found")
+		return
+	}
+	if !tk.requestCancel() {
+		writeError(w, http.StatusConflict, "task has already ended")
+		return
+	}
+	info, _ := tk.snapshot()
+	log.Info().Str("task", info.ID).Msg("Task canceled")
+	writeJSON(w, http.StatusAccepted, info)
+}
+
+func (s *Server) handleViewer(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write(viewerHTML)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Debug().Err(err).Msg("Failed to write response")
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,363 @@
+package server_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/spance/autoglm-go/phoneagent"
+	"github.com/spance/autoglm-go/phoneagent/definitions"
+	"github.com/spance/autoglm-go/phoneagent/devicetest"
+	"github.com/spance/autoglm-go/phoneagent/llm/llmtest"
+	"github.com/spance/autoglm-go/server"
+)
+
+func newTestServer(t *testing.T, model *llmtest.Server) *httptest.Server {
+	t.Helper()
+	return newTestServerWithToken(t, model, "")
+}
+
+func newTestServerWithToken(t *testing.T, model *llmtest.Server, token string) *httptest.Server {
+	t.Helper()
+	device := devicetest.NewFakeDevice(devicetest.SolidScreen(10, 10, color.White, "Home"))
+	device.SetDevices(
+		definitions.DeviceInfo{DeviceID: "phone-1", Status: "device"},
+		definitions.DeviceInfo{DeviceID: "phone-2", Status: "offline"},
+	)
+	s := server.New(device, server.Config{
+		ModelConfig: &definitions.ModelConfig{BaseURL: model.BaseURL(), ModelName: "autoglm-phone"},
+		AgentConfig: &definitions.AgentConfig{MaxSteps: 5, Lang: "en", DeviceID: "phone-1"},
+		Token:       token,
+	})
+	ts := httptest.NewServer(s.Handler())
+	t.Cleanup(func() {
+		ts.Close()
+		s.Close()
+	})
+	return ts
+}
+
+func doJSON(t *testing.T, method, url, body string, status int, out any) {
+	t.Helper()
+	req, _ := http.NewRequest(method, url, strings.NewReader(body))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != status {
+		t.Fatalf("%s %s: status %d, want %d", method, url, resp.StatusCode, status)
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func waitStatus(t *testing.T, url string, want server.TaskStatus) server.TaskInfo {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var info server.TaskInfo
+		doJSON(t, http.MethodGet, url, "", http.StatusOK, &info)
+		if info.Status == want {
+			return info
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("task status %s, want %s", info.Status, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func readEvents(t *testing.T, url, lastEventID string) []server.Event {
+	t.Helper()
+	return readEventsContext(t, context.Background(), url, lastEventID)
+}
+
+// readEventsContext reads events until the stream ends or ctx is done.
+func readEventsContext(t *testing.T, ctx context.Context, url, lastEventID string) []server.Event {
+	t.Helper()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("content type %q", ct)
+	}
+
+	var events []server.Event
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+			var event server.Event
+			if err := json.Unmarshal([]byte(data), &event); err != nil {
+				t.Fatal(err)
+			}
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+func TestSubmitAndFollowTask(t *testing.T) {
+	model := llmtest.NewServer(
+		llmtest.ToolCall("Go home first", "press_home", nil),
+		llmtest.Finish("Done"),
+	)
+	defer model.Close()
+	ts := newTestServer(t, model)
+
+	var info server.TaskInfo
+	doJSON(t, http.MethodPost, ts.URL+"/api/tasks", `{"task":"Go home"}`, http.StatusAccepted, &info)
+	if info.ID == "" || info.DeviceID != "phone-1" {
+		t.Fatalf("unexpected task %+v", info)
+	}
+
+	// The event stream replays every phase and ends with the final status
+	events := readEvents(t, ts.URL+"/api/tasks/"+info.ID+"/events", "")
+	var types []string
+	for _, e := range events {
+		types = append(types, string(e.Type))
+	}
+	want := "status status step screenshot thinking action step screenshot thinking action status"
+	if strings.Join(types, " ") != want {
+		t.Fatalf("events %q, want %q", strings.Join(types, " "), want)
+	}
+	last := events[len(events)-1].Status
+	if last.Status != server.StatusSucceeded || last.Result != "Done" || last.Step != 2 {
+		t.Fatalf("unexpected final status %+v", last)
+	}
+	if shot := events[3]; shot.Screenshot == "" || shot.App != "Home" {
+		t.Errorf("unexpected screenshot event %+v", shot)
+	}
+	if action := events[5]; action.Action["action"] != "Home" || !action.Result.Success {
+		t.Errorf("unexpected action event %+v", action)
+	}
+
+	// Reconnecting resumes after the last received event
+	if resumed := readEvents(t, ts.URL+"/api/tasks/"+info.ID+"/events", "9"); len(resumed) != 2 || resumed[0].ID != 10 {
+		t.Errorf("unexpected resumed events %+v", resumed)
+	}
+
+	var steps []server.StepInfo
+	doJSON(t, http.MethodGet, ts.URL+"/api/tasks/"+info.ID+"/steps", "", http.StatusOK, &steps)
+	if len(steps) != 2 || steps[0].Action["action"] != "Home" || !steps[0].Success || steps[0].Thinking != "Go home first" {
+		t.Fatalf("unexpected steps %+v", steps)
+	}
+
+	resp, err := http.Get(ts.URL + steps[0].Screenshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var image bytes.Buffer
+	_, _ = image.ReadFrom(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "image/png" || image.Len() == 0 {
+		t.Fatalf("screenshot: status %d, type %q, %d bytes", resp.StatusCode, resp.Header.Get("Content-Type"), image.Len())
+	}
+
+	var tasks []server.TaskInfo
+	doJSON(t, http.MethodGet, ts.URL+"/api/tasks?status=succeeded", "", http.StatusOK, &tasks)
+	if len(tasks) != 1 || tasks[0].ID != info.ID {
+		t.Fatalf("unexpected task list %+v", tasks)
+	}
+}
+
+func TestEndedTaskEvents(t *testing.T) {
+	model := llmtest.NewServer(
+		llmtest.ToolCall("The app asks to log in", "take_over", map[string]any{"message": "Log in"}),
+		llmtest.Finish("Done"),
+	)
+	defer model.Close()
+	blocked, release := make(chan struct{}), make(chan struct{})
+	s := server.New(devicetest.NewFakeDevice(devicetest.SolidScreen(10, 10, color.White, "Home")), server.Config{
+		ModelConfig: &definitions.ModelConfig{BaseURL: model.BaseURL(), ModelName: "autoglm-phone", Stream: true},
+		AgentConfig: &definitions.AgentConfig{MaxSteps: 5, Lang: "en", DeviceID: "phone-1"},
+		Options: func(deviceID string) []phoneagent.Option {
+			return []phoneagent.Option{phoneagent.WithTakeoverHandler(phoneagent.TakeoverFunc(func(ctx context.Context, message string) error {
+				close(blocked)
+				<-release
+				return nil
+			}))}
+		},
+		MaxScreenshots: 1,
+	})
+	ts := httptest.NewServer(s.Handler())
+	defer func() {
+		ts.Close()
+		s.Close()
+	}()
+
+	var info server.TaskInfo
+	doJSON(t, http.MethodPost, ts.URL+"/api/tasks", `{"task":"Open the bank app"}`, http.StatusAccepted, &info)
+	<-blocked
+
+	// While the task runs, watchers get the thinking as it streams and thumbnails
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	live := readEventsContext(t, ctx, ts.URL+"/api/tasks/"+info.ID+"/events", "")
+	cancel()
+	count := func(events []server.Event) (deltas, thumbnails int) {
+		for _, e := range events {
+			if e.Type == server.EventThinkingDelta {
+				deltas++
+			}
+			if strings.HasPrefix(e.Thumbnail, "data:image/jpeg;base64,") {
+				thumbnails++
+			}
+		}
+		return deltas, thumbnails
+	}
+	if deltas, thumbnails := count(live); deltas == 0 || thumbnails != 1 {
+		t.Fatalf("expected thinking deltas and a thumbnail while running, got %d, %d", deltas, thumbnails)
+	}
+
+	// Once ended, only the complete events remain, under their original IDs
+	close(release)
+	waitStatus(t, ts.URL+"/api/tasks/"+info.ID, server.StatusSucceeded)
+	events := readEvents(t, ts.URL+"/api/tasks/"+info.ID+"/events", "")
+	if deltas, thumbnails := count(events); deltas != 0 || thumbnails != 0 {
+		t.Errorf("expected no thinking deltas nor thumbnails once ended, got %d, %d", deltas, thumbnails)
+	}
+	last := events[len(events)-1]
+	if last.ID <= len(events) || last.Type != server.EventStatus {
+		t.Errorf("expected the last event to keep its ID, got %+v", last)
+	}
+	if resumed := readEvents(t, ts.URL+"/api/tasks/"+info.ID+"/events", strconv.Itoa(events[len(events)-2].ID)); len(resumed) != 1 || resumed[0].ID != last.ID {
+		t.Errorf("unexpected resumed events %+v", resumed)
+	}
+
+	// Only the screenshot of the last step is kept
+	var steps []server.StepInfo
+	doJSON(t, http.MethodGet, ts.URL+"/api/tasks/"+info.ID+"/steps", "", http.StatusOK, &steps)
+	if len(steps) != 2 || steps[0].Screenshot != "" || steps[1].Screenshot == "" {
+		t.Fatalf("unexpected steps %+v", steps)
+	}
+	doJSON(t, http.MethodGet, ts.URL+"/api/tasks/"+info.ID+"/steps/1/screenshot", "", http.StatusNotFound, nil)
+	doJSON(t, http.MethodGet, ts.URL+steps[1].Screenshot, "", http.StatusOK, nil)
+	for _, e := range events {
+		if e.Type == server.EventScreenshot && (e.Step == 1) != (e.Screenshot == "") {
+			t.Errorf("expected only the kept screenshot in the events, got %+v", e)
+		}
+	}
+}
+
+func TestRejectedConfirmationFails(t *testing.T) {
+	model := llmtest.NewServer(
+		llmtest.ToolCall("Pay for the order.", "tap", map[string]any{"element": []int{500, 500}, "message": "Pay 10 CNY"}),
+	)
+	defer model.Close()
+	ts := newTestServer(t, model)
+
+	var info server.TaskInfo
+	doJSON(t, http.MethodPost, ts.URL+"/api/tasks", `{"task":"Buy it"}`, http.StatusAccepted, &info)
+	// Without an operator the payment is rejected, which ends the task as a failure
+	done := waitStatus(t, ts.URL+"/api/tasks/"+info.ID, server.StatusFailed)
+	if done.Error == "" || done.Step != 1 {
+		t.Errorf("unexpected failed task %+v", done)
+	}
+}
+
+func TestCancelTasks(t *testing.T) {
+	release := make(chan struct{})
+	model := llmtest.NewServerFunc(func(req *openai.ChatCompletionRequest) llmtest.Response {
+		<-release
+		return llmtest.Finish("Done")
+	})
+	defer model.Close()
+	defer close(release)
+	ts := newTestServer(t, model)
+
+	var running, queued server.TaskInfo
+	doJSON(t, http.MethodPost, ts.URL+"/api/tasks", `{"task":"Wait"}`, http.StatusAccepted, &running)
+	waitStatus(t, ts.URL+"/api/tasks/"+running.ID, server.StatusRunning)
+
+	// A second task for the same device waits for the first one
+	doJSON(t, http.MethodPost, ts.URL+"/api/tasks", `{"task":"Wait again","device":"phone-1"}`, http.StatusAccepted, &queued)
+	time.Sleep(50 * time.Millisecond)
+	waitStatus(t, ts.URL+"/api/tasks/"+queued.ID, server.StatusQueued)
+
+	doJSON(t, http.MethodPost, ts.URL+"/api/tasks/"+queued.ID+"/cancel", "", http.StatusAccepted, nil)
+	waitStatus(t, ts.URL+"/api/tasks/"+queued.ID, server.StatusCanceled)
+
+	doJSON(t, http.MethodPost, ts.URL+"/api/tasks/"+running.ID+"/cancel", "", http.StatusAccepted, nil)
+	waitStatus(t, ts.URL+"/api/tasks/"+running.ID, server.StatusCanceled)
+
+	doJSON(t, http.MethodPost, ts.URL+"/api/tasks/"+running.ID+"/cancel", "", http.StatusConflict, nil)
+}
+
+func TestDevicesAndErrors(t *testing.T) {
+	model := llmtest.NewServer()
+	defer model.Close()
+	ts := newTestServer(t, model)
+
+	var devices []definitions.DeviceInfo
+	doJSON(t, http.MethodGet, ts.URL+"/api/devices", "", http.StatusOK, &devices)
+	if len(devices) != 2 || devices[0].DeviceID != "phone-1" {
+		t.Fatalf("unexpected devices %+v", devices)
+	}
+
+	var apiErr struct {
+		Error string `json:"error"`
+	}
+	doJSON(t, http.MethodPost, ts.URL+"/api/tasks", `{"task":""}`, http.StatusBadRequest, &apiErr)
+	if apiErr.Error != "task is empty" {
+		t.Errorf("unexpected error %q", apiErr.Error)
+	}
+	doJSON(t, http.MethodPost, ts.URL+"/api/tasks", `{"task":"x","steps":3}`, http.StatusBadRequest, nil)
+	doJSON(t, http.MethodGet, ts.URL+"/api/tasks/missing", "", http.StatusNotFound, nil)
+	doJSON(t, http.MethodGet, ts.URL+"/api/tasks/missing/steps/1/screenshot", "", http.StatusNotFound, nil)
+
+	resp, err := http.Get(ts.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		t.Errorf("viewer: status %d, type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+}
+
+func TestTokenRequired(t *testing.T) {
+	model := llmtest.NewServer()
+	defer model.Close()
+	ts := newTestServerWithToken(t, model, "secret")
+
+	doJSON(t, http.MethodGet, ts.URL+"/api/devices", "", http.StatusUnauthorized, nil)
+	doJSON(t, http.MethodGet, ts.URL+"/api/tasks?token=wrong", "", http.StatusUnauthorized, nil)
+	doJSON(t, http.MethodGet, ts.URL+"/api/tasks?token=secret", "", http.StatusOK, nil)
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/api/devices", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("bearer token: status %d", resp.StatusCode)
+	}
+
+	// The viewer page is public, it forwards the token it is opened with
+	if resp, err := http.Get(ts.URL + "/"); err != nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("viewer: %v, %v", resp, err)
+	} else {
+		resp.Body.Close()
+	}
+}
@@ -0,0 +1,338 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/spance/autoglm-go/phoneagent"
+	"github.com/spance/autoglm-go/phoneagent/definitions"
+	"github.com/spance/autoglm-go/phoneagent/helper"
+	"github.com/spance/autoglm-go/phoneagent/imageutil"
+	"github.com/spance/autoglm-go/phoneagent/llm"
+)
+
+// TaskStatus is the lifecycle state of a submitted task.
+type TaskStatus string
+
+const (
+	StatusQueued    TaskStatus = "queued"    // waiting for its device
+	StatusRunning   TaskStatus = "running"   // the agent is executing steps
+	StatusSucceeded TaskStatus = "succeeded" // the model finished the task
+	StatusFailed    TaskStatus = "failed"    // an error occurred or MaxSteps was reached
+	StatusCanceled  TaskStatus = "canceled"  // canceled through the API or on shutdown
+)
+
+// Done reports whether the status is final.
+func (s TaskStatus) Done() bool {
+	return s == StatusSucceeded || s == StatusFailed || s == StatusCanceled
+}
+
+// TaskInfo is the JSON view of a task.
+type TaskInfo struct {
+	ID        string     `json:"id"`
+	Task      string     `json:"task"`
+	DeviceID  string     `json:"device"`
+	Status    TaskStatus `json:"status"`
+	Step      int        `json:"step"` // steps executed so far
+	Result    string     `json:"result,omitempty"`
+	Error     string     `json:"error,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	StartedAt *time.Time `json:"started_at,omitempty"`
+	EndedAt   *time.Time `json:"ended_at,omitempty"`
+}
+
+// StepInfo is the JSON view of one executed step.
+type StepInfo struct {
+	Step       int           `json:"step"`
+	App        string        `json:"app,omitempty"`
+	Thinking   string        `json:"thinking,omitempty"`
+	Action     helper.Action `json:"action,omitempty"`
+	Success    bool          `json:"success"`
+	Message    string        `json:"message,omitempty"`
+	Error      string        `json:"error,omitempty"`
+	Screenshot string        `json:"screenshot,omitempty"` // URL of the step screenshot
+	Time       time.Time     `json:"time"`
+}
+
+// EventType identifies the phase reported by an Event.
+type EventType string
+
+const (
+	EventStatus        EventType = "status"         // the task status changed, Status is set
+	EventStep          EventType = "step"           // a step started
+	EventScreenshot    EventType = "screenshot"     // App, Thumbnail and Screenshot are set
+	EventThinkingDelta EventType = "thinking_delta" // Thinking holds new text, streaming models only
+	EventThinking      EventType = "thinking"       // Thinking holds the whole thinking of the step
+	EventAction        EventType = "action"         // Action and Result are set
+	EventError         EventType = "step_error"     // the step failed, Error is set
+)
+
+// Event is one phase of a task, in the order the agent went through them. Once the
+// task has ended, its thinking_delta events are dropped (thinking events hold the
+// whole text) and so are the thumbnails; the IDs of the other events do not change.
+// The Screenshot URL is cleared once the server no longer keeps that screenshot.
+type Event struct {
+	ID         int                  `json:"id"` // 1-based, increasing with each event of the task
+	Type       EventType            `json:"type"`
+	Step       int                  `json:"step,omitempty"`
+	Time       time.Time            `json:"time"`
+	Status     *TaskInfo            `json:"status,omitempty"`
+	App        string               `json:"app,omitempty"`
+	Thumbnail  string               `json:"thumbnail,omitempty"`  // data URL of a downscaled screenshot
+	Screenshot string               `json:"screenshot,omitempty"` // URL of the full screenshot
+	Thinking   string               `json:"thinking,omitempty"`
+	Action     helper.Action        `json:"action,omitempty"`
+	Result     *helper.ActionResult `json:"result,omitempty"`
+	Error      string               `json:"error,omitempty"`
+}
+
+// thumbnailEdge is the long edge of the screenshot thumbnails sent in events.
+const thumbnailEdge = 360
+
+// task is a submitted task and the history recorded by its agent. It implements
+// phoneagent.StepObserver and phoneagent.ThinkingObserver.
+type task struct {
+	phoneagent.NopObserver
+
+	mu             sync.Mutex
+	info           TaskInfo
+	steps          []StepInfo
+	events         []Event
+	lastID         int // ID of the last event
+	screenshots    map[int]*definitions.Screenshot
+	maxScreenshots int
+	cancel         context.CancelFunc // cancels the context of the task
+	canceled       bool
+	changed        chan struct{} // closed and replaced on every update
+}
+
+func newTask(id string, t phoneagent.Task, cancel context.CancelFunc, maxScreenshots int) *task {
+	tk := &task{
+		cancel:         cancel,
+		maxScreenshots: maxScreenshots,
+		info: TaskInfo{
+			ID:        id,
+			Task:      t.Prompt,
+			DeviceID:  t.DeviceID,
+			Status:    StatusQueued,
+			CreatedAt: time.Now(),
+		},
+		screenshots: map[int]*definitions.Screenshot{},
+		changed:     make(chan struct{}),
+	}
+	tk.emitStatus()
+	return tk
+}
+
+// update applies fn under the lock and wakes up the watchers.
+func (t *task) update(fn func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fn()
+	close(t.changed)
+	t.changed = make(chan struct{})
+}
+
+// snapshot returns the task info and a channel closed on the next update.
+func (t *task) snapshot() (TaskInfo, <-chan struct{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.info, t.changed
+}
+
+// eventsAfter returns the events following the event with ID after, a channel
+// closed on the next update and whether the task has ended.
+func (t *task) eventsAfter(after int) ([]Event, <-chan struct{}, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	i := sort.Search(len(t.events), func(i int) bool { return t.events[i].ID > after })
+	return append([]Event(nil), t.events[i:]...), t.changed, t.info.Status.Done()
+}
+
+// emit records an event; it must be called with the lock held.
+func (t *task) emit(e Event) {
+	t.lastID++
+	e.ID, e.Time = t.lastID, time.Now()
+	t.events = append(t.events, e)
+}
+
+// emitStatus must be called with the lock held.
+func (t *task) emitStatus() {
+	info := t.info
+	t.emit(Event{Type: EventStatus, Step: info.Step, Status: &info})
+}
+
+func (t *task) history() []StepInfo {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]StepInfo(nil), t.steps...)
+}
+
+func (t *task) screenshot(step int) *definitions.Screenshot {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.screenshots[step]
+}
+
+// current returns the record of the step being executed.
+func (t *task) current(step int) *StepInfo {
+	if n := len(t.steps); n > 0 && t.steps[n-1].Step == step {
+		return &t.steps[n-1]
+	}
+	t.steps = append(t.steps, StepInfo{Step: step, Time: time.Now()})
+	return &t.steps[len(t.steps)-1]
+}
+
+// start marks the task as running; it reports false when it was canceled while queued.
+func (t *task) start() bool {
+	ok := true
+	t.update(func() {
+		if t.canceled {
+			ok = false
+			return
+		}
+		now := time.Now()
+		t.info.Status, t.info.StartedAt = StatusRunning, &now
+		t.emitStatus()
+	})
+	return ok
+}
+
+// requestCancel cancels the task; it reports false when the task had already ended.
+func (t *task) requestCancel() bool {
+	ok := true
+	t.update(func() {
+		if t.info.Status.Done() {
+			ok = false
+			return
+		}
+		t.canceled = true
+		t.cancel()
+		if t.info.Status == StatusQueued {
+			t.end(StatusCanceled, "", "canceled before start")
+		}
+	})
+	return ok
+}
+
+// end must be called with the lock held.
+func (t *task) end(status TaskStatus, result, errMsg string) {
+	now := time.Now()
+	t.info.Status, t.info.Result, t.info.Error, t.info.EndedAt = status, result, errMsg, &now
+	t.emitStatus()
+	t.compact()
+}
+
+// compact drops what only live watchers need from the events of an ended task: the
+// thinking deltas and the thumbnails. It must be called with the lock held.
+func (t *task) compact() {
+	events := t.events[:0]
+	for _, e := range t.events {
+		if e.Type == EventThinkingDelta {
+			continue
+		}
+		e.Thumbnail = ""
+		events = append(events, e)
+	}
+	clear(t.events[len(events):])
+	t.events = slices.Clip(events)
+}
+
+func (t *task) finish(result phoneagent.TaskResult) {
+	t.update(func() {
+		switch {
+		case t.info.Status.Done():
+			// Canceled while queued
+		case t.canceled || errors.Is(result.Err, context.Canceled):
+			t.end(StatusCanceled, result.Result, "canceled")
+		case result.Err != nil:
+			t.end(StatusFailed, result.Result, result.Error)
+		case !result.Finished:
+			t.end(StatusFailed, result.Result, fmt.Sprintf("not finished after %d steps", result.Steps))
+		case !result.Success:
+			t.end(StatusFailed, result.Result, result.Result)
+		default:
+			t.end(StatusSucceeded, result.Result, "")
+		}
+	})
+}
+
+func (t *task) OnStepStart(ctx context.Context, step int, prompt string) {
+	t.update(func() {
+		t.info.Step = step
+		t.current(step)
+		t.emit(Event{Type: EventStep, Step: step})
+	})
+}
+
+func (t *task) OnScreenshot(ctx context.Context, step int, screenshot *definitions.Screenshot, currentApp string) {
+	var thumbnail string
+	if small, err := imageutil.Prepare(screenshot, definitions.ImageOptions{MaxLongEdge: thumbnailEdge, Format: definitions.ImageJPEG}); err == nil {
+		if data, err := imageutil.Bytes(small); err == nil {
+			thumbnail = "data:" + small.MimeType + ";base64," + base64.StdEncoding.EncodeToString(data)
+		}
+	}
+
+	t.update(func() {
+		t.screenshots[step] = screenshot
+		if len(t.screenshots) > t.maxScreenshots {
+			t.dropOldestScreenshot()
+		}
+		s := t.current(step)
+		s.App = currentApp
+		s.Screenshot = fmt.Sprintf("/api/tasks/%s/steps/%d/screenshot", t.info.ID, step)
+		t.emit(Event{Type: EventScreenshot, Step: step, App: currentApp, Thumbnail: thumbnail, Screenshot: s.Screenshot})
+	})
+}
+
+// dropOldestScreenshot also removes the dropped screenshot URL from the step and
+// its events; it must be called with the lock held.
+func (t *task) dropOldestScreenshot() {
+	for i := range t.steps {
+		s := &t.steps[i]
+		if _, ok := t.screenshots[s.Step]; ok {
+			delete(t.screenshots, s.Step)
+			s.Screenshot = ""
+			for j := range t.events {
+				if e := &t.events[j]; e.Type == EventScreenshot && e.Step == s.Step {
+					e.Screenshot = ""
+				}
+			}
+			return
+		}
+	}
+}
+
+func (t *task) OnThinkingDelta(ctx context.Context, step int, delta string) {
+	t.update(func() {
+		t.emit(Event{Type: EventThinkingDelta, Step: step, Thinking: delta})
+	})
+}
+
+func (t *task) OnModelResponse(ctx context.Context, step int, response *llm.ModelResponse) {
+	t.update(func() {
+		t.current(step).Thinking = response.Thinking
+		t.emit(Event{Type: EventThinking, Step: step, Thinking: response.Thinking})
+	})
+}
+
+func (t *task) OnActionExecuted(ctx context.Context, step int, action helper.Action, result helper.ActionResult) {
+	t.update(func() {
+		s := t.current(step)
+		s.Action, s.Success, s.Message = action, result.Success, result.Message
+		t.emit(Event{Type: EventAction, Step: step, Action: action, Result: &result})
+	})
+}
+
+func (t *task) OnError(ctx context.Context, step int, err error) {
+	t.update(func() {
+		t.current(step).Error = err.Error()
+		t.emit(Event{Type: EventError, Step: step, Error: err.Error()})
+	})
+}
@@ -15,3 +15,14 @@ func AnyToIntSlice(v any) []int {
 	}
 	return s
 }
+
+func AnyToInt(v any) int {
+	switch n := v.(type) {
+	case int:
+		return n
+	case float64:
+		return int(n)
+	default:
+		return 0
+	}
+}